@@ -1,54 +1,124 @@
 package main
 
 import (
+	"context"
+	"expense-split-wise/internal/cache"
 	"expense-split-wise/internal/config"
 	"expense-split-wise/internal/database"
 	"expense-split-wise/internal/handlers"
+	"expense-split-wise/internal/middleware"
 	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/repository"
 	"expense-split-wise/internal/services"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Timezones for analytics, without relying on the image's zoneinfo
 
 	"github.com/gin-gonic/gin"
 )
 
+// shutdownTimeout bounds how long the API waits for in-flight requests on shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	os.Exit(run())
+}
+
+// run starts the API server and blocks until it is stopped, returning the exit code:
+// non-zero if it failed to start. It returns only once its deferred cleanup has run.
+func run() int {
 	// Load configuration
 	cfg := config.Load()
 
 	// Initialize MongoDB
 	mongoDB, err := database.NewMongoClient(cfg.MongoURI, cfg.MongoDatabase)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		log.Printf("❌ Failed to connect to MongoDB: %v", err)
+		return 1
 	}
 	defer mongoDB.Close()
 
-	// Initialize Redis
+	// Initialize Redis. Only the Redis queue backend needs it to start; otherwise the
+	// cache falls back to the database and live events pause until Redis is back.
+	// Requests with an Idempotency-Key are rejected with 503 while it is down.
 	redisClient, err := database.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		if cfg.QueueBackend == queue.BackendRedis {
+			log.Printf("❌ Failed to connect to Redis: %v", err)
+			return 1
+		}
+		log.Printf("❌ Redis is unavailable, starting without it: %v", err)
 	}
 	defer redisClient.Close()
 
-	// Initialize RabbitMQ
-	rabbitmq, err := queue.NewRabbitMQClient(cfg.RabbitMQURL)
+	// Initialize the cache: Redis, in-process, or in-process in front of Redis
+	appCache, err := cache.Open(cfg.CacheBackend, redisClient.Client, cfg.CacheLocalSize, cfg.CacheLocalTTL)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		log.Printf("❌ Failed to open the cache: %v", err)
+		return 1
 	}
-	defer rabbitmq.Close()
+	defer appCache.Close()
 
-	// Declare the expense queue
-	if err := rabbitmq.DeclareQueue(cfg.ExpenseQueue); err != nil {
-		log.Fatalf("Failed to declare queue: %v", err)
+	// Initialize the queue broker, RabbitMQ or Redis Streams
+	broker, err := queue.Open(cfg.QueueBackend, cfg.RabbitMQURL, redisClient.Client, cfg.MaxDeliveries)
+	if err != nil {
+		log.Printf("❌ Failed to connect to the queue broker: %v", err)
+		return 1
 	}
+	defer broker.Close()
+
+	// Declare the expense and notification queues
+	for _, name := range []string{cfg.ExpenseQueue, cfg.NotificationQueue} {
+		if err := broker.DeclareQueue(name); err != nil {
+			log.Printf("❌ Failed to declare queue: %v", err)
+			return 1
+		}
+	}
+
+	// Initialize the repositories, stored in MongoDB or PostgreSQL
+	store, err := repository.Open(context.Background(), cfg.StorageBackend, mongoDB, cfg.PostgresURL)
+	if err != nil {
+		log.Printf("❌ Failed to open the %s store: %v", cfg.StorageBackend, err)
+		return 1
+	}
+	defer store.Close()
 
 	// Initialize services
-	groupService := services.NewGroupService(mongoDB)
-	expenseService := services.NewExpenseService(mongoDB, rabbitmq, cfg.ExpenseQueue)
-	balanceService := services.NewBalanceService(mongoDB, redisClient)
+	outboxService := services.NewOutboxService(store.Outbox, broker)
+	notificationService := services.NewNotificationService(store.Notifications, store.Groups, store.Expenses, outboxService, broker, cfg.NotificationQueue)
+	groupService := services.NewGroupService(store.Transactor, store.Groups, outboxService, notificationService)
+	expenseService := services.NewExpenseService(store.Transactor, store.Expenses, store.Groups, store.Balances, outboxService, cfg.ExpenseQueue)
+	eventService := services.NewEventService(store.Expenses, redisClient)
+	balanceService := services.NewBalanceService(store.Transactor, store.Expenses, store.Balances, appCache, eventService)
+	importService := services.NewImportService(store.Transactor, store.Expenses, groupService, outboxService, cfg.ExpenseQueue)
+	statementService := services.NewStatementService(store.Transactor, store.Statements, store.Expenses, groupService, expenseService, outboxService)
+	reportService := services.NewReportService(groupService, expenseService)
+	analyticsService := services.NewAnalyticsService(store.Expenses, appCache)
+	webhookService := services.NewWebhookService(store.Webhooks, store.Expenses)
+	budgetService := services.NewBudgetService(store.Budgets, store.Expenses, webhookService)
+
+	// Ensure the indexes used by expense search, balance updates, the outbox, statement uploads, webhook deliveries and budgets
+	if err := store.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("❌ Failed to create indexes: %v", err)
+		return 1
+	}
 
 	// Initialize handlers
 	groupHandler := handlers.NewGroupHandler(groupService)
 	expenseHandler := handlers.NewExpenseHandler(expenseService, balanceService)
+	exportHandler := handlers.NewExportHandler(groupService, expenseService, balanceService, cfg.DefaultCurrency)
+	importHandler := handlers.NewImportHandler(importService)
+	statementHandler := handlers.NewStatementHandler(statementService)
+	reportHandler := handlers.NewReportHandler(reportService, cfg.DefaultCurrency)
+	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	budgetHandler := handlers.NewBudgetHandler(budgetService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	eventHandler := handlers.NewEventHandler(eventService)
 
 	// Setup Gin router
 	router := gin.Default()
@@ -60,6 +130,7 @@ func main() {
 
 	// API routes
 	api := router.Group("/api/v1")
+	api.Use(middleware.Idempotency(redisClient, 24*time.Hour))
 	{
 		// Group routes
 		api.POST("/groups", groupHandler.CreateGroup)
@@ -68,14 +139,86 @@ func main() {
 		// Expense routes
 		api.POST("/groups/:id/expenses", expenseHandler.CreateExpense)
 		api.GET("/groups/:id/expenses", expenseHandler.GetExpenses)
+		api.PUT("/groups/:id/expenses/:expenseId", expenseHandler.UpdateExpense)
+		api.DELETE("/groups/:id/expenses/:expenseId", expenseHandler.DeleteExpense)
+		api.GET("/groups/:id/expenses/search", expenseHandler.SearchExpenses)
+		api.GET("/users/:user/expenses/search", expenseHandler.SearchUserExpenses)
 
 		// Balance routes
 		api.GET("/groups/:id/balances", expenseHandler.GetBalances)
+		api.POST("/groups/:id/balances/recalculate", expenseHandler.RecalculateBalances)
+		api.GET("/groups/:id/events", eventHandler.StreamEvents)
+
+		// Export routes
+		api.GET("/groups/:id/export.csv", exportHandler.ExportCSV)
+		api.GET("/groups/:id/export.ledger", exportHandler.ExportLedger)
+		api.GET("/groups/:id/export.beancount", exportHandler.ExportBeancount)
+
+		// Import routes
+		api.POST("/groups/:id/import/splitwise", importHandler.ImportSplitwise)
+
+		// Bank statement routes
+		api.POST("/groups/:id/members/:member/statements", statementHandler.UploadStatement)
+		api.GET("/groups/:id/statements/transactions", statementHandler.GetTransactions)
+		api.POST("/groups/:id/statements/proposals/accept", statementHandler.AcceptProposals)
+		api.POST("/groups/:id/statements/proposals/reject", statementHandler.RejectProposals)
+
+		// Report routes
+		api.GET("/groups/:id/members/:member/statement.pdf", reportHandler.MemberStatementPDF)
+		api.GET("/groups/:id/analytics", analyticsHandler.GetAnalytics)
+
+		// Budget routes
+		api.POST("/groups/:id/budgets", budgetHandler.CreateBudget)
+		api.GET("/groups/:id/budgets", budgetHandler.GetBudgets)
+		api.DELETE("/groups/:id/budgets/:budgetId", budgetHandler.DeleteBudget)
+
+		// Webhook routes
+		api.POST("/groups/:id/webhooks", webhookHandler.CreateWebhook)
+		api.GET("/groups/:id/webhooks", webhookHandler.GetWebhooks)
+		api.DELETE("/groups/:id/webhooks/:webhookId", webhookHandler.DeleteWebhook)
+		api.POST("/groups/:id/webhooks/:webhookId/enable", webhookHandler.EnableWebhook)
+		api.GET("/groups/:id/webhooks/:webhookId/deliveries", webhookHandler.GetDeliveries)
+
+		// Notification routes
+		api.GET("/users/:user/notifications", notificationHandler.GetPreferences)
+		api.PUT("/users/:user/notifications", notificationHandler.UpdatePreferences)
+	}
+
+	server := &http.Server{
+		Addr:    ":" + cfg.APIPort,
+		Handler: router,
 	}
+	// Event streams never finish on their own, so end them when shutdown starts
+	server.RegisterOnShutdown(eventHandler.Close)
 
 	// Start server
-	log.Printf("🚀 API Server starting on port %s", cfg.APIPort)
-	if err := router.Run(":" + cfg.APIPort); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("🚀 API Server starting on port %s", cfg.APIPort)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Wait for SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serverErr:
+		log.Printf("❌ Failed to start server: %v", err)
+		return 1
+	case <-ctx.Done():
 	}
+
+	// Stop accepting connections and let in-flight requests finish, up to the deadline
+	log.Println("🛑 Shutting down API server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("❌ Shutdown timed out, closing remaining connections: %v", err)
+		server.Close()
+	}
+
+	// Deferred calls close the store, queue broker, cache, Redis and MongoDB
+	log.Println("👋 API server stopped")
+	return 0
 }
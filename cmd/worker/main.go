@@ -1,47 +1,195 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/cache"
 	"expense-split-wise/internal/config"
 	"expense-split-wise/internal/database"
+	"expense-split-wise/internal/notify"
 	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/repository"
 	"expense-split-wise/internal/services"
 	"expense-split-wise/internal/worker"
+	"expvar"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Timezones for monthly budgets, without relying on the image's zoneinfo
 )
 
+// shutdownTimeout bounds how long the worker waits for in-flight messages on shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	os.Exit(run())
+}
+
+// run starts the worker and blocks until it is stopped, returning the exit code:
+// non-zero if a consumer failed. It returns only once its deferred cleanup has run.
+func run() int {
+	exitCode := 0
+
 	// Load configuration
 	cfg := config.Load()
 
+	// Check the email templates and digest schedule before connecting to anything
+	templates, err := notify.LoadTemplates(cfg.EmailTemplateDir)
+	if err != nil {
+		log.Printf("❌ Failed to load email templates: %v", err)
+		return 1
+	}
+	schedule, err := services.ParseDigestSchedule(cfg.DigestDay, cfg.DigestTime, cfg.DigestTimezone)
+	if err != nil {
+		log.Printf("❌ Invalid digest schedule: %v", err)
+		return 1
+	}
+
 	// Initialize MongoDB
 	mongoDB, err := database.NewMongoClient(cfg.MongoURI, cfg.MongoDatabase)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		log.Printf("❌ Failed to connect to MongoDB: %v", err)
+		return 1
 	}
 	defer mongoDB.Close()
 
-	// Initialize Redis
+	// Initialize Redis. Only the Redis queue backend needs it to start; otherwise the
+	// cache falls back to the database and live events pause until Redis is back.
 	redisClient, err := database.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		if cfg.QueueBackend == queue.BackendRedis {
+			log.Printf("❌ Failed to connect to Redis: %v", err)
+			return 1
+		}
+		log.Printf("❌ Redis is unavailable, starting without it: %v", err)
 	}
 	defer redisClient.Close()
 
-	// Initialize RabbitMQ
-	rabbitmq, err := queue.NewRabbitMQClient(cfg.RabbitMQURL)
+	// Initialize the cache: Redis, in-process, or in-process in front of Redis
+	appCache, err := cache.Open(cfg.CacheBackend, redisClient.Client, cfg.CacheLocalSize, cfg.CacheLocalTTL)
+	if err != nil {
+		log.Printf("❌ Failed to open the cache: %v", err)
+		return 1
+	}
+	defer appCache.Close()
+
+	// Initialize the queue broker, RabbitMQ or Redis Streams
+	broker, err := queue.Open(cfg.QueueBackend, cfg.RabbitMQURL, redisClient.Client, cfg.MaxDeliveries)
+	if err != nil {
+		log.Printf("❌ Failed to connect to the queue broker: %v", err)
+		return 1
+	}
+	defer broker.Close()
+
+	// Declare the queues published to, including the expense queue the outbox relay publishes to
+	for _, name := range []string{cfg.ExpenseQueue, cfg.NotificationQueue} {
+		if err := broker.DeclareQueue(name); err != nil {
+			log.Printf("❌ Failed to declare queue: %v", err)
+			return 1
+		}
+	}
+
+	// Initialize the repositories, stored in MongoDB or PostgreSQL
+	store, err := repository.Open(context.Background(), cfg.StorageBackend, mongoDB, cfg.PostgresURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		log.Printf("❌ Failed to open the %s store: %v", cfg.StorageBackend, err)
+		return 1
 	}
-	defer rabbitmq.Close()
+	defer store.Close()
 
 	// Initialize services
-	balanceService := services.NewBalanceService(mongoDB, redisClient)
+	eventService := services.NewEventService(store.Expenses, redisClient)
+	balanceService := services.NewBalanceService(store.Transactor, store.Expenses, store.Balances, appCache, eventService)
+	webhookService := services.NewWebhookService(store.Webhooks, store.Expenses)
+	budgetService := services.NewBudgetService(store.Budgets, store.Expenses, webhookService)
+	outboxService := services.NewOutboxService(store.Outbox, broker)
+	notificationService := services.NewNotificationService(store.Notifications, store.Groups, store.Expenses, outboxService, broker, cfg.NotificationQueue)
+
+	if err := store.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("❌ Failed to create indexes: %v", err)
+		return 1
+	}
+
+	// Stop on SIGINT or SIGTERM; background jobs and consumers all stop when ctx is cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	failed := make(chan error, 2)
+	consume := func(name string, start func(context.Context) error) {
+		wg.Go(func() {
+			if err := start(ctx); err != nil {
+				failed <- fmt.Errorf("%s: %w", name, err)
+			}
+		})
+	}
+
+	// Publish expense events the API saved but could not publish
+	outboxRelay := worker.NewOutboxRelay(outboxService, 5*time.Second)
+	wg.Go(func() { outboxRelay.Start(ctx) })
+
+	// Deliver webhooks in the background
+	webhookWorker := worker.NewWebhookWorker(webhookService, time.Second)
+	wg.Go(func() { webhookWorker.Start(ctx) })
+
+	// Email notifications
+	mailer := notify.NewMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+	notificationWorker := worker.NewNotificationWorker(broker, notificationService, mailer, templates, cfg.DefaultCurrency, cfg.NotificationQueue)
+
+	// Weekly digests, checked every few minutes
+	digestService := services.NewDigestService(store.Notifications, store.Groups, store.Expenses, balanceService, notificationService)
+	digestWorker := worker.NewDigestWorker(digestService, schedule, 5*time.Minute)
+	wg.Go(func() { digestWorker.Start(ctx) })
 
 	// Initialize and start worker
-	expenseWorker := worker.NewExpenseWorker(rabbitmq, balanceService, cfg.ExpenseQueue)
+	expenseWorker := worker.NewExpenseWorker(broker, balanceService, budgetService, webhookService, eventService, notificationService, cfg.ExpenseQueue, cfg.WorkerConcurrency, cfg.WorkerPrefetch)
+
+	// Serve throughput metrics as JSON at /debug/vars
+	expvar.Publish("expenseWorker", expvar.Func(func() interface{} {
+		return expenseWorker.Metrics().Snapshot()
+	}))
+	metricsServer := &http.Server{Addr: ":" + cfg.WorkerMetricsPort}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("❌ Metrics server failed: %v", err)
+		}
+	}()
 
 	log.Println("🚀 Worker starting...")
-	if err := expenseWorker.Start(); err != nil {
-		log.Fatalf("Worker failed: %v", err)
+	consume("notification worker", notificationWorker.Start)
+	consume("expense worker", expenseWorker.Start)
+
+	select {
+	case <-ctx.Done():
+		log.Println("🛑 Shutting down worker...")
+	case err := <-failed:
+		log.Printf("❌ Worker failed: %v", err)
+		exitCode = 1
 	}
+	stop()
+
+	// Let in-flight messages finish; any still unacknowledged at the deadline are redelivered
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(shutdownTimeout):
+		log.Printf("❌ Shutdown timed out after %s; unacknowledged messages will be redelivered", shutdownTimeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	metricsServer.Shutdown(shutdownCtx)
+
+	// Deferred calls close the store, queue broker, cache, Redis and MongoDB
+	log.Println("👋 Worker stopped")
+	return exitCode
 }
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache backends selectable with Open
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendTiered = "tiered"
+)
+
+var (
+	// ErrMiss is returned by Cache.Get when a key is not cached
+	ErrMiss = errors.New("cache: miss")
+	// ErrUnavailable is returned while the cache's store cannot be reached
+	ErrUnavailable = errors.New("cache: unavailable")
+)
+
+// Cache keeps values that can be recomputed, such as balances and analytics. Callers
+// treat any error as a miss and read from the database, so an outage of the cache
+// slows reads down rather than failing them.
+type Cache interface {
+	// Get returns the value of key, or ErrMiss if it is not cached
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set caches value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys from the cache
+	Delete(ctx context.Context, keys ...string) error
+	Close()
+}
+
+var (
+	_ Cache = (*RedisCache)(nil)
+	_ Cache = (*LRUCache)(nil)
+	_ Cache = (*TieredCache)(nil)
+)
+
+// Open returns the cache of the given backend. The Redis and tiered backends store
+// values through redisClient; the memory and tiered backends keep up to localSize
+// values in process for at most localTTL, which bounds how stale they can get when
+// another process changes them.
+func Open(backend string, redisClient *redis.Client, localSize int, localTTL time.Duration) (Cache, error) {
+	switch backend {
+	case BackendRedis:
+		return NewRedisCache(redisClient), nil
+	case BackendMemory:
+		return NewLRUCache(localSize, localTTL), nil
+	case BackendTiered:
+		return NewTieredCache(NewLRUCache(localSize, localTTL), NewRedisCache(redisClient)), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", backend)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// expectValue fails unless c holds want under key
+func expectValue(t *testing.T, c Cache, key, want string) {
+	t.Helper()
+
+	value, err := c.Get(context.Background(), key)
+	if err != nil || string(value) != want {
+		t.Errorf("Get(%q) = %q, %v, want %q", key, value, err, want)
+	}
+}
+
+// expectMiss fails unless c misses key
+func expectMiss(t *testing.T, c Cache, key string) {
+	t.Helper()
+
+	if value, err := c.Get(context.Background(), key); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get(%q) = %q, %v, want ErrMiss", key, value, err)
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2, 0)
+
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), 0)
+	expectValue(t, c, "a", "1") // Now b is the least recently used
+	c.Set(ctx, "c", []byte("3"), 0)
+
+	expectMiss(t, c, "b")
+	expectValue(t, c, "a", "1")
+	expectValue(t, c, "c", "3")
+
+	// Replacing a value keeps one entry for the key
+	c.Set(ctx, "a", []byte("4"), 0)
+	expectValue(t, c, "a", "4")
+	expectValue(t, c, "c", "3")
+}
+
+func TestLRUCacheExpiresValues(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(10, 50*time.Millisecond)
+
+	c.Set(ctx, "short", []byte("1"), 10*time.Millisecond)
+	c.Set(ctx, "capped", []byte("2"), time.Hour) // Kept only for the maximum TTL
+	c.Set(ctx, "default", []byte("3"), 0)
+	expectValue(t, c, "capped", "2")
+
+	time.Sleep(20 * time.Millisecond)
+	expectMiss(t, c, "short")
+	expectValue(t, c, "capped", "2")
+
+	time.Sleep(40 * time.Millisecond)
+	expectMiss(t, c, "capped")
+	expectMiss(t, c, "default")
+}
+
+func TestLRUCacheCopiesValues(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(10, 0)
+
+	value := []byte("abc")
+	c.Set(ctx, "key", value, 0)
+	value[0] = 'x'
+	got, _ := c.Get(ctx, "key")
+	got[1] = 'y'
+	expectValue(t, c, "key", "abc")
+}
+
+func TestLRUCacheDeleteAndPurge(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(10, 0)
+
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(ctx, key, []byte(key), 0)
+	}
+	c.Delete(ctx, "a", "b", "missing")
+	expectMiss(t, c, "a")
+	expectMiss(t, c, "b")
+	expectValue(t, c, "c", "c")
+
+	c.Purge()
+	expectMiss(t, c, "c")
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisRetryInterval is how long RedisCache leaves Redis alone after a failure
+	// before trying it again
+	redisRetryInterval = 5 * time.Second
+	// maxPendingDeletes bounds the keys remembered for deletion while Redis is unreachable
+	maxPendingDeletes = 100000
+	// deleteBatchSize is how many pending keys are deleted per DEL
+	deleteBatchSize = 1000
+)
+
+// RedisCache is a Cache in Redis, shared by every process. After a failure it stops
+// calling Redis for redisRetryInterval, failing fast with ErrUnavailable so callers
+// go straight to the database instead of waiting on timeouts. Keys it fails to set
+// or delete in the meantime may hold stale values, so they are deleted before Redis
+// is used again.
+type RedisCache struct {
+	client *redis.Client
+
+	mu         sync.Mutex
+	down       bool
+	retryAt    time.Time           // When to try Redis again while down
+	recovering bool                // Whether a caller is deleting the pending keys
+	pending    map[string]struct{} // Keys to delete once Redis is back
+	overflowed bool                // Whether keys were dropped from pending
+}
+
+// NewRedisCache returns a cache storing values through client. It does not own client.
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{
+		client:  client,
+		pending: make(map[string]struct{}),
+	}
+}
+
+// Get returns the value of key, or ErrMiss if it is not cached
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if !c.available(ctx) {
+		return nil, ErrUnavailable
+	}
+
+	value, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		c.failed(ctx, err)
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set caches value under key for ttl; zero keeps it until it is deleted
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !c.available(ctx) {
+		c.stale(key)
+		return ErrUnavailable
+	}
+
+	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		if c.failed(ctx, err) {
+			c.stale(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes keys from the cache
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if !c.available(ctx) {
+		c.stale(keys...)
+		return ErrUnavailable
+	}
+
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		if c.failed(ctx, err) {
+			c.stale(keys...)
+		}
+		return err
+	}
+	return nil
+}
+
+// Close does nothing; the Redis client is left for its owner to close
+func (c *RedisCache) Close() {}
+
+// available reports whether Redis should be called. Once the retry interval of an
+// outage has passed, the first caller deletes the pending keys; Redis is used again
+// if that succeeds.
+func (c *RedisCache) available(ctx context.Context) bool {
+	c.mu.Lock()
+	if !c.down {
+		c.mu.Unlock()
+		return true
+	}
+	if c.recovering || time.Now().Before(c.retryAt) {
+		c.mu.Unlock()
+		return false
+	}
+	c.recovering = true
+	keys := make([]string, 0, len(c.pending))
+	for key := range c.pending {
+		keys = append(keys, key)
+	}
+	overflowed := c.overflowed
+	c.mu.Unlock()
+
+	err := c.client.Ping(ctx).Err()
+	for start := 0; err == nil && start < len(keys); start += deleteBatchSize {
+		err = c.client.Del(ctx, keys[start:min(start+deleteBatchSize, len(keys))]...).Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recovering = false
+	if err != nil {
+		c.retryAt = time.Now().Add(redisRetryInterval)
+		return false
+	}
+
+	for _, key := range keys {
+		delete(c.pending, key)
+	}
+	if len(c.pending) > 0 {
+		// Keys went stale while these were deleted; the next caller deletes them
+		c.retryAt = time.Now()
+		return false
+	}
+	c.down = false
+	c.overflowed = false
+	if overflowed {
+		log.Println("✅ Redis cache is reachable again; some values changed during the outage may be stale until they expire")
+	} else {
+		log.Println("✅ Redis cache is reachable again")
+	}
+	return true
+}
+
+// failed marks Redis down after an error reaching it, and reports whether it did.
+// Errors replied by Redis, and those of cancelled calls, do not say Redis is down.
+func (c *RedisCache) failed(ctx context.Context, err error) bool {
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) || ctx.Err() != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.down {
+		log.Printf("❌ Redis cache unavailable, reading from the database until it is back: %v", err)
+	}
+	c.down = true
+	c.retryAt = time.Now().Add(redisRetryInterval)
+	return true
+}
+
+// stale remembers keys whose value in Redis may be out of date, to delete them once
+// Redis is reachable
+func (c *RedisCache) stale(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if len(c.pending) >= maxPendingDeletes {
+			c.overflowed = true
+			return
+		}
+		c.pending[key] = struct{}{}
+	}
+}
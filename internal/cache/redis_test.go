@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/testutil"
+	"testing"
+	"time"
+)
+
+// retryNow ends the wait before c tries Redis again
+func retryNow(c *RedisCache) {
+	c.mu.Lock()
+	c.retryAt = time.Now()
+	c.mu.Unlock()
+}
+
+func TestRedisCacheSurvivesOutage(t *testing.T) {
+	ctx := context.Background()
+	redisClient, server := testutil.NewRedis(t)
+	c := NewRedisCache(redisClient.Client)
+
+	c.Set(ctx, "balances", []byte("old"), 0)
+	c.Set(ctx, "analytics", []byte("old"), 0)
+	expectValue(t, c, "balances", "old")
+
+	// The first failure reaches Redis; later calls fail fast without trying
+	server.Close()
+	if _, err := c.Get(ctx, "balances"); err == nil || errors.Is(err, ErrMiss) {
+		t.Fatalf("Get = %v while Redis is down, want an error other than a miss", err)
+	}
+	for _, err := range []error{
+		func() error { _, err := c.Get(ctx, "balances"); return err }(),
+		c.Set(ctx, "balances", []byte("new"), 0),
+		c.Delete(ctx, "analytics"),
+	} {
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("call while down = %v, want ErrUnavailable", err)
+		}
+	}
+
+	// Redis comes back with the values from before the outage, now stale
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "balances"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get = %v before the retry interval, want ErrUnavailable", err)
+	}
+
+	// Once it is tried again, the keys changed during the outage are deleted first
+	retryNow(c)
+	expectMiss(t, c, "balances")
+	expectMiss(t, c, "analytics")
+	if err := c.Set(ctx, "balances", []byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, c, "balances", "new")
+}
+
+func TestRedisCacheStaysDownWhileRedisIs(t *testing.T) {
+	ctx := context.Background()
+	redisClient, server := testutil.NewRedis(t)
+	c := NewRedisCache(redisClient.Client)
+
+	server.Close()
+	c.Get(ctx, "balances")
+	c.Delete(ctx, "balances")
+
+	// A failed recovery keeps the pending deletes for the next one
+	retryNow(c)
+	if _, err := c.Get(ctx, "balances"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get = %v with Redis still down, want ErrUnavailable", err)
+	}
+	c.mu.Lock()
+	_, pending := c.pending["balances"]
+	c.mu.Unlock()
+	if !pending {
+		t.Error("pending delete dropped by a failed recovery")
+	}
+}
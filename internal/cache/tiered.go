@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel is the Redis channel on which tiered caches announce the keys
+// they changed, so the others drop their local copies
+const invalidationChannel = "cache:invalidate"
+
+// TieredCache keeps recently used values in a local LRU cache in front of Redis. Keys
+// changed through any tiered cache are dropped from the local caches of the others
+// over Redis pub/sub. While Redis is unreachable the local cache keeps serving, and
+// since changes are not announced then, the local cache's maximum TTL bounds how
+// stale its values get; it is emptied when the subscription comes back.
+type TieredCache struct {
+	local  *LRUCache
+	remote *RedisCache
+	origin string // Identifies this cache's announcements, which it ignores
+
+	pubsub *redis.PubSub
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once listen returns
+}
+
+// invalidation is an announcement of changed keys
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// NewTieredCache returns a cache keeping values of remote in local, and starts
+// listening for keys changed by other processes
+func NewTieredCache(local *LRUCache, remote *RedisCache) *TieredCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &TieredCache{
+		local:  local,
+		remote: remote,
+		origin: rand.Text(),
+		pubsub: remote.client.Subscribe(ctx, invalidationChannel),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go c.listen(ctx)
+	return c
+}
+
+// Get returns the value of key from the local cache, or from Redis and keeps it locally
+func (c *TieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := c.local.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, err := c.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.local.Set(ctx, key, value, 0)
+	return value, nil
+}
+
+// Set caches value under key in both tiers and announces the change
+func (c *TieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.local.Set(ctx, key, value, ttl)
+	if err := c.remote.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	c.announce(ctx, key)
+	return nil
+}
+
+// Delete removes keys from both tiers and announces the change
+func (c *TieredCache) Delete(ctx context.Context, keys ...string) error {
+	c.local.Delete(ctx, keys...)
+	if err := c.remote.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	c.announce(ctx, keys...)
+	return nil
+}
+
+// Close stops listening for changes. The Redis client is left for its owner to close.
+func (c *TieredCache) Close() {
+	c.cancel()
+	c.pubsub.Close() // Interrupts a pending receive
+	<-c.done
+}
+
+// announce tells the other tiered caches to drop their copies of keys
+func (c *TieredCache) announce(ctx context.Context, keys ...string) {
+	message, _ := json.Marshal(invalidation{Origin: c.origin, Keys: keys})
+	if err := c.remote.client.Publish(ctx, invalidationChannel, message).Err(); err != nil {
+		log.Printf("❌ Failed to announce cache invalidation: %v", err)
+	}
+}
+
+// listen drops the keys other caches announce from the local cache. Announcements
+// may have been missed whenever the subscription is (re)established, so the local
+// cache is emptied then.
+func (c *TieredCache) listen(ctx context.Context) {
+	defer close(c.done)
+
+	for {
+		received, err := c.pubsub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// The subscription is re-established on the next receive
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(redisRetryInterval):
+			}
+			continue
+		}
+
+		switch msg := received.(type) {
+		case *redis.Subscription:
+			c.local.Purge()
+		case *redis.Message:
+			var announced invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &announced); err != nil {
+				log.Printf("❌ Invalid cache invalidation: %v", err)
+				continue
+			}
+			if announced.Origin != c.origin {
+				c.local.Delete(ctx, announced.Keys...)
+			}
+		}
+	}
+}
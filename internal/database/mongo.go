@@ -50,3 +50,19 @@ func (m *MongoClient) Close() error {
 func (m *MongoClient) Collection(name string) *mongo.Collection {
 	return m.Database.Collection(name)
 }
+
+// WithTransaction runs fn in a transaction, retrying it on transient errors.
+// Operations in fn must use the session context it is given. Transactions need
+// MongoDB to run as a replica set (a single node is enough).
+func (m *MongoClient) WithTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	session, err := m.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresClient wraps a PostgreSQL connection pool
+type PostgresClient struct {
+	Pool *pgxpool.Pool
+}
+
+// NewPostgresClient initializes and returns a PostgreSQL client
+func NewPostgresClient(url string) (*PostgresClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ping to verify connection
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	log.Println("✅ Connected to PostgreSQL")
+
+	return &PostgresClient{Pool: pool}, nil
+}
+
+// Close closes every connection in the pool
+func (p *PostgresClient) Close() {
+	p.Pool.Close()
+}
@@ -12,7 +12,8 @@ type RedisClient struct {
 	Client *redis.Client
 }
 
-// NewRedisClient initializes and returns a Redis client
+// NewRedisClient initializes and returns a Redis client. If Redis cannot be reached
+// the client is returned along with the error, and connects once Redis is back.
 func NewRedisClient(addr, password string, db int) (*RedisClient, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
@@ -23,7 +24,7 @@ func NewRedisClient(addr, password string, db int) (*RedisClient, error) {
 	// Ping to verify connection
 	ctx := context.Background()
 	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, err
+		return &RedisClient{Client: client}, err
 	}
 
 	log.Println("✅ Connected to Redis")
@@ -0,0 +1,152 @@
+package export
+
+import (
+	"encoding/csv"
+	"expense-split-wise/internal/models"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CSVLayout selects how expense rows are laid out
+type CSVLayout string
+
+const (
+	// LayoutLong writes one row per expense with participants in a single column
+	LayoutLong CSVLayout = "long"
+	// LayoutWide writes one column per member holding that member's net change for the expense
+	LayoutWide CSVLayout = "wide"
+)
+
+// CSVWriter writes a group's expenses followed by a balances section
+type CSVWriter struct {
+	w       *csv.Writer
+	layout  CSVLayout
+	members []string
+}
+
+// NewCSVWriter returns a CSVWriter; members sets the column order for the wide layout
+func NewCSVWriter(w io.Writer, layout CSVLayout, members []string) *CSVWriter {
+	return &CSVWriter{
+		w:       csv.NewWriter(w),
+		layout:  layout,
+		members: members,
+	}
+}
+
+// WriteHeader writes the header row of the expenses section
+func (e *CSVWriter) WriteHeader() error {
+	if e.layout == LayoutWide {
+		header := []string{"date", "description", "payer", "amount", "category", "currency"}
+		for _, member := range e.members {
+			header = append(header, escapeCell(member))
+		}
+		return e.w.Write(header)
+	}
+
+	return e.w.Write([]string{"date", "description", "payer", "amount", "participants", "share", "category", "currency"})
+}
+
+// WriteExpense writes a single expense row
+func (e *CSVWriter) WriteExpense(expense *models.Expense) error {
+	participants := expense.Participants()
+	share := expense.Amount / float64(len(participants))
+	date := expense.CreatedAt.Format("2006-01-02")
+
+	if e.layout == LayoutWide {
+		// Net change per member: positive = paid more than their share
+		net := make(map[string]float64)
+		net[expense.PaidBy] += expense.Amount
+		for _, member := range participants {
+			net[member] -= share
+		}
+
+		row := []string{date, escapeCell(expense.Description), escapeCell(expense.PaidBy), formatAmount(expense.Amount), escapeCell(expense.Category), escapeCell(expense.Currency)}
+		for _, member := range e.members {
+			if amount, ok := net[member]; ok {
+				row = append(row, formatAmount(amount))
+			} else {
+				row = append(row, "")
+			}
+		}
+		return e.w.Write(row)
+	}
+
+	return e.w.Write([]string{
+		date,
+		escapeCell(expense.Description),
+		escapeCell(expense.PaidBy),
+		formatAmount(expense.Amount),
+		escapeCell(strings.Join(participants, ";")),
+		formatAmount(share),
+		escapeCell(expense.Category),
+		escapeCell(expense.Currency),
+	})
+}
+
+// WriteBalances writes the balances section, separated from the expenses by a blank row
+func (e *CSVWriter) WriteBalances(balances map[string]float64) error {
+	if err := e.w.Write([]string{}); err != nil {
+		return err
+	}
+	if err := e.w.Write([]string{"member", "balance"}); err != nil {
+		return err
+	}
+
+	for _, member := range MemberColumns(e.members, balances) {
+		if err := e.w.Write([]string{escapeCell(member), formatAmount(balances[member])}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes any buffered rows to the underlying writer
+func (e *CSVWriter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// MemberColumns returns the group members in order followed by any other names
+// present in balances or others (e.g. removed members), sorted
+func MemberColumns(members []string, balances map[string]float64, others ...string) []string {
+	seen := make(map[string]bool, len(members))
+	columns := make([]string, 0, len(members))
+	for _, member := range members {
+		if !seen[member] {
+			seen[member] = true
+			columns = append(columns, member)
+		}
+	}
+
+	var extra []string
+	for member := range balances {
+		if !seen[member] {
+			seen[member] = true
+			extra = append(extra, member)
+		}
+	}
+	for _, member := range others {
+		if !seen[member] {
+			seen[member] = true
+			extra = append(extra, member)
+		}
+	}
+	sort.Strings(extra)
+
+	return append(columns, extra...)
+}
+
+// escapeCell prefixes text that a spreadsheet would run as a formula with a quote
+func escapeCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,88 @@
+package export
+
+import (
+	"bytes"
+	"expense-split-wise/internal/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testDate = time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+
+// writeCSV writes expenses and balances with a CSVWriter and returns the output
+func writeCSV(t *testing.T, layout CSVLayout, members []string, expenses []models.Expense, balances map[string]float64) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := NewCSVWriter(&buf, layout, members)
+	if err := writer.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range expenses {
+		if err := writer.WriteExpense(&expenses[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.WriteBalances(balances); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCSVWriterLongLayout(t *testing.T) {
+	expenses := []models.Expense{
+		{Description: "Dinner, drinks", Amount: 90, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol"}, Category: "Food", Currency: "EUR", CreatedAt: testDate},
+		{Description: "=HYPERLINK(\"http://evil\")", Amount: 10, PaidBy: "@bob", SplitBetween: []string{"alice", "@bob"}, Category: "+Misc", CreatedAt: testDate},
+		{Description: "-refund", Amount: -20, PaidBy: "carol", SplitBetween: []string{"alice", "carol"}, CreatedAt: testDate},
+		// Split between nobody: the payer's own
+		{Description: "Snacks", Amount: 7.5, PaidBy: "bob", CreatedAt: testDate},
+	}
+
+	got := writeCSV(t, LayoutLong, []string{"alice", "@bob", "carol"}, expenses, map[string]float64{"alice": 50, "@bob": -5, "carol": -45})
+	want := `date,description,payer,amount,participants,share,category,currency
+2024-03-05,"Dinner, drinks",alice,90.00,alice;bob;carol,30.00,Food,EUR
+2024-03-05,"'=HYPERLINK(""http://evil"")",'@bob,10.00,alice;@bob,5.00,'+Misc,
+2024-03-05,'-refund,carol,-20.00,alice;carol,-10.00,,
+2024-03-05,Snacks,bob,7.50,bob,7.50,,
+
+member,balance
+alice,50.00
+'@bob,-5.00
+carol,-45.00
+`
+	if got != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCSVWriterWideLayout(t *testing.T) {
+	expenses := []models.Expense{
+		{Description: "Taxi", Amount: 30, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "dave"}, CreatedAt: testDate},
+		{Description: "Snacks", Amount: 4, PaidBy: "bob", CreatedAt: testDate},
+	}
+	balances := map[string]float64{"alice": 20, "bob": -10, "dave": -10}
+
+	// dave has left the group, so only the expenses name him
+	members := MemberColumns([]string{"alice", "bob"}, nil, "dave", "alice")
+	if want := []string{"alice", "bob", "dave"}; !slices.Equal(members, want) {
+		t.Fatalf("MemberColumns = %v, want %v", members, want)
+	}
+
+	got := writeCSV(t, LayoutWide, members, expenses, balances)
+	want := `date,description,payer,amount,category,currency,alice,bob,dave
+2024-03-05,Taxi,alice,30.00,,,20.00,-10.00,-10.00
+2024-03-05,Snacks,bob,4.00,,,,0.00,
+
+member,balance
+alice,20.00
+bob,-10.00
+dave,-10.00
+`
+	if got != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+package export
+
+import (
+	"expense-split-wise/internal/models"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// JournalFormat selects the plain-text accounting format
+type JournalFormat string
+
+const (
+	FormatLedger    JournalFormat = "ledger"
+	FormatBeancount JournalFormat = "beancount"
+)
+
+// JournalOptions configures account naming for a journal export
+type JournalOptions struct {
+	Format JournalFormat
+	// MemberAccounts overrides the account of a member (default "Liabilities:Members:<Member>")
+	MemberAccounts map[string]string
+	// CategoryAccounts overrides the expense account of a category (default "Expenses:<Category>")
+	CategoryAccounts map[string]string
+	// Commodity is used for expenses without a currency
+	Commodity string
+}
+
+// accountComponent matches one colon-separated component of an account name
+var accountComponent = regexp.MustCompile(`^[A-Z][A-Za-z0-9-]*$`)
+
+// accountRoots are the top-level accounts beancount allows
+var accountRoots = []string{"Assets", "Liabilities", "Equity", "Income", "Expenses"}
+
+// commodityName matches a commodity valid in both ledger and beancount ("EUR", "BTC")
+var commodityName = regexp.MustCompile(`^[A-Z][A-Z0-9'._-]{0,22}[A-Z0-9]$`)
+
+// Validate checks that every account override is a valid account name under one
+// of the beancount roots and that the commodity is valid
+func (o JournalOptions) Validate() error {
+	for _, overrides := range []map[string]string{o.MemberAccounts, o.CategoryAccounts} {
+		for name, account := range overrides {
+			components := strings.Split(account, ":")
+			if !slices.Contains(accountRoots, components[0]) {
+				return fmt.Errorf("invalid account %q for %q: must start with one of %s", account, name, strings.Join(accountRoots, ", "))
+			}
+			for _, component := range components[1:] {
+				if !accountComponent.MatchString(component) {
+					return fmt.Errorf("invalid account %q for %q", account, name)
+				}
+			}
+		}
+	}
+	if !commodityName.MatchString(o.Commodity) {
+		return fmt.Errorf("invalid commodity %q", o.Commodity)
+	}
+	return nil
+}
+
+// JournalWriter renders expenses as a ledger-cli or beancount journal.
+//
+// Every member has an account for what the group owes them: the payer's account is
+// credited with the amount and each participant's share is booked to their own
+// sub-account of the category's expense account. Settlements (models.CategoryPayment)
+// move money between the member accounts directly. A member's entry in GetBalances
+// is therefore the negated sum of their postings to both.
+type JournalWriter struct {
+	w      io.Writer
+	opts   JournalOptions
+	opened map[string]bool
+
+	// Running totals in cents per member, used by Verify
+	totals   map[string]int64
+	postings map[string]int
+}
+
+// NewJournalWriter returns a JournalWriter writing to w
+func NewJournalWriter(w io.Writer, opts JournalOptions) *JournalWriter {
+	return &JournalWriter{
+		w:        w,
+		opts:     opts,
+		opened:   make(map[string]bool),
+		totals:   make(map[string]int64),
+		postings: make(map[string]int),
+	}
+}
+
+type posting struct {
+	member  string
+	account string
+	cents   int64
+}
+
+// WriteExpense writes one transaction for the expense
+func (j *JournalWriter) WriteExpense(expense *models.Expense) error {
+	commodity := expense.Currency
+	if commodity == "" {
+		commodity = j.opts.Commodity
+	}
+
+	settlement := expense.Category == models.CategoryPayment
+	amount := toCents(expense.Amount)
+
+	postings := []posting{{member: expense.PaidBy, account: j.memberAccount(expense.PaidBy), cents: -amount}}
+	participants := expense.Participants()
+	for i, share := range splitCents(amount, len(participants)) {
+		member := participants[i]
+		account := j.memberAccount(member)
+		if !settlement {
+			account = j.categoryAccount(expense.Category) + ":" + accountName(member)
+		}
+		postings = append(postings, posting{member: member, account: account, cents: share})
+	}
+
+	date := expense.CreatedAt.Format("2006-01-02")
+	var b strings.Builder
+
+	if j.opts.Format == FormatBeancount {
+		// Beancount requires every account to be opened before it is used
+		for _, p := range postings {
+			if !j.opened[p.account] {
+				j.opened[p.account] = true
+				fmt.Fprintf(&b, "%s open %s\n", date, p.account)
+			}
+		}
+		fmt.Fprintf(&b, "%s * %s %s\n", date, beancountString(expense.PaidBy), beancountString(expense.Description))
+		fmt.Fprintf(&b, "  expense_id: %s\n", beancountString(expense.ID.Hex()))
+		if expense.Category != "" {
+			fmt.Fprintf(&b, "  category: %s\n", beancountString(expense.Category))
+		}
+	} else {
+		fmt.Fprintf(&b, "%s * %s\n", date, strings.ReplaceAll(expense.Description, "\n", " "))
+		fmt.Fprintf(&b, "    ; Payee: %s\n", expense.PaidBy)
+		fmt.Fprintf(&b, "    ; ExpenseID: %s\n", expense.ID.Hex())
+		if expense.Category != "" {
+			fmt.Fprintf(&b, "    ; Category: %s\n", expense.Category)
+		}
+	}
+
+	for _, p := range postings {
+		fmt.Fprintf(&b, "    %-40s %12s %s\n", p.account, formatCents(p.cents), commodity)
+		j.totals[p.member] -= p.cents
+		j.postings[p.member]++
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(j.w, b.String())
+	return err
+}
+
+// Verify checks that the member account balances of everything written so far
+// match balances (as returned by BalanceService.GetBalances). Journal amounts are
+// rounded to cents, so each posting may differ from the exact share by under a cent.
+func (j *JournalWriter) Verify(balances map[string]float64) error {
+	members := make(map[string]bool)
+	for member := range balances {
+		members[member] = true
+	}
+	for member := range j.totals {
+		members[member] = true
+	}
+
+	var mismatches []string
+	for member := range members {
+		journal := float64(j.totals[member]) / 100
+		tolerance := 0.01*float64(j.postings[member]) + 1e-9
+		if math.Abs(journal-balances[member]) > tolerance {
+			mismatches = append(mismatches, fmt.Sprintf("%s: journal %.2f, balances %.2f", member, journal, balances[member]))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("journal does not match balances: %s", strings.Join(mismatches, "; "))
+	}
+
+	return nil
+}
+
+func (j *JournalWriter) memberAccount(member string) string {
+	if account, ok := j.opts.MemberAccounts[member]; ok {
+		return account
+	}
+	return "Liabilities:Members:" + accountName(member)
+}
+
+func (j *JournalWriter) categoryAccount(category string) string {
+	if account, ok := j.opts.CategoryAccounts[category]; ok {
+		return account
+	}
+	if category == "" {
+		return "Expenses:Uncategorized"
+	}
+	return "Expenses:" + accountName(category)
+}
+
+// accountName turns free text into an account name component valid in both
+// ledger and beancount: capitalised words of letters and digits ("goa trip" -> "GoaTrip")
+func accountName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	account := b.String()
+	if account == "" || !unicode.IsLetter(rune(account[0])) {
+		account = "X" + account
+	}
+	return account
+}
+
+func beancountString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(s) + `"`
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// splitCents splits amount into n shares that sum exactly to amount,
+// giving the leftover cents to the first shares
+func splitCents(amount int64, n int) []int64 {
+	sign := int64(1)
+	if amount < 0 {
+		sign, amount = -1, -amount
+	}
+
+	shares := make([]int64, n)
+	for i := range shares {
+		shares[i] = amount / int64(n)
+		if int64(i) < amount%int64(n) {
+			shares[i]++
+		}
+		shares[i] *= sign
+	}
+	return shares
+}
+
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,196 @@
+package export
+
+import (
+	"bytes"
+	"expense-split-wise/internal/models"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// journalExpenses returns expenses covering every kind of transaction a journal holds
+func journalExpenses() []models.Expense {
+	id := func(hex string) primitive.ObjectID {
+		oid, _ := primitive.ObjectIDFromHex(hex)
+		return oid
+	}
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 18, 30, 0, 0, time.UTC) }
+
+	return []models.Expense{
+		{ID: id("65e700000000000000000001"), Description: "Dinner", Amount: 100, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol"}, Category: "food", Currency: "EUR", CreatedAt: day(1)},
+		{ID: id("65e700000000000000000002"), Description: `Museum "tickets"`, Amount: 36, PaidBy: "bob", SplitBetween: []string{"bob", "carol"}, Currency: "EUR", CreatedAt: day(2)},
+		{ID: id("65e700000000000000000003"), Description: "Refund", Amount: -10.01, PaidBy: "carol", SplitBetween: []string{"alice", "bob", "carol"}, Category: "food", Currency: "EUR", CreatedAt: day(3)},
+		{ID: id("65e700000000000000000004"), Description: "Snacks", Amount: 4.5, PaidBy: "carol", Category: "food", CreatedAt: day(4)},
+		{ID: id("65e700000000000000000005"), Description: "Settle up", Amount: 20, PaidBy: "bob", SplitBetween: []string{"alice"}, Category: models.CategoryPayment, Currency: "EUR", CreatedAt: day(5)},
+	}
+}
+
+// journalBalances are the balances GetBalances returns for journalExpenses
+var journalBalances = map[string]float64{
+	"alice": 100 - 100.0/3 + 10.01/3 - 20,
+	"bob":   -100.0/3 + 36 - 18 + 10.01/3 + 20,
+	"carol": -100.0/3 - 18 - 10.01 + 10.01/3,
+}
+
+func TestJournalWriterGolden(t *testing.T) {
+	opts := JournalOptions{
+		MemberAccounts:   map[string]string{"carol": "Assets:Friends:Carol"},
+		CategoryAccounts: map[string]string{"food": "Expenses:Dining"},
+		Commodity:        "USD",
+	}
+
+	for _, format := range []JournalFormat{FormatLedger, FormatBeancount} {
+		t.Run(string(format), func(t *testing.T) {
+			opts.Format = format
+			var buf bytes.Buffer
+			writer := NewJournalWriter(&buf, opts)
+			expenses := journalExpenses()
+			for i := range expenses {
+				if err := writer.WriteExpense(&expenses[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Verify(journalBalances); err != nil {
+				t.Error(err)
+			}
+
+			checkJournal(t, format, buf.String())
+
+			golden := filepath.Join("testdata", "journal."+string(format))
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Errorf("journal =\n%s\nwant (%s)\n%s", got, golden, want)
+			}
+		})
+	}
+}
+
+// journalOpen matches a beancount open directive
+var journalOpen = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} open (\S+)$`)
+
+// journalPosting matches a posting line: account, amount and commodity
+var journalPosting = regexp.MustCompile(`^    (\S+)\s+(-?\d+\.\d{2}) ([A-Z]+)$`)
+
+// checkJournal checks the rules ledger and beancount enforce: every transaction
+// balances in each commodity, accounts sit under a beancount root and, for
+// beancount, are opened before they are used
+func checkJournal(t *testing.T, format JournalFormat, journal string) {
+	t.Helper()
+
+	opened := make(map[string]bool)
+	for _, transaction := range strings.Split(strings.TrimSpace(journal), "\n\n") {
+		sums := make(map[string]int64)
+		postings := 0
+		for _, line := range strings.Split(transaction, "\n") {
+			if open := journalOpen.FindStringSubmatch(line); open != nil {
+				opened[open[1]] = true
+				continue
+			}
+			match := journalPosting.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			postings++
+
+			account := match[1]
+			if !slices.Contains(accountRoots, strings.Split(account, ":")[0]) {
+				t.Errorf("account %s is not under a beancount root", account)
+			}
+			if format == FormatBeancount && !opened[account] {
+				t.Errorf("account %s used before it is opened", account)
+			}
+			cents, err := strconv.ParseInt(strings.Replace(match[2], ".", "", 1), 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sums[match[3]] += cents
+		}
+
+		if postings < 2 {
+			t.Errorf("transaction has %d postings:\n%s", postings, transaction)
+		}
+		for commodity, sum := range sums {
+			if sum != 0 {
+				t.Errorf("transaction is off by %d cents of %s:\n%s", sum, commodity, transaction)
+			}
+		}
+	}
+}
+
+func TestJournalWriterVerifyMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewJournalWriter(&buf, JournalOptions{Format: FormatLedger, Commodity: "EUR"})
+	expenses := journalExpenses()
+	if err := writer.WriteExpense(&expenses[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker has not applied the dinner yet
+	if err := writer.Verify(map[string]float64{}); err == nil {
+		t.Error("Verify passed a journal that does not match the balances")
+	}
+}
+
+func TestSplitCents(t *testing.T) {
+	tests := []struct {
+		amount int64
+		n      int
+		want   []int64
+	}{
+		{amount: 10000, n: 3, want: []int64{3334, 3333, 3333}},
+		{amount: 1001, n: 2, want: []int64{501, 500}},
+		{amount: -1001, n: 3, want: []int64{-334, -334, -333}},
+		{amount: -2, n: 3, want: []int64{-1, -1, 0}},
+		{amount: 450, n: 1, want: []int64{450}},
+	}
+
+	for _, tt := range tests {
+		if got := splitCents(tt.amount, tt.n); !slices.Equal(got, tt.want) {
+			t.Errorf("splitCents(%d, %d) = %v, want %v", tt.amount, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestJournalOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts JournalOptions
+		ok   bool
+	}{
+		{name: "defaults", opts: JournalOptions{Commodity: "EUR"}, ok: true},
+		{name: "overrides", opts: JournalOptions{Commodity: "EUR", MemberAccounts: map[string]string{"alice": "Assets:Friends:Alice"}, CategoryAccounts: map[string]string{"food": "Expenses:Dining"}}, ok: true},
+		{name: "unknown root", opts: JournalOptions{Commodity: "EUR", MemberAccounts: map[string]string{"alice": "Friends:Alice"}}},
+		{name: "category without root", opts: JournalOptions{Commodity: "EUR", CategoryAccounts: map[string]string{"food": "Dining"}}},
+		{name: "lowercase component", opts: JournalOptions{Commodity: "EUR", MemberAccounts: map[string]string{"alice": "Assets:alice"}}},
+		{name: "empty component", opts: JournalOptions{Commodity: "EUR", MemberAccounts: map[string]string{"alice": "Assets::Alice"}}},
+		{name: "lowercase commodity", opts: JournalOptions{Commodity: "eur"}},
+		{name: "commodity with a space", opts: JournalOptions{Commodity: "EUR 1"}},
+		{name: "no commodity", opts: JournalOptions{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package export
+
+import (
+	"expense-split-wise/internal/models"
+	"fmt"
+	"io"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// statementColumns are the entry table columns and their widths in mm (A4 portrait, 15mm margins)
+var statementColumns = []struct {
+	title string
+	width float64
+	align string
+}{
+	{"Date", 22, "L"},
+	{"Description", 58, "L"},
+	{"Paid by", 28, "L"},
+	{"Amount", 22, "R"},
+	{"Your share", 22, "R"},
+	{"Balance", 28, "R"},
+}
+
+// WriteStatementPDF renders a member statement as a PDF using only the built-in
+// PDF fonts, so no font files or network access are needed
+func WriteStatementPDF(w io.Writer, statement *models.MemberStatement, currency string) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.SetTitle(fmt.Sprintf("Statement for %s", statement.Member), true)
+	pdf.AliasNbPages("")
+
+	// Core fonts are cp1252; translate so accented names render correctly
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		for _, column := range statementColumns {
+			pdf.CellFormat(column.width, 7, column.title, "1", 0, column.align, true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 9)
+	}
+
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr(fmt.Sprintf("Statement: %s", statement.Member)), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, tr(fmt.Sprintf("Group: %s", statement.GroupName)), "", 1, "L", false, 0, "")
+	// To is exclusive; show the last day included
+	pdf.CellFormat(0, 6, fmt.Sprintf("Period: %s to %s",
+		statement.From.Format("2 Jan 2006"),
+		statement.To.AddDate(0, 0, -1).Format("2 Jan 2006"),
+	), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("Currency: %s", currency), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	summary := func(label string, amount float64) {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(40, 7, label, "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(40, 7, formatAmount(amount), "", 0, "R", false, 0, "")
+		pdf.CellFormat(0, 7, balanceNote(amount), "", 1, "L", false, 0, "")
+	}
+
+	summary("Opening balance", statement.OpeningBalance)
+	pdf.Ln(2)
+
+	header()
+	for _, entry := range statement.Entries {
+		// Repeat the table header after a page break
+		if pdf.GetY()+7 > 297-15-5 {
+			pdf.AddPage()
+			header()
+		}
+
+		description := entry.Description
+		if entry.Settlement {
+			description = "Payment: " + description
+		}
+
+		cells := []string{
+			entry.Date.Format("2006-01-02"),
+			truncate(pdf, tr(description), statementColumns[1].width-2),
+			truncate(pdf, tr(entry.PaidBy), statementColumns[2].width-2),
+			formatAmount(entry.Amount),
+			formatAmount(entry.Share),
+			formatAmount(entry.Balance),
+		}
+		for i, column := range statementColumns {
+			pdf.CellFormat(column.width, 6, cells[i], "1", 0, column.align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	if len(statement.Entries) == 0 {
+		pdf.CellFormat(0, 6, "No expenses or settlements in this period", "1", 1, "C", false, 0, "")
+	}
+
+	pdf.Ln(4)
+	summary("Closing balance", statement.ClosingBalance)
+
+	return pdf.Output(w)
+}
+
+// balanceNote explains the sign of a balance
+func balanceNote(amount float64) string {
+	switch {
+	case amount > 0.005:
+		return "  (you are owed)"
+	case amount < -0.005:
+		return "  (you owe)"
+	default:
+		return "  (settled)"
+	}
+}
+
+// truncate shortens translated (single-byte) text with an ellipsis so it fits in width mm at the current font
+func truncate(pdf *fpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
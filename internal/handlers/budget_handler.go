@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type BudgetHandler struct {
+	budgetService *services.BudgetService
+}
+
+func NewBudgetHandler(budgetService *services.BudgetService) *BudgetHandler {
+	return &BudgetHandler{budgetService: budgetService}
+}
+
+// CreateBudget handles POST /groups/:id/budgets
+// Request: {"name": "Food", "category": "Food", "amount": 10000, "period": "monthly", "timezone": "Asia/Kolkata"}
+// Trip budgets: {"name": "Goa trip", "amount": 50000, "period": "trip", "startDate": "2024-01-01T00:00:00Z", "endDate": "2024-01-10T00:00:00Z"}
+// Response: {"id": "...", "name": "Food", "amount": 10000, ...}
+func (h *BudgetHandler) CreateBudget(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req struct {
+		Name      string     `json:"name" binding:"required"`
+		Category  string     `json:"category"`
+		Amount    float64    `json:"amount" binding:"required,gt=0"`
+		Period    string     `json:"period" binding:"required,oneof=monthly trip"`
+		Timezone  string     `json:"timezone"`
+		StartDate *time.Time `json:"startDate"`
+		EndDate   *time.Time `json:"endDate"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+		return
+	}
+	if req.StartDate != nil && req.EndDate != nil && !req.StartDate.Before(*req.EndDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endDate must be after startDate"})
+		return
+	}
+
+	budget := &models.Budget{
+		GroupID:   groupID,
+		Name:      req.Name,
+		Category:  req.Category,
+		Amount:    req.Amount,
+		Period:    req.Period,
+		Timezone:  req.Timezone,
+		StartDate: req.StartDate,
+		EndDate:   req.EndDate,
+	}
+
+	if err := h.budgetService.CreateBudget(c.Request.Context(), budget); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create budget"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, budget)
+}
+
+// GetBudgets handles GET /groups/:id/budgets
+// Response: [{"id": "...", "name": "Food", "amount": 10000, "spent": 8200, "remaining": 1800, "percent": 82, ...}, ...]
+func (h *BudgetHandler) GetBudgets(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	statuses, err := h.budgetService.GetBudgetStatuses(c.Request.Context(), groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch budgets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// DeleteBudget handles DELETE /groups/:id/budgets/:budgetId
+// Response: {"message": "Budget deleted successfully"}
+func (h *BudgetHandler) DeleteBudget(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	budgetID, err := primitive.ObjectIDFromHex(c.Param("budgetId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid budget ID"})
+		return
+	}
+
+	if err := h.budgetService.DeleteBudget(c.Request.Context(), groupID, budgetID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Budget not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete budget"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget deleted successfully"})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"expense-split-wise/internal/services"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// eventHeartbeat keeps idle event streams from being closed by proxies
+const eventHeartbeat = 15 * time.Second
+
+type EventHandler struct {
+	eventService *services.EventService
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewEventHandler(eventService *services.EventService) *EventHandler {
+	return &EventHandler{
+		eventService: eventService,
+		done:         make(chan struct{}),
+	}
+}
+
+// Close ends every open event stream, so they do not hold up a server shutdown.
+// Clients reconnect to another instance and resume with Last-Event-ID.
+func (h *EventHandler) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// StreamEvents handles GET /groups/:id/events
+// Streams Server-Sent Events: expense.created, expense.updated, expense.deleted,
+// settlement.recorded and balances.changed, each with the changed data as JSON.
+// Reconnecting clients send Last-Event-ID (or ?lastEventId=) to receive the events they missed.
+// If those are no longer kept, a reset event tells the client to fetch the group again.
+// Response:
+//
+//	id: 1700000000000-0
+//	event: balances.changed
+//	data: {"Alice": 60, "Bob": -30, "Charlie": -30}
+func (h *EventHandler) StreamEvents(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	ctx := c.Request.Context()
+	events, err := h.eventService.Subscribe(ctx, groupID, lastEventID)
+	if errors.Is(err, services.ErrInvalidEventID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to events"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Stop nginx buffering the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Event, event.Data)
+		}
+		c.Writer.Flush()
+	}
+}
@@ -1,14 +1,41 @@
 package handlers
 
 import (
+	"errors"
 	"expense-split-wise/internal/models"
 	"expense-split-wise/internal/services"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// expenseRequest is the body of expense create and update requests
+type expenseRequest struct {
+	Description  string   `json:"description" binding:"required"`
+	Amount       float64  `json:"amount" binding:"required,gt=0"`
+	PaidBy       string   `json:"paidBy" binding:"required"`
+	SplitBetween []string `json:"splitBetween" binding:"required,min=1"`
+	Category     string   `json:"category"`
+	Currency     string   `json:"currency"`
+	Notes        string   `json:"notes"`
+}
+
+func (r *expenseRequest) toExpense(groupID primitive.ObjectID) *models.Expense {
+	return &models.Expense{
+		GroupID:      groupID,
+		Description:  r.Description,
+		Amount:       r.Amount,
+		PaidBy:       r.PaidBy,
+		SplitBetween: r.SplitBetween,
+		Category:     r.Category,
+		Currency:     r.Currency,
+		Notes:        r.Notes,
+	}
+}
+
 type ExpenseHandler struct {
 	expenseService *services.ExpenseService
 	balanceService *services.BalanceService
@@ -24,6 +51,7 @@ func NewExpenseHandler(expenseService *services.ExpenseService, balanceService *
 // CreateExpense handles POST /groups/:id/expenses
 // Request: {"description": "Dinner", "amount": 1500, "paidBy": "Alice", "splitBetween": ["Alice", "Bob", "Charlie"]}
 // Response: {"id": "...", "groupId": "...", "description": "Dinner", ...}
+// Responds 202 instead of 201 when the expense was saved but balances will update late.
 func (h *ExpenseHandler) CreateExpense(c *gin.Context) {
 	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -31,27 +59,19 @@ func (h *ExpenseHandler) CreateExpense(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Description  string   `json:"description" binding:"required"`
-		Amount       float64  `json:"amount" binding:"required,gt=0"`
-		PaidBy       string   `json:"paidBy" binding:"required"`
-		SplitBetween []string `json:"splitBetween" binding:"required,min=1"`
-	}
-
+	var req expenseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	expense := &models.Expense{
-		GroupID:      groupID,
-		Description:  req.Description,
-		Amount:       req.Amount,
-		PaidBy:       req.PaidBy,
-		SplitBetween: req.SplitBetween,
-	}
+	expense := req.toExpense(groupID)
 
 	if err := h.expenseService.CreateExpense(c.Request.Context(), expense); err != nil {
+		if errors.Is(err, services.ErrEventDelayed) {
+			c.JSON(http.StatusAccepted, expense)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create expense"})
 		return
 	}
@@ -59,6 +79,80 @@ func (h *ExpenseHandler) CreateExpense(c *gin.Context) {
 	c.JSON(http.StatusCreated, expense)
 }
 
+// UpdateExpense handles PUT /groups/:id/expenses/:expenseId
+// Request: same as CreateExpense
+// Response: {"id": "...", "groupId": "...", "description": "Dinner", ...}
+// Responds 202 instead of 200 when the expense was saved but balances will update late.
+func (h *ExpenseHandler) UpdateExpense(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	expenseID, err := primitive.ObjectIDFromHex(c.Param("expenseId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID"})
+		return
+	}
+
+	var req expenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expense := req.toExpense(groupID)
+	expense.ID = expenseID
+
+	if err := h.expenseService.UpdateExpense(c.Request.Context(), expense); err != nil {
+		if errors.Is(err, services.ErrEventDelayed) {
+			c.JSON(http.StatusAccepted, expense)
+			return
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update expense"})
+		return
+	}
+
+	c.JSON(http.StatusOK, expense)
+}
+
+// DeleteExpense handles DELETE /groups/:id/expenses/:expenseId
+// Response: {"message": "Expense deleted successfully"}
+// Responds 202 instead of 200 when the expense was deleted but balances will update late.
+func (h *ExpenseHandler) DeleteExpense(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	expenseID, err := primitive.ObjectIDFromHex(c.Param("expenseId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID"})
+		return
+	}
+
+	if err := h.expenseService.DeleteExpense(c.Request.Context(), groupID, expenseID); err != nil {
+		if errors.Is(err, services.ErrEventDelayed) {
+			c.JSON(http.StatusAccepted, gin.H{"message": "Expense deleted, balances will update shortly"})
+			return
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete expense"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expense deleted successfully"})
+}
+
 // GetExpenses handles GET /groups/:id/expenses
 // Response: [{"id": "...", "description": "Dinner", ...}, ...]
 func (h *ExpenseHandler) GetExpenses(c *gin.Context) {
@@ -77,6 +171,64 @@ func (h *ExpenseHandler) GetExpenses(c *gin.Context) {
 	c.JSON(http.StatusOK, expenses)
 }
 
+// SearchExpenses handles GET /groups/:id/expenses/search?q=taxi&limit=20
+// Response: [{"id": "...", "description": "Goa taxi", "score": 7.5, "highlights": {"description": "Goa <mark>taxi</mark>"}}, ...]
+func (h *ExpenseHandler) SearchExpenses(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	query, limit, ok := parseSearchParams(c)
+	if !ok {
+		return
+	}
+
+	results, err := h.expenseService.SearchExpenses(c.Request.Context(), groupID, query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search expenses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// SearchUserExpenses handles GET /users/:user/expenses/search?q=taxi&limit=20
+// Searches the expenses of every group the user is a member of
+// Response: same as SearchExpenses
+func (h *ExpenseHandler) SearchUserExpenses(c *gin.Context) {
+	query, limit, ok := parseSearchParams(c)
+	if !ok {
+		return
+	}
+
+	results, err := h.expenseService.SearchUserExpenses(c.Request.Context(), c.Param("user"), query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search expenses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// parseSearchParams reads the q and limit query parameters, writing a 400 response if they are invalid
+func parseSearchParams(c *gin.Context) (string, int64, bool) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return "", 0, false
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return "", 0, false
+	}
+
+	return query, limit, true
+}
+
 // GetBalances handles GET /groups/:id/balances
 // Response: {"Alice": 500, "Bob": -250, "Charlie": -250}
 func (h *ExpenseHandler) GetBalances(c *gin.Context) {
@@ -94,3 +246,27 @@ func (h *ExpenseHandler) GetBalances(c *gin.Context) {
 
 	c.JSON(http.StatusOK, balances)
 }
+
+// RecalculateBalances handles POST /groups/:id/balances/recalculate
+// Rebuilds the group's balances from all of its expenses, repairing any drift from incremental updates
+// Response: {"Alice": 500, "Bob": -250, "Charlie": -250}
+func (h *ExpenseHandler) RecalculateBalances(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	if err := h.balanceService.RecalculateBalances(c.Request.Context(), groupID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate balances"})
+		return
+	}
+
+	balances, err := h.balanceService.GetBalances(c.Request.Context(), groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch balances"})
+		return
+	}
+
+	c.JSON(http.StatusOK, balances)
+}
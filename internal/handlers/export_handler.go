@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"expense-split-wise/internal/export"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/services"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// csvFlushEvery is how many rows are buffered before flushing to the client
+const csvFlushEvery = 100
+
+type ExportHandler struct {
+	groupService   *services.GroupService
+	expenseService *services.ExpenseService
+	balanceService *services.BalanceService
+	currency       string
+}
+
+func NewExportHandler(groupService *services.GroupService, expenseService *services.ExpenseService, balanceService *services.BalanceService, defaultCurrency string) *ExportHandler {
+	return &ExportHandler{
+		groupService:   groupService,
+		expenseService: expenseService,
+		balanceService: balanceService,
+		currency:       defaultCurrency,
+	}
+}
+
+// ExportCSV handles GET /groups/:id/export.csv?layout=wide
+// Streams every expense of the group followed by a balances section.
+// layout=long (default) has one participants column; layout=wide has one column per member.
+func (h *ExportHandler) ExportCSV(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	layout := export.CSVLayout(c.DefaultQuery("layout", string(export.LayoutLong)))
+	if layout != export.LayoutLong && layout != export.LayoutWide {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "layout must be long or wide"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	group, err := h.groupService.GetGroup(ctx, groupID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+
+	// Balances are fetched up front so every member column is known before streaming
+	balances, err := h.balanceService.GetBalances(ctx, groupID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch balances"})
+		return
+	}
+
+	// The wide layout needs a column for everyone in an expense, including members
+	// since removed from the group, so it reads the expenses once up front
+	var involved []string
+	if layout == export.LayoutWide {
+		seen := make(map[string]bool)
+		err := h.expenseService.StreamExpensesByGroup(ctx, groupID, func(expense *models.Expense) error {
+			for _, member := range append([]string{expense.PaidBy}, expense.SplitBetween...) {
+				if !seen[member] {
+					seen[member] = true
+					involved = append(involved, member)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expenses"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="group-%s.csv"`, groupID.Hex()))
+	c.Status(http.StatusOK)
+
+	writer := export.NewCSVWriter(c.Writer, layout, export.MemberColumns(group.Members, balances, involved...))
+	if err := writer.WriteHeader(); err != nil {
+		log.Printf("❌ CSV export failed for group %s: %v", groupID.Hex(), err)
+		return
+	}
+
+	// Headers are already sent, so failures from here on can only abort the stream
+	rows := 0
+	err = h.expenseService.StreamExpensesByGroup(ctx, groupID, func(expense *models.Expense) error {
+		if err := writer.WriteExpense(expense); err != nil {
+			return err
+		}
+		rows++
+		if rows%csvFlushEvery == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.WriteBalances(balances)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Printf("❌ CSV export failed for group %s: %v", groupID.Hex(), err)
+	}
+}
+
+// ExportLedger handles GET /groups/:id/export.ledger
+// Optional account naming: ?account[Alice]=Liabilities:Friends:Alice&category[Food]=Expenses:Dining&commodity=EUR
+func (h *ExportHandler) ExportLedger(c *gin.Context) {
+	h.exportJournal(c, export.FormatLedger)
+}
+
+// ExportBeancount handles GET /groups/:id/export.beancount
+// Takes the same query parameters as ExportLedger
+func (h *ExportHandler) ExportBeancount(c *gin.Context) {
+	h.exportJournal(c, export.FormatBeancount)
+}
+
+// exportJournal renders the group's journal and only sends it once its member
+// balances have been checked against GetBalances
+func (h *ExportHandler) exportJournal(c *gin.Context, format export.JournalFormat) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	opts := export.JournalOptions{
+		Format:           format,
+		MemberAccounts:   c.QueryMap("account"),
+		CategoryAccounts: c.QueryMap("category"),
+		Commodity:        c.DefaultQuery("commodity", h.currency),
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	balances, err := h.balanceService.GetBalances(ctx, groupID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch balances"})
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := export.NewJournalWriter(&buf, opts)
+	if err := h.expenseService.StreamExpensesByGroup(ctx, groupID, writer.WriteExpense); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expenses"})
+		return
+	}
+
+	// A mismatch usually means the worker has not yet processed a recent expense
+	if err := writer.Verify(balances); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("group-%s.%s", groupID.Hex(), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+}
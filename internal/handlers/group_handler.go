@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"expense-split-worker/internal/services"
+	"expense-split-wise/internal/services"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"expense-split-wise/internal/cache"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/services"
+	"expense-split-wise/internal/testutil"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestRouter serves the group, expense and notification routes in a test environment
+func newTestRouter(t *testing.T) (*gin.Engine, *testutil.Env) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	env := testutil.NewEnv(t, testutil.ExpenseQueue, testutil.NotificationQueue)
+	store := env.Store
+
+	outboxService := services.NewOutboxService(store.Outbox, env.Broker)
+	notificationService := services.NewNotificationService(store.Notifications, store.Groups, store.Expenses, outboxService, env.Broker, testutil.NotificationQueue)
+	groupService := services.NewGroupService(store.Transactor, store.Groups, outboxService, notificationService)
+	expenseService := services.NewExpenseService(store.Transactor, store.Expenses, store.Groups, store.Balances, outboxService, testutil.ExpenseQueue)
+	eventService := services.NewEventService(store.Expenses, env.Redis)
+	balanceService := services.NewBalanceService(store.Transactor, store.Expenses, store.Balances, cache.NewLRUCache(100, 0), eventService)
+
+	groupHandler := NewGroupHandler(groupService)
+	expenseHandler := NewExpenseHandler(expenseService, balanceService)
+	notificationHandler := NewNotificationHandler(notificationService)
+	eventHandler := NewEventHandler(eventService)
+
+	router := gin.New()
+	api := router.Group("/api/v1")
+	api.POST("/groups", groupHandler.CreateGroup)
+	api.POST("/groups/:id/users", groupHandler.AddUsersToGroup)
+	api.POST("/groups/:id/expenses", expenseHandler.CreateExpense)
+	api.GET("/groups/:id/expenses", expenseHandler.GetExpenses)
+	api.PUT("/groups/:id/expenses/:expenseId", expenseHandler.UpdateExpense)
+	api.DELETE("/groups/:id/expenses/:expenseId", expenseHandler.DeleteExpense)
+	api.GET("/groups/:id/balances", expenseHandler.GetBalances)
+	api.POST("/groups/:id/balances/recalculate", expenseHandler.RecalculateBalances)
+	api.GET("/groups/:id/events", eventHandler.StreamEvents)
+	api.GET("/users/:user/notifications", notificationHandler.GetPreferences)
+	api.PUT("/users/:user/notifications", notificationHandler.UpdatePreferences)
+	return router, env
+}
+
+// serve sends a request with body encoded as JSON, unless it is nil, and decodes
+// the response into out, unless it is nil
+func serve(t *testing.T, router *gin.Engine, method, path string, body, out interface{}, wantStatus int) {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != wantStatus {
+		t.Fatalf("%s %s = %d %s, want %d", method, path, rec.Code, rec.Body, wantStatus)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpenseRoutes(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	var group models.Group
+	serve(t, router, http.MethodPost, "/api/v1/groups", gin.H{"name": "Trip", "members": []string{"alice", "bob"}}, &group, http.StatusCreated)
+	groupPath := "/api/v1/groups/" + group.ID.Hex()
+	serve(t, router, http.MethodPost, groupPath+"/users", gin.H{"members": []string{"carol"}}, nil, http.StatusOK)
+
+	var dinner models.Expense
+	serve(t, router, http.MethodPost, groupPath+"/expenses", gin.H{
+		"description": "Dinner", "amount": 90, "paidBy": "alice", "splitBetween": []string{"alice", "bob", "carol"},
+	}, &dinner, http.StatusCreated)
+
+	serve(t, router, http.MethodPut, groupPath+"/expenses/"+dinner.ID.Hex(), gin.H{
+		"description": "Dinner", "amount": 60, "paidBy": "bob", "splitBetween": []string{"alice", "bob", "carol"},
+	}, nil, http.StatusOK)
+
+	var expenses []models.Expense
+	serve(t, router, http.MethodGet, groupPath+"/expenses", nil, &expenses, http.StatusOK)
+	if len(expenses) != 1 || expenses[0].Amount != 60 || expenses[0].PaidBy != "bob" {
+		t.Errorf("expenses = %+v, want the updated dinner", expenses)
+	}
+
+	// The worker is not running, so only a recalculation brings balances up to date
+	var balances map[string]float64
+	serve(t, router, http.MethodPost, groupPath+"/balances/recalculate", nil, &balances, http.StatusOK)
+	want := map[string]float64{"alice": -20, "bob": 40, "carol": -20}
+	if !maps.Equal(balances, want) {
+		t.Errorf("recalculated balances = %v, want %v", balances, want)
+	}
+	serve(t, router, http.MethodGet, groupPath+"/balances", nil, &balances, http.StatusOK)
+	if !maps.Equal(balances, want) {
+		t.Errorf("balances = %v, want %v", balances, want)
+	}
+
+	serve(t, router, http.MethodDelete, groupPath+"/expenses/"+dinner.ID.Hex(), nil, nil, http.StatusOK)
+	serve(t, router, http.MethodGet, groupPath+"/expenses", nil, &expenses, http.StatusOK)
+	if len(expenses) != 0 {
+		t.Errorf("expenses = %+v, want none", expenses)
+	}
+}
+
+func TestExpenseRoutesRejectInvalidRequests(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	var group models.Group
+	serve(t, router, http.MethodPost, "/api/v1/groups", gin.H{"name": "Trip", "members": []string{"alice", "bob"}}, &group, http.StatusCreated)
+	groupPath := "/api/v1/groups/" + group.ID.Hex()
+	update := gin.H{"description": "Dinner", "amount": 60, "paidBy": "bob", "splitBetween": []string{"alice", "bob"}}
+
+	serve(t, router, http.MethodPut, groupPath+"/expenses/"+primitive.NewObjectID().Hex(), update, nil, http.StatusNotFound)
+	serve(t, router, http.MethodDelete, groupPath+"/expenses/"+primitive.NewObjectID().Hex(), nil, nil, http.StatusNotFound)
+	serve(t, router, http.MethodPut, groupPath+"/expenses/dinner", update, nil, http.StatusBadRequest)
+	serve(t, router, http.MethodGet, "/api/v1/groups/trip/expenses", nil, nil, http.StatusBadRequest)
+	serve(t, router, http.MethodPost, groupPath+"/expenses", gin.H{"description": "Dinner", "amount": -5, "paidBy": "alice", "splitBetween": []string{"alice"}}, nil, http.StatusBadRequest)
+}
+
+func TestNotificationRoutes(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	serve(t, router, http.MethodGet, "/api/v1/users/alice/notifications", nil, nil, http.StatusNotFound)
+
+	serve(t, router, http.MethodPut, "/api/v1/users/alice/notifications", gin.H{"email": "not an email"}, nil, http.StatusBadRequest)
+	serve(t, router, http.MethodPut, "/api/v1/users/alice/notifications", gin.H{"optOut": []string{"spam"}}, nil, http.StatusBadRequest)
+	serve(t, router, http.MethodPut, "/api/v1/users/alice/notifications", gin.H{
+		"email": "alice@example.com", "optOut": []string{models.NotificationWeeklyDigest},
+	}, nil, http.StatusOK)
+
+	var preferences models.NotificationPreferences
+	serve(t, router, http.MethodGet, "/api/v1/users/alice/notifications", nil, &preferences, http.StatusOK)
+	if preferences.User != "alice" || preferences.Email != "alice@example.com" || len(preferences.OptOut) != 1 || preferences.OptOut[0] != models.NotificationWeeklyDigest {
+		t.Errorf("preferences = %+v, want alice@example.com opted out of the weekly digest", preferences)
+	}
+}
+
+func TestStreamEventsRejectsMalformedLastEventID(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	path := "/api/v1/groups/" + primitive.NewObjectID().Hex() + "/events?lastEventId=yesterday"
+	serve(t, router, http.MethodGet, path, nil, nil, http.StatusBadRequest)
+}
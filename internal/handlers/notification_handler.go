@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/services"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type NotificationHandler struct {
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(notificationService *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{notificationService: notificationService}
+}
+
+// GetPreferences handles GET /users/:user/notifications
+// Response: {"user": "Alice", "email": "alice@example.com", "optOut": ["expense_added"], "updatedAt": "..."}
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	preferences, err := h.notificationService.GetPreferences(c.Request.Context(), c.Param("user"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No notification preferences for user"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// UpdatePreferences handles PUT /users/:user/notifications
+// Request: {"email": "alice@example.com", "optOut": ["expense_added"]}
+// Response: {"user": "Alice", "email": "alice@example.com", "optOut": ["expense_added"], "updatedAt": "..."}
+// Users without preferences (or with an empty email) receive no emails
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	var req struct {
+		Email  string   `json:"email" binding:"omitempty,email"`
+		OptOut []string `json:"optOut"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, kind := range req.OptOut {
+		if !slices.Contains(services.NotificationKinds, kind) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification: " + kind, "notifications": services.NotificationKinds})
+			return
+		}
+	}
+
+	preferences := &models.NotificationPreferences{
+		User:   c.Param("user"),
+		Email:  req.Email,
+		OptOut: req.OptOut,
+	}
+
+	if err := h.notificationService.UpdatePreferences(c.Request.Context(), preferences); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
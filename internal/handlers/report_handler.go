@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"expense-split-wise/internal/export"
+	"expense-split-wise/internal/services"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReportHandler struct {
+	reportService *services.ReportService
+	currency      string
+}
+
+func NewReportHandler(reportService *services.ReportService, defaultCurrency string) *ReportHandler {
+	return &ReportHandler{
+		reportService: reportService,
+		currency:      defaultCurrency,
+	}
+}
+
+// MemberStatementPDF handles GET /groups/:id/members/:member/statement.pdf?from=2024-01-01&to=2024-01-31
+// from and to are inclusive dates and default to the current month
+// Response: application/pdf
+func (h *ReportHandler) MemberStatementPDF(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, -1)
+
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	member := c.Param("member")
+	statement, err := h.reportService.MemberStatement(c.Request.Context(), groupID, member, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build statement"})
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%s-%s-%s.pdf", member, from.Format("20060102"), to.Format("20060102"))
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	if err := export.WriteStatementPDF(c.Writer, statement, h.currency); err != nil {
+		log.Printf("❌ PDF statement failed for %s in group %s: %v", member, groupID.Hex(), err)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"expense-split-wise/internal/importer"
+	"expense-split-wise/internal/services"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type StatementHandler struct {
+	statementService *services.StatementService
+}
+
+func NewStatementHandler(statementService *services.StatementService) *StatementHandler {
+	return &StatementHandler{statementService: statementService}
+}
+
+// UploadStatement handles POST /groups/:id/members/:member/statements
+// Request: CSV or OFX statement, as multipart field "file" or the raw body
+// Query: dateOrder=dmy|mdy for numeric dates in a CSV statement, detected when omitted
+// Response: {"linked": [...], "proposed": [...], "duplicates": 0, "ignored": 2}
+func (h *StatementHandler) UploadStatement(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	order := importer.DateOrder(c.Query("dateOrder"))
+	if order != "" && order != importer.DayFirst && order != importer.MonthFirst {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dateOrder must be dmy or mdy"})
+		return
+	}
+
+	var body io.Reader = c.Request.Body
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	transactions, err := importer.ParseStatement(body, order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.statementService.ImportStatement(c.Request.Context(), groupID, c.Param("member"), transactions)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, services.ErrNotMember) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import statement"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// GetTransactions handles GET /groups/:id/statements/transactions?status=pending
+// Response: [{"id": "...", "member": "Alice", "amount": 450, "status": "pending", ...}, ...]
+func (h *StatementHandler) GetTransactions(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	transactions, err := h.statementService.GetTransactions(c.Request.Context(), groupID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// AcceptProposals handles POST /groups/:id/statements/proposals/accept
+// Request: {"proposals": [{"id": "...", "splitBetween": ["Alice", "Bob"], "category": "Taxi"}, ...]}
+// Response: [{"id": "...", "description": "UBER TRIP", ...}, ...] (the created expenses)
+// All proposals are accepted or, if any is no longer pending (409) or splits with a
+// non-member (400), none is
+func (h *StatementHandler) AcceptProposals(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req struct {
+		Proposals []struct {
+			ID           string   `json:"id" binding:"required"`
+			Description  string   `json:"description"`
+			SplitBetween []string `json:"splitBetween"`
+			Category     string   `json:"category"`
+		} `json:"proposals" binding:"required,min=1,dive"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	acceptances := make([]services.ProposalAcceptance, len(req.Proposals))
+	for i, proposal := range req.Proposals {
+		transactionID, err := primitive.ObjectIDFromHex(proposal.ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal ID"})
+			return
+		}
+		acceptances[i] = services.ProposalAcceptance{
+			TransactionID: transactionID,
+			Description:   proposal.Description,
+			SplitBetween:  proposal.SplitBetween,
+			Category:      proposal.Category,
+		}
+	}
+
+	expenses, err := h.statementService.AcceptProposals(c.Request.Context(), groupID, acceptances)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrEventDelayed):
+			c.JSON(http.StatusAccepted, expenses)
+		case errors.Is(err, services.ErrProposalNotPending):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrNotMember):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, mongo.ErrNoDocuments):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept proposals"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, expenses)
+}
+
+// RejectProposals handles POST /groups/:id/statements/proposals/reject
+// Request: {"ids": ["...", "..."]}
+// Response: {"message": "Proposals rejected"}
+func (h *StatementHandler) RejectProposals(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req struct {
+		IDs []string `json:"ids" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transactionIDs := make([]primitive.ObjectID, len(req.IDs))
+	for i, id := range req.IDs {
+		transactionID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal ID"})
+			return
+		}
+		transactionIDs[i] = transactionID
+	}
+
+	if err := h.statementService.RejectProposals(c.Request.Context(), groupID, transactionIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject proposals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Proposals rejected"})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/services"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// CreateWebhook handles POST /groups/:id/webhooks
+// Request: {"url": "https://example.com/hooks", "secret": "s3cret", "events": ["expense.created", "balances.changed"]}
+// Response: {"id": "...", "url": "...", "events": [...], "active": true, ...}
+// Payloads are signed: X-Webhook-Signature is "sha256=" + hex HMAC-SHA256 of "<X-Webhook-Timestamp>.<body>"
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url" binding:"required"`
+		Secret string   `json:"secret" binding:"required,min=16"`
+		Events []string `json:"events" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http or https URL"})
+		return
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(services.WebhookEvents, event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event: " + event, "events": services.WebhookEvents})
+			return
+		}
+	}
+
+	subscription := &models.WebhookSubscription{
+		GroupID: groupID,
+		URL:     req.URL,
+		Secret:  req.Secret,
+		Events:  req.Events,
+	}
+
+	if err := h.webhookService.CreateSubscription(c.Request.Context(), subscription); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+// GetWebhooks handles GET /groups/:id/webhooks
+// Response: [{"id": "...", "url": "...", "events": [...], "active": true, "consecutiveFailures": 0}, ...]
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	subscriptions, err := h.webhookService.GetSubscriptions(c.Request.Context(), groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// DeleteWebhook handles DELETE /groups/:id/webhooks/:webhookId
+// Response: {"message": "Webhook deleted successfully"}
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	groupID, subscriptionID, ok := parseWebhookIDs(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.DeleteSubscription(c.Request.Context(), groupID, subscriptionID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// EnableWebhook handles POST /groups/:id/webhooks/:webhookId/enable
+// Re-enables a webhook that was disabled after repeated delivery failures
+// Response: {"message": "Webhook enabled"}
+func (h *WebhookHandler) EnableWebhook(c *gin.Context) {
+	groupID, subscriptionID, ok := parseWebhookIDs(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.EnableSubscription(c.Request.Context(), groupID, subscriptionID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook enabled"})
+}
+
+// GetDeliveries handles GET /groups/:id/webhooks/:webhookId/deliveries?limit=50
+// Response: [{"id": "...", "event": "expense.created", "status": "succeeded", "attempts": 1, "responseStatus": 200, ...}, ...]
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	groupID, subscriptionID, ok := parseWebhookIDs(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	deliveries, err := h.webhookService.GetDeliveries(c.Request.Context(), groupID, subscriptionID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// parseWebhookIDs reads the group and webhook IDs from the path, writing a 400 response if either is invalid
+func parseWebhookIDs(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	subscriptionID, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	return groupID, subscriptionID, true
+}
@@ -0,0 +1,156 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"expense-split-wise/internal/models"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitwiseFixedColumns are the columns preceding the per-member columns in a Splitwise export
+var splitwiseFixedColumns = []string{"Date", "Description", "Category", "Cost", "Currency"}
+
+// splitwiseTotalRow is the description of the summary row ending a Splitwise export
+const splitwiseTotalRow = "Total balance"
+
+// shareTolerance absorbs Splitwise's cent rounding (100 / 3 = 33.34 + 33.33 + 33.33)
+const shareTolerance = 0.011
+
+// SplitwiseExport is a parsed Splitwise group export
+type SplitwiseExport struct {
+	Members  []string
+	Expenses []models.Expense
+	Skipped  []models.SkippedRow
+}
+
+// ParseSplitwiseCSV parses a Splitwise per-group CSV export.
+//
+// Each row holds every member's net change (positive = paid more than their share).
+// Rows are mapped to a single payer with an equal split; payments map to an expense
+// paid by the sender and split to the recipient. Rows that cannot be expressed that
+// way (several payers, unequal splits) are reported in Skipped.
+func ParseSplitwiseCSV(r io.Reader) (*SplitwiseExport, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if len(header) <= len(splitwiseFixedColumns) {
+		return nil, errors.New("not a Splitwise export: no member columns")
+	}
+	for i, column := range splitwiseFixedColumns {
+		if strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")) != column {
+			return nil, fmt.Errorf("not a Splitwise export: expected column %q, got %q", column, header[i])
+		}
+	}
+
+	export := &SplitwiseExport{}
+	for _, member := range header[len(splitwiseFixedColumns):] {
+		export.Members = append(export.Members, strings.TrimSpace(member))
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err // Already names the line
+		}
+		// Taken from the reader, which skips blank lines
+		line, _ := reader.FieldPos(0)
+
+		// Blank separator rows and the trailing "Total balance" row carry no expense
+		if len(record) < len(header) || strings.TrimSpace(record[0]) == "" || strings.TrimSpace(record[1]) == splitwiseTotalRow {
+			continue
+		}
+
+		expense, reason := parseSplitwiseRow(record, export.Members)
+		if reason != "" {
+			export.Skipped = append(export.Skipped, models.SkippedRow{
+				Line:        line,
+				Description: record[1],
+				Reason:      reason,
+			})
+			continue
+		}
+		export.Expenses = append(export.Expenses, *expense)
+	}
+
+	return export, nil
+}
+
+// parseSplitwiseRow maps a row to an expense, or returns the reason it cannot be imported
+func parseSplitwiseRow(record, members []string) (*models.Expense, string) {
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+	if err != nil {
+		return nil, "invalid date"
+	}
+
+	amount, err := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+	if err != nil || amount <= 0 {
+		return nil, "invalid cost"
+	}
+
+	var payers, debtors []string
+	net := make(map[string]float64, len(members))
+	for i, member := range members {
+		value := strings.TrimSpace(record[len(splitwiseFixedColumns)+i])
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Sprintf("invalid amount for %s", member)
+		}
+		switch {
+		case n > shareTolerance:
+			payers = append(payers, member)
+		case n < -shareTolerance:
+			debtors = append(debtors, member)
+		}
+		net[member] = n
+	}
+
+	if len(payers) != 1 {
+		return nil, "expense must have exactly one payer"
+	}
+	if len(debtors) == 0 {
+		return nil, "expense has no participants besides the payer"
+	}
+
+	payer := payers[0]
+	splitBetween := debtors
+
+	// The payer shares the cost when their net is less than the full amount
+	if amount-net[payer] > shareTolerance {
+		splitBetween = append([]string{payer}, debtors...)
+	}
+
+	share := amount / float64(len(splitBetween))
+	for _, member := range debtors {
+		if math.Abs(net[member]+share) > shareTolerance {
+			return nil, "unequal splits are not supported"
+		}
+	}
+	if len(splitBetween) > len(debtors) && math.Abs(net[payer]-(amount-share)) > shareTolerance {
+		return nil, "unequal splits are not supported"
+	}
+
+	return &models.Expense{
+		Description:  strings.TrimSpace(record[1]),
+		Amount:       amount,
+		PaidBy:       payer,
+		SplitBetween: splitBetween,
+		Category:     strings.TrimSpace(record[2]),
+		Currency:     strings.TrimSpace(record[4]),
+		CreatedAt:    date,
+	}, ""
+}
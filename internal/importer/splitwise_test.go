@@ -0,0 +1,110 @@
+package importer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splitwiseHeader is the header of a Splitwise export of a three-member group,
+// which starts with a byte order mark
+const splitwiseHeader = "\ufeffDate,Description,Category,Cost,Currency,Alice,Bob,Carol\n"
+
+func TestParseSplitwiseCSVRows(t *testing.T) {
+	tests := []struct {
+		name         string
+		row          string
+		paidBy       string
+		splitBetween []string
+		amount       float64
+		skipped      string
+	}{
+		{name: "equal split", row: "2024-01-05,Groceries,Groceries,90.00,EUR,60.00,-30.00,-30.00", paidBy: "Alice", splitBetween: []string{"Alice", "Bob", "Carol"}, amount: 90},
+		{name: "cent rounding", row: "2024-01-06,Train tickets,Transportation,100.00,EUR,-33.33,66.67,-33.34", paidBy: "Bob", splitBetween: []string{"Bob", "Alice", "Carol"}, amount: 100},
+		{name: "payer not sharing", row: "2024-01-07,Concert ticket,Entertainment,45.00,EUR,0.00,-45.00,45.00", paidBy: "Carol", splitBetween: []string{"Bob"}, amount: 45},
+		{name: "member not involved", row: "2024-01-08,Taxi,Taxi,30.00,EUR,15.00,-15.00,0.00", paidBy: "Alice", splitBetween: []string{"Alice", "Bob"}, amount: 30},
+		{name: "payment", row: "2024-01-09,Bob paid Alice,Payment,25.00,EUR,-25.00,25.00,0.00", paidBy: "Bob", splitBetween: []string{"Alice"}, amount: 25},
+		{name: "unequal split", row: "2024-01-10,Dinner,Dining out,90.00,EUR,50.00,-20.00,-30.00", skipped: "unequal splits are not supported"},
+		{name: "beyond rounding", row: "2024-01-11,Dinner,Dining out,90.00,EUR,60.00,-29.98,-30.02", skipped: "unequal splits are not supported"},
+		{name: "several payers", row: "2024-01-12,Hotel,Hotel,300.00,EUR,100.00,100.00,-200.00", skipped: "expense must have exactly one payer"},
+		{name: "no participants", row: "2024-01-13,Refund,General,10.00,EUR,0.00,0.00,0.00", skipped: "expense must have exactly one payer"},
+		{name: "invalid cost", row: "2024-01-14,Gift,General,free,EUR,10.00,-5.00,-5.00", skipped: "invalid cost"},
+		{name: "invalid date", row: "14/01/2024,Gift,General,10.00,EUR,10.00,-5.00,-5.00", skipped: "invalid date"},
+		{name: "invalid share", row: "2024-01-15,Gift,General,10.00,EUR,5.00,-5.00,n/a", skipped: "invalid amount for Carol"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export, err := ParseSplitwiseCSV(strings.NewReader(splitwiseHeader + tt.row + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.skipped != "" {
+				if len(export.Expenses) != 0 || len(export.Skipped) != 1 {
+					t.Fatalf("got %d expenses and %d skipped rows, want the row skipped", len(export.Expenses), len(export.Skipped))
+				}
+				if skipped := export.Skipped[0]; skipped.Reason != tt.skipped || skipped.Line != 2 {
+					t.Errorf("skipped line %d for %q, want line 2 for %q", skipped.Line, skipped.Reason, tt.skipped)
+				}
+				return
+			}
+
+			if len(export.Expenses) != 1 {
+				t.Fatalf("got %d expenses, skipped %v", len(export.Expenses), export.Skipped)
+			}
+			expense := export.Expenses[0]
+			if expense.PaidBy != tt.paidBy || !slices.Equal(expense.SplitBetween, tt.splitBetween) || expense.Amount != tt.amount {
+				t.Errorf("expense = %.2f paid by %s split between %v, want %.2f paid by %s split between %v",
+					expense.Amount, expense.PaidBy, expense.SplitBetween, tt.amount, tt.paidBy, tt.splitBetween)
+			}
+		})
+	}
+}
+
+func TestParseSplitwiseCSVExport(t *testing.T) {
+	csv := splitwiseHeader +
+		"\n" +
+		"2024-01-05,Groceries,Groceries,90.00,EUR,60.00,-30.00,-30.00\n" +
+		"2024-01-09,Bob paid Alice,Payment,25.00,EUR,-25.00,25.00,0.00\n" +
+		"2024-01-10,Dinner,Dining out,90.00,EUR,50.00,-20.00,-30.00\n" +
+		"\n" +
+		"2024-01-31,Total balance, , ,EUR,5.00,-5.00,0.00\n"
+
+	export, err := ParseSplitwiseCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"Alice", "Bob", "Carol"}; !slices.Equal(export.Members, want) {
+		t.Errorf("members = %v, want %v", export.Members, want)
+	}
+	if len(export.Expenses) != 2 {
+		t.Fatalf("got %d expenses, want 2", len(export.Expenses))
+	}
+	groceries := export.Expenses[0]
+	if groceries.Description != "Groceries" || groceries.Category != "Groceries" || groceries.Currency != "EUR" ||
+		!groceries.CreatedAt.Equal(time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("groceries = %+v", groceries)
+	}
+	if len(export.Skipped) != 1 || export.Skipped[0].Line != 5 || export.Skipped[0].Description != "Dinner" {
+		t.Errorf("skipped = %+v, want the dinner on line 5", export.Skipped)
+	}
+}
+
+func TestParseSplitwiseCSVRejectsOtherFiles(t *testing.T) {
+	tests := map[string]string{
+		"empty":         "",
+		"no members":    "Date,Description,Category,Cost,Currency\n",
+		"other columns": "Date,Description,Amount,Balance,Currency,Alice\n",
+	}
+
+	for name, csv := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseSplitwiseCSV(strings.NewReader(csv)); err == nil {
+				t.Error("parsed a file that is not a Splitwise export")
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BankTransaction is a single row of a bank or card statement.
+// Amount is signed as on the statement: negative for money going out.
+type BankTransaction struct {
+	ExternalID  string
+	Date        time.Time
+	Amount      float64
+	Description string
+}
+
+// DateOrder selects how numeric dates such as 03/04/2024 are read in CSV statements
+type DateOrder string
+
+const (
+	// DayFirst reads 03/04/2024 as 3 April
+	DayFirst DateOrder = "dmy"
+	// MonthFirst reads 03/04/2024 as 4 March
+	MonthFirst DateOrder = "mdy"
+)
+
+// ErrAmbiguousDates is returned for a CSV statement whose numeric dates read as valid
+// but different dates either way round, when no date order is given
+var ErrAmbiguousDates = errors.New("statement dates could be day-first or month-first; specify the date order")
+
+// ParseStatement parses a CSV or OFX statement, detecting the format from its content.
+// order is the order of numeric dates in a CSV statement, or empty to detect it.
+func ParseStatement(r io.Reader, order DateOrder) ([]BankTransaction, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	head := strings.ToUpper(string(data[:min(len(data), 512)]))
+	if strings.Contains(head, "OFXHEADER") || strings.Contains(head, "<OFX>") {
+		return ParseOFX(bytes.NewReader(data))
+	}
+	return ParseStatementCSV(bytes.NewReader(data), order)
+}
+
+// statementDateLayouts are the date formats accepted in CSV statements, besides the
+// numeric ones in numericDateLayouts
+var statementDateLayouts = []string{"2006-01-02", "2006/01/02", "02 Jan 2006", "Jan 2, 2006"}
+
+// numericDateLayouts are the numeric date formats accepted in each date order
+var numericDateLayouts = map[DateOrder][]string{
+	DayFirst:   {"02/01/2006", "02-01-2006"},
+	MonthFirst: {"01/02/2006", "01-02-2006"},
+}
+
+// ParseStatementCSV parses a CSV statement with a header row. Columns are found by
+// name: a date column, a description column (description, narrative, payee, details,
+// memo) and either a signed amount column or separate debit and credit columns.
+//
+// order is the order of numeric dates, or empty to detect it from the dates that fit
+// only one order. A statement whose dates all fit both is rejected with
+// ErrAmbiguousDates unless every date reads the same either way.
+func ParseStatementCSV(r io.Reader, order DateOrder) ([]BankTransaction, error) {
+	if order != "" && numericDateLayouts[order] == nil {
+		return nil, fmt.Errorf("unknown date order %q", order)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch {
+		case strings.Contains(name, "date") && !hasColumn(columns, "date"):
+			columns["date"] = i
+		case name == "description" || name == "narrative" || name == "payee" || name == "details" || name == "memo" || name == "transaction":
+			if !hasColumn(columns, "description") {
+				columns["description"] = i
+			}
+		case name == "amount":
+			columns["amount"] = i
+		case name == "debit" || name == "withdrawal" || name == "withdrawals" || name == "paid out":
+			columns["debit"] = i
+		case name == "credit" || name == "deposit" || name == "deposits" || name == "paid in":
+			columns["credit"] = i
+		}
+	}
+	if !hasColumn(columns, "date") || !hasColumn(columns, "description") {
+		return nil, errors.New("statement needs date and description columns")
+	}
+	if !hasColumn(columns, "amount") && !hasColumn(columns, "debit") {
+		return nil, errors.New("statement needs an amount or debit column")
+	}
+
+	// Read every row first, as the date order may only show in a later row
+	type row struct {
+		line   int
+		record []string
+	}
+	var rows []row
+	var dates []string
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(record) == 0 || strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		rows = append(rows, row{line: line, record: record})
+		if i := columns["date"]; i < len(record) {
+			dates = append(dates, strings.TrimSpace(record[i]))
+		}
+	}
+
+	if order == "" {
+		if order, err = detectDateOrder(dates); err != nil {
+			return nil, err
+		}
+	}
+
+	var transactions []BankTransaction
+	ids := rowIDs{}
+	for _, row := range rows {
+		line, record := row.line, row.record
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		date, err := parseStatementDate(field("date"), order)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var amount float64
+		if hasColumn(columns, "amount") {
+			amount, err = parseStatementAmount(field("amount"))
+		} else {
+			var debit, credit float64
+			debit, err = parseStatementAmount(field("debit"))
+			if err == nil {
+				credit, err = parseStatementAmount(field("credit"))
+			}
+			amount = credit - abs(debit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		transaction := BankTransaction{
+			Date:        date,
+			Amount:      amount,
+			Description: field("description"),
+		}
+		transaction.ExternalID = ids.next(transaction)
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, nil
+}
+
+// ofxTag matches an OFX element and its value; OFX 1.x (SGML) leaves elements unclosed
+var ofxTag = regexp.MustCompile(`(?i)<(DTPOSTED|TRNAMT|FITID|NAME|MEMO|PAYEE)>([^<\r\n]*)`)
+
+// ParseOFX parses the STMTTRN elements of an OFX 1.x or 2.x statement
+func ParseOFX(r io.Reader) ([]BankTransaction, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	scanner.Split(splitOFXTransactions)
+
+	var transactions []BankTransaction
+	ids := rowIDs{}
+	for scanner.Scan() {
+		values := map[string]string{}
+		for _, match := range ofxTag.FindAllStringSubmatch(scanner.Text(), -1) {
+			tag := strings.ToUpper(match[1])
+			if _, seen := values[tag]; !seen {
+				values[tag] = strings.TrimSpace(match[2])
+			}
+		}
+
+		// DTPOSTED is YYYYMMDD optionally followed by time and timezone
+		if len(values["DTPOSTED"]) < 8 {
+			return nil, fmt.Errorf("transaction %q: missing DTPOSTED", values["FITID"])
+		}
+		date, err := time.Parse("20060102", values["DTPOSTED"][:8])
+		if err != nil {
+			return nil, fmt.Errorf("transaction %q: %w", values["FITID"], err)
+		}
+
+		amount, err := parseStatementAmount(values["TRNAMT"])
+		if err != nil {
+			return nil, fmt.Errorf("transaction %q: %w", values["FITID"], err)
+		}
+
+		description := values["NAME"]
+		if description == "" {
+			description = values["PAYEE"]
+		}
+		if memo := values["MEMO"]; memo != "" && memo != description {
+			description = strings.TrimSpace(description + " " + memo)
+		}
+
+		transaction := BankTransaction{
+			ExternalID:  values["FITID"],
+			Date:        date,
+			Amount:      amount,
+			Description: description,
+		}
+		if transaction.ExternalID == "" {
+			transaction.ExternalID = ids.next(transaction)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// splitOFXTransactions is a bufio.SplitFunc yielding the body of each <STMTTRN> element
+func splitOFXTransactions(data []byte, atEOF bool) (int, []byte, error) {
+	upper := bytes.ToUpper(data)
+	start := bytes.Index(upper, []byte("<STMTTRN>"))
+	if start < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+
+	end := bytes.Index(upper[start:], []byte("</STMTTRN>"))
+	if end < 0 {
+		if atEOF {
+			return len(data), data[start:], nil
+		}
+		return 0, nil, nil
+	}
+
+	return start + end + len("</STMTTRN>"), data[start : start+end], nil
+}
+
+func parseStatementDate(value string, order DateOrder) (time.Time, error) {
+	for _, layout := range statementDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return parseNumericDate(value, order)
+}
+
+// detectDateOrder returns the order that fits every numeric date, preferring day-first
+// when the dates read the same either way. It fails with ErrAmbiguousDates when the
+// dates fit both orders but read differently, as nothing shows which one is meant.
+func detectDateOrder(dates []string) (DateOrder, error) {
+	fits := map[DateOrder]bool{DayFirst: true, MonthFirst: true}
+	differ := false
+	for _, value := range dates {
+		dayFirst, dayFirstErr := parseNumericDate(value, DayFirst)
+		monthFirst, monthFirstErr := parseNumericDate(value, MonthFirst)
+		if dayFirstErr != nil && monthFirstErr != nil {
+			continue // Not a numeric date, or one no order accepts
+		}
+		fits[DayFirst] = fits[DayFirst] && dayFirstErr == nil
+		fits[MonthFirst] = fits[MonthFirst] && monthFirstErr == nil
+		differ = differ || (dayFirstErr == nil && monthFirstErr == nil && !dayFirst.Equal(monthFirst))
+	}
+
+	switch {
+	case fits[DayFirst] && fits[MonthFirst] && differ:
+		return "", ErrAmbiguousDates
+	case !fits[DayFirst] && fits[MonthFirst]:
+		return MonthFirst, nil
+	default:
+		// Dates that fit neither order fail on their own line
+		return DayFirst, nil
+	}
+}
+
+// parseNumericDate parses a numeric date in the given order
+func parseNumericDate(value string, order DateOrder) (time.Time, error) {
+	for _, layout := range numericDateLayouts[order] {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", value)
+}
+
+// parseStatementAmount parses amounts such as "-1,234.50", "(12.00)" or an empty cell
+func parseStatementAmount(value string) (float64, error) {
+	value = strings.NewReplacer(",", "", " ", "").Replace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	negative := strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")")
+	value = strings.Trim(value, "()")
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// rowIDs derives stable IDs for transactions without one, so uploading the same
+// (or an overlapping) statement twice does not duplicate transactions. Identical
+// rows, like two coffees on the same day, are told apart by their occurrence.
+type rowIDs map[string]int
+
+func (ids rowIDs) next(transaction BankTransaction) string {
+	key := fmt.Sprintf("%s|%.2f|%s", transaction.Date.Format("2006-01-02"), transaction.Amount, transaction.Description)
+	ids[key]++
+
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", key, ids[key])))
+	return hex.EncodeToString(sum[:])
+}
+
+func hasColumn(columns map[string]int, name string) bool {
+	_, ok := columns[name]
+	return ok
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
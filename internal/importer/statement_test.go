@@ -0,0 +1,62 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatementCSVDateOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		dates   []string
+		order   DateOrder
+		want    []string
+		wantErr error
+	}{
+		{name: "day-first shown by a later date", dates: []string{"03/04/2024", "25/04/2024"}, want: []string{"2024-04-03", "2024-04-25"}},
+		{name: "month-first shown by a later date", dates: []string{"03/04/2024", "04/25/2024"}, want: []string{"2024-03-04", "2024-04-25"}},
+		{name: "dashes", dates: []string{"03-04-2024", "04-25-2024"}, want: []string{"2024-03-04", "2024-04-25"}},
+		{name: "ambiguous", dates: []string{"03/04/2024", "05/06/2024"}, wantErr: ErrAmbiguousDates},
+		{name: "ambiguous with a given order", dates: []string{"03/04/2024", "05/06/2024"}, order: MonthFirst, want: []string{"2024-03-04", "2024-05-06"}},
+		{name: "same either way", dates: []string{"03/03/2024", "2024-04-05"}, want: []string{"2024-03-03", "2024-04-05"}},
+		{name: "not numeric", dates: []string{"2024-04-03", "05 Apr 2024"}, want: []string{"2024-04-03", "2024-04-05"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csv := "Date,Description,Amount\n"
+			for _, date := range tt.dates {
+				csv += date + ",Coffee,-3.50\n"
+			}
+
+			transactions, err := ParseStatementCSV(strings.NewReader(csv), tt.order)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, transaction := range transactions {
+				if got := transaction.Date.Format(time.DateOnly); got != tt.want[i] {
+					t.Errorf("date %q = %s, want %s", tt.dates[i], got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseStatementCSVRejectsMixedDateOrders(t *testing.T) {
+	csv := "Date,Description,Amount\n25/04/2024,Coffee,-3.50\n04/26/2024,Coffee,-3.50\n"
+	if _, err := ParseStatementCSV(strings.NewReader(csv), ""); err == nil {
+		t.Error("parsed a statement with day-first and month-first dates")
+	}
+	if _, err := ParseStatementCSV(strings.NewReader(csv), "ymd"); err == nil {
+		t.Error("parsed a statement with an unknown date order")
+	}
+}
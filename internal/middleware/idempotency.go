@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/database"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyHeader is the request header clients set to make retries safe
+const IdempotencyHeader = "Idempotency-Key"
+
+const (
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+	// idempotencyLockTTL bounds how long a key stays claimed if the API dies mid-request
+	idempotencyLockTTL = 5 * time.Minute
+	// idempotencyRetryAfter is how long clients are asked to wait while Redis is unreachable
+	idempotencyRetryAfter = 5 * time.Second
+)
+
+// idempotencyRecord is what is stored in Redis for a key: a marker while the first
+// request runs, then its response
+type idempotencyRecord struct {
+	Hash        string `json:"hash"` // Of the body
+	Done        bool   `json:"done"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Idempotency makes POST, PUT and DELETE requests carrying an Idempotency-Key header
+// safe to retry. Keys are scoped to the method and path, so one key may be used on
+// different routes. The first request with a key runs normally and its response is
+// kept for ttl; a retry with the same key and body gets that response again without
+// running the handler. Reusing a key with a different body is rejected with 422, and
+// retrying while the first request is still running with 409. Server errors are not
+// kept, so they can be retried.
+//
+// Keys are stored in Redis, so requests carrying one need Redis: while it is
+// unreachable they are rejected with 503 and a Retry-After header rather than run
+// without replay protection. Requests without a key are unaffected.
+func Idempotency(redisClient *database.RedisClient, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		method := c.Request.Method
+		if key == "" || (method != http.MethodPost && method != http.MethodPut && method != http.MethodDelete) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyHeader, maxIdempotencyKeyLength)})
+			return
+		}
+
+		// Read the body to hash it, then restore it for the handler
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.Sum256(body)
+		record := idempotencyRecord{Hash: hex.EncodeToString(hash[:])}
+
+		// The outcome is recorded even if the client disconnects
+		ctx := context.WithoutCancel(c.Request.Context())
+		cacheKey := fmt.Sprintf("idempotency:%s:%s:%s", method, c.Request.URL.Path, key)
+
+		// Claim the key; only the first request with it runs the handler
+		marker, _ := json.Marshal(record)
+		claimed, err := redisClient.Client.SetNX(ctx, cacheKey, marker, idempotencyLockTTL).Result()
+		if err != nil {
+			// Running the request without the claim could apply a retry twice
+			log.Printf("❌ Failed to claim idempotency key: %v", err)
+			abortUnavailable(c)
+			return
+		}
+
+		if !claimed {
+			var existing idempotencyRecord
+			data, err := redisClient.Client.Get(ctx, cacheKey).Bytes()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				log.Printf("❌ Failed to read idempotency key: %v", err)
+				abortUnavailable(c)
+				return
+			}
+			if err != nil || json.Unmarshal(data, &existing) != nil {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+				return
+			}
+
+			switch {
+			case existing.Hash != record.Hash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request body"})
+			case !existing.Done:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		completed := false
+		defer func() {
+			// Release the key if the handler panicked or failed, so the client can retry with it
+			if !completed {
+				redisClient.Client.Del(ctx, cacheKey)
+			}
+		}()
+
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+		completed = true
+
+		record.Done = true
+		record.StatusCode = recorder.Status()
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		data, _ := json.Marshal(record)
+		if err := redisClient.Client.Set(ctx, cacheKey, data, ttl).Err(); err != nil {
+			// The claim expires after idempotencyLockTTL; retries are rejected as in progress until then
+			log.Printf("❌ Failed to save idempotent response: %v", err)
+		}
+	}
+}
+
+// abortUnavailable rejects a request with an idempotency key while Redis is unreachable
+func abortUnavailable(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(int(idempotencyRetryAfter.Seconds())))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Idempotency-Key cannot be checked right now, retry later"})
+}
+
+// responseRecorder keeps a copy of the response body as it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
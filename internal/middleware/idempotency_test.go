@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"expense-split-wise/internal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// newIdempotentRouter serves POST /count and POST /count/:name behind Idempotency and
+// returns how many times their handler ran
+func newIdempotentRouter(t *testing.T) (*gin.Engine, *miniredis.Miniredis, *int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	redisClient, server := testutil.NewRedis(t)
+
+	calls := 0
+	router := gin.New()
+	router.Use(Idempotency(redisClient, time.Hour))
+	count := func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"calls": calls})
+	}
+	router.POST("/count", count)
+	router.POST("/count/:name", count)
+	return router, server, &calls
+}
+
+func post(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	return postTo(router, "/count", key, body)
+}
+
+func postTo(router *gin.Engine, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	router, _, calls := newIdempotentRouter(t)
+
+	first := post(router, "key-1", "{}")
+	replayed := post(router, "key-1", "{}")
+	if first.Code != http.StatusCreated || replayed.Code != http.StatusCreated || replayed.Body.String() != first.Body.String() {
+		t.Errorf("responses %d %s and %d %s, want the first replayed", first.Code, first.Body, replayed.Code, replayed.Body)
+	}
+	if replayed.Header().Get("Idempotent-Replayed") != "true" || *calls != 1 {
+		t.Errorf("handler ran %d times, want once with the retry replayed", *calls)
+	}
+
+	if rec := post(router, "key-1", `{"other": true}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reusing a key with another body = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyScopesKeysToTheRoute(t *testing.T) {
+	router, _, calls := newIdempotentRouter(t)
+
+	for _, path := range []string{"/count", "/count/a", "/count/b"} {
+		if rec := postTo(router, path, "key-1", "{}"); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("POST %s = %d, want the handler run", path, rec.Code)
+		}
+	}
+	if *calls != 3 {
+		t.Errorf("handler ran %d times, want once per path", *calls)
+	}
+
+	if rec := postTo(router, "/count/a", "key-1", "{}"); rec.Header().Get("Idempotent-Replayed") != "true" || *calls != 3 {
+		t.Errorf("retry on /count/a ran the handler, want its response replayed")
+	}
+}
+
+func TestIdempotencyRejectsKeysWithoutRedis(t *testing.T) {
+	router, server, calls := newIdempotentRouter(t)
+	server.Close()
+
+	rec := post(router, "key-1", "{}")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("response = %d with Retry-After %q, want %d with a Retry-After", rec.Code, rec.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+	if *calls != 0 {
+		t.Errorf("handler ran %d times without Redis, want 0", *calls)
+	}
+
+	// Requests without a key do not need Redis
+	if rec := post(router, "", "{}"); rec.Code != http.StatusCreated || *calls != 1 {
+		t.Errorf("request without a key = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
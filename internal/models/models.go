@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,6 +16,11 @@ type Group struct {
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
+// CategoryPayment marks an expense that settles a debt between members rather
+// than shared spending: the payer sends Amount to the members in SplitBetween.
+// Matches the category Splitwise uses for payments.
+const CategoryPayment = "Payment"
+
 // Expense represents a shared expense in a group
 type Expense struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
@@ -23,20 +29,335 @@ type Expense struct {
 	Amount       float64            `json:"amount" bson:"amount"`
 	PaidBy       string             `json:"paidBy" bson:"paidBy"`             // User who paid
 	SplitBetween []string           `json:"splitBetween" bson:"splitBetween"` // Users to split between
+	Category     string             `json:"category,omitempty" bson:"category,omitempty"`
+	Currency     string             `json:"currency,omitempty" bson:"currency,omitempty"`
+	Notes        string             `json:"notes,omitempty" bson:"notes,omitempty"`
+	Comments     []string           `json:"comments,omitempty" bson:"comments,omitempty"`
 	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
 }
 
+// Participants returns the members the expense is split between. An expense split
+// between nobody, which the API rejects but older imports may hold, is the payer's own.
+func (e *Expense) Participants() []string {
+	if len(e.SplitBetween) == 0 {
+		return []string{e.PaidBy}
+	}
+	return e.SplitBetween
+}
+
+// ExpenseSearchResult is an expense matched by a full-text search
+type ExpenseSearchResult struct {
+	Expense    `bson:",inline"`
+	Score      float64           `json:"score" bson:"score"`
+	Highlights map[string]string `json:"highlights,omitempty" bson:"-"` // field -> text with matched terms marked
+}
+
 // Balance represents the balance sheet for a group
 type Balance struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	GroupID   primitive.ObjectID `json:"groupId" bson:"groupId"`
-	Balances  map[string]float64 `json:"balances" bson:"balances"` // user -> amount (positive = owed, negative = owes)
-	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GroupID         primitive.ObjectID `json:"groupId" bson:"groupId"`
+	Balances        map[string]float64 `json:"balances" bson:"balances"`           // user -> amount (positive = owed, negative = owes)
+	Applied         []string           `json:"-" bson:"applied,omitempty"`         // Recently applied delta keys, see BalanceService.ApplyDelta
+	EventSeq        int64              `json:"-" bson:"eventSeq,omitempty"`        // Sequence number of the group's last expense event
+	RecalculatedSeq int64              `json:"-" bson:"recalculatedSeq,omitempty"` // Last expense event counted by a recalculation, see BalanceService.RecalculateBalances
+	UpdatedAt       time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
+// Expense events carried by ExpenseMessage and delivered to webhooks
+const (
+	EventExpenseCreated     = "expense.created"
+	EventExpenseUpdated     = "expense.updated"
+	EventExpenseDeleted     = "expense.deleted"
+	EventSettlementRecorded = "settlement.recorded"
+	EventBalancesChanged    = "balances.changed"
+)
+
+// EventBudgetAlert is delivered to webhooks when spending crosses a budget threshold
+const EventBudgetAlert = "budget.alert"
+
+// EventStreamReset is streamed to a client resuming after events that are no longer
+// kept. It should fetch the group's state again rather than rely on the events.
+const EventStreamReset = "reset"
+
 // ExpenseMessage represents the message sent to RabbitMQ
 type ExpenseMessage struct {
-	GroupID   string  `json:"groupId"`
-	ExpenseID string  `json:"expenseId"`
-	Amount    float64 `json:"amount"`
+	EventID   string             `json:"eventId,omitempty"` // Unique per message, used to deduplicate redeliveries
+	Event     string             `json:"event,omitempty"`   // One of the expense events; empty means created
+	GroupID   string             `json:"groupId"`
+	ExpenseID string             `json:"expenseId"`
+	Amount    float64            `json:"amount"`
+	Delta     map[string]float64 `json:"delta"`         // Change to each member's balance; absent (from older publishers) means recalculate
+	Seq       int64              `json:"seq,omitempty"` // Orders the event against balance recalculations; absent from older publishers
+}
+
+// ImportReport describes the changes an import makes (or would make, on a dry run) to a group
+type ImportReport struct {
+	DryRun     bool         `json:"dryRun"`
+	NewMembers []string     `json:"newMembers"`
+	Expenses   []Expense    `json:"expenses"`   // Expenses created, or to be created on a dry run
+	Duplicates []Expense    `json:"duplicates"` // Rows matching an existing expense, not imported
+	Skipped    []SkippedRow `json:"skipped"`    // Rows that cannot be represented
+}
+
+// SkippedRow is an import row that was not imported
+type SkippedRow struct {
+	Line        int    `json:"line"`
+	Description string `json:"description"`
+	Reason      string `json:"reason"`
+}
+
+// Statement transaction statuses
+const (
+	TransactionLinked   = "linked"   // Matched to an existing expense
+	TransactionPending  = "pending"  // Proposed as a new expense, awaiting review
+	TransactionAccepted = "accepted" // Proposal accepted, expense created
+	TransactionRejected = "rejected" // Proposal rejected
+)
+
+// StatementTransaction is a spending transaction imported from a member's bank or card statement
+type StatementTransaction struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	GroupID     primitive.ObjectID  `json:"groupId" bson:"groupId"`
+	Member      string              `json:"member" bson:"member"`
+	ExternalID  string              `json:"externalId" bson:"externalId"` // FITID, or a hash of the row for CSV
+	Date        time.Time           `json:"date" bson:"date"`
+	Amount      float64             `json:"amount" bson:"amount"` // Money spent, always positive
+	Description string              `json:"description" bson:"description"`
+	Status      string              `json:"status" bson:"status"`
+	ExpenseID   *primitive.ObjectID `json:"expenseId,omitempty" bson:"expenseId,omitempty"` // Linked or created expense
+	MatchScore  float64             `json:"matchScore,omitempty" bson:"matchScore,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// StatementImportResult summarises a statement upload
+type StatementImportResult struct {
+	Linked     []StatementTransaction `json:"linked"`
+	Proposed   []StatementTransaction `json:"proposed"`
+	Duplicates int                    `json:"duplicates"` // Transactions already imported earlier
+	Ignored    int                    `json:"ignored"`    // Credits and zero-amount rows
+}
+
+// MemberStatement is a member's account of a group over a period
+type MemberStatement struct {
+	GroupID        primitive.ObjectID     `json:"groupId"`
+	GroupName      string                 `json:"groupName"`
+	Member         string                 `json:"member"`
+	From           time.Time              `json:"from"`
+	To             time.Time              `json:"to"`
+	OpeningBalance float64                `json:"openingBalance"`
+	ClosingBalance float64                `json:"closingBalance"`
+	Entries        []MemberStatementEntry `json:"entries"`
+}
+
+// MemberStatementEntry is an expense or settlement affecting the member
+type MemberStatementEntry struct {
+	ExpenseID   primitive.ObjectID `json:"expenseId"`
+	Date        time.Time          `json:"date"`
+	Description string             `json:"description"`
+	Category    string             `json:"category,omitempty"`
+	PaidBy      string             `json:"paidBy"`
+	Amount      float64            `json:"amount"`  // Full expense amount
+	Share       float64            `json:"share"`   // Member's share of the amount, 0 if not a participant
+	Effect      float64            `json:"effect"`  // Change to the member's balance
+	Balance     float64            `json:"balance"` // Running balance after this entry
+	Settlement  bool               `json:"settlement"`
+}
+
+// GroupAnalytics summarises a group's spending over a period. Settlements are excluded.
+type GroupAnalytics struct {
+	GroupID    primitive.ObjectID `json:"groupId"`
+	From       *time.Time         `json:"from,omitempty"`
+	To         *time.Time         `json:"to,omitempty"`
+	Timezone   string             `json:"timezone"`
+	Total      float64            `json:"total"`
+	Count      int                `json:"count"`
+	Members    []MemberSpending   `json:"members"`
+	Categories []SpendingTotal    `json:"categories"`
+	Months     []SpendingTotal    `json:"months"`   // Key is YYYY-MM in the requested timezone
+	Weekdays   []SpendingTotal    `json:"weekdays"` // Key is the weekday name, Sunday first
+}
+
+// MemberSpending is what a member paid for versus what they consumed
+type MemberSpending struct {
+	Member   string  `json:"member"`
+	Paid     float64 `json:"paid"`
+	Consumed float64 `json:"consumed"`
+	Net      float64 `json:"net"` // Paid - Consumed
+}
+
+// SpendingTotal is the spending in one bucket (category, month or weekday)
+type SpendingTotal struct {
+	Key   string  `json:"key"`
+	Total float64 `json:"total"`
+	Count int     `json:"count"`
+}
+
+// Budget periods
+const (
+	BudgetMonthly = "monthly" // Resets every calendar month in the budget's timezone
+	BudgetTrip    = "trip"    // One period between StartDate and EndDate (open-ended if unset)
+)
+
+// Budget caps a group's spending, overall or in one category, per period
+type Budget struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GroupID   primitive.ObjectID `json:"groupId" bson:"groupId"`
+	Name      string             `json:"name" bson:"name"`
+	Category  string             `json:"category,omitempty" bson:"category,omitempty"` // Empty for all spending
+	Amount    float64            `json:"amount" bson:"amount"`
+	Period    string             `json:"period" bson:"period"`
+	Timezone  string             `json:"timezone" bson:"timezone"`
+	StartDate *time.Time         `json:"startDate,omitempty" bson:"startDate,omitempty"`
+	EndDate   *time.Time         `json:"endDate,omitempty" bson:"endDate,omitempty"`
+	Alerts    map[string][]int   `json:"-" bson:"alerts,omitempty"` // Period key -> thresholds already alerted
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// BudgetStatus is a budget's spending in its current period
+type BudgetStatus struct {
+	Budget
+	PeriodStart *time.Time `json:"periodStart,omitempty"`
+	PeriodEnd   *time.Time `json:"periodEnd,omitempty"`
+	Spent       float64    `json:"spent"`
+	Remaining   float64    `json:"remaining"`
+	Percent     float64    `json:"percent"`
+}
+
+// BudgetAlert is the data of a budget.alert webhook event
+type BudgetAlert struct {
+	GroupID   string    `json:"groupId"`
+	BudgetID  string    `json:"budgetId"`
+	Name      string    `json:"name"`
+	Category  string    `json:"category,omitempty"`
+	Period    string    `json:"period"`    // Period key, e.g. "2024-01" or "trip"
+	Threshold int       `json:"threshold"` // Percent of the budget crossed
+	Spent     float64   `json:"spent"`
+	Amount    float64   `json:"amount"`
+	ExpenseID string    `json:"expenseId"` // Expense that crossed the threshold
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookSubscription delivers a group's events to an HTTP endpoint
+type WebhookSubscription struct {
+	ID                  primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GroupID             primitive.ObjectID `json:"groupId" bson:"groupId"`
+	URL                 string             `json:"url" bson:"url"`
+	Secret              string             `json:"-" bson:"secret"` // HMAC key, never returned
+	Events              []string           `json:"events" bson:"events"`
+	Active              bool               `json:"active" bson:"active"`
+	ConsecutiveFailures int                `json:"consecutiveFailures" bson:"consecutiveFailures"`
+	DisabledAt          *time.Time         `json:"disabledAt,omitempty" bson:"disabledAt,omitempty"`
+	CreatedAt           time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // Gave up after the last retry
+)
+
+// WebhookDelivery is one event to be delivered to a subscription, with its attempt history
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionID primitive.ObjectID `json:"subscriptionId" bson:"subscriptionId"`
+	GroupID        primitive.ObjectID `json:"groupId" bson:"groupId"`
+	EventID        string             `json:"eventId" bson:"eventId"`
+	Event          string             `json:"event" bson:"event"`
+	Payload        string             `json:"payload" bson:"payload"` // JSON body sent to the endpoint
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LastAttemptAt  *time.Time         `json:"lastAttemptAt,omitempty" bson:"lastAttemptAt,omitempty"`
+	ResponseStatus int                `json:"responseStatus,omitempty" bson:"responseStatus,omitempty"`
+	LastError      string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// WebhookEvent is the JSON body posted to webhook endpoints
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	GroupID   string      `json:"groupId"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// Notification kinds; each can be opted out of per user
+const (
+	NotificationMemberAdded     = "member_added"
+	NotificationExpenseAdded    = "expense_added"
+	NotificationPaymentReceived = "payment_received"
+	NotificationWeeklyDigest    = "weekly_digest"
+)
+
+// NotificationMessage asks the worker to email members about a group event
+type NotificationMessage struct {
+	EventID   string        `json:"eventId"` // Unique per event, used to avoid emailing a member twice
+	Kind      string        `json:"kind"`
+	GroupID   string        `json:"groupId"`
+	ExpenseID string        `json:"expenseId,omitempty"`
+	Members   []string      `json:"members,omitempty"` // Members added, for member_added; the recipient, for weekly_digest
+	Digest    *WeeklyDigest `json:"digest,omitempty"`
+}
+
+// NotificationPreferences holds a user's email address and the notifications they opted out of
+type NotificationPreferences struct {
+	User      string    `json:"user" bson:"_id"`
+	Email     string    `json:"email" bson:"email"`
+	OptOut    []string  `json:"optOut" bson:"optOut"` // Notification kinds not to send
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Settlement is a suggested payment that settles balances
+type Settlement struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+// WeeklyDigest summarises a user's week across all their groups
+type WeeklyDigest struct {
+	User   string        `json:"user"`
+	Week   string        `json:"week"` // ISO week, e.g. 2024-W07
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"` // Exclusive
+	Groups []DigestGroup `json:"groups"`
+}
+
+// DigestRun records the sending of a week's digests, see DigestService.RunDue
+type DigestRun struct {
+	Week        string     `json:"week" bson:"_id"`
+	StartedAt   time.Time  `json:"startedAt" bson:"startedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	Digests     int        `json:"digests" bson:"digests"` // Digests queued, once completed
+}
+
+// DigestGroup is one group's part of a weekly digest
+type DigestGroup struct {
+	GroupID     primitive.ObjectID `json:"groupId"`
+	Name        string             `json:"name"`
+	NewExpenses []Expense          `json:"newExpenses"` // Expenses involving the user created during the week
+	Balance     float64            `json:"balance"`     // The user's current balance in the group
+	Settlements []Settlement       `json:"settlements"` // Suggested payments involving the user
+}
+
+// GroupEvent is a change to a group streamed to clients over Server-Sent Events
+type GroupEvent struct {
+	ID        string          `json:"id"` // Redis stream ID, sent as the SSE id for resuming
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// OutboxEntry is a message saved with the change it announces, in the same
+// transaction, and published to the queue afterwards
+type OutboxEntry struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Queue         string             `json:"queue" bson:"queue"`
+	Body          []byte             `json:"body" bson:"body"` // JSON message
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+	SentAt        *time.Time         `json:"sentAt,omitempty" bson:"sentAt,omitempty"`
 }
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"expense-split-wise/internal/models"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// defaultTemplates are used for any kind without a template file. Each template
+// defines a "subject" and a "body".
+var defaultTemplates = map[string]string{
+	models.NotificationMemberAdded: `{{define "subject"}}You were added to {{.Group.Name}}{{end}}
+{{define "body"}}Hi {{.Recipient}},
+
+You were added to the group "{{.Group.Name}}".
+Members: {{join .Group.Members ", "}}
+{{end}}`,
+
+	models.NotificationExpenseAdded: `{{define "subject"}}{{.Expense.PaidBy}} added "{{.Expense.Description}}" in {{.Group.Name}}{{end}}
+{{define "body"}}Hi {{.Recipient}},
+
+{{.Expense.PaidBy}} added an expense in "{{.Group.Name}}":
+
+  {{.Expense.Description}}
+  Total:      {{amount .Expense.Amount}} {{.Currency}}
+  Your share: {{amount .Share}} {{.Currency}}
+  Split with: {{join .Expense.SplitBetween ", "}}
+{{end}}`,
+
+	models.NotificationPaymentReceived: `{{define "subject"}}{{.Expense.PaidBy}} paid you {{amount .Share}} {{.Currency}}{{end}}
+{{define "body"}}Hi {{.Recipient}},
+
+{{.Expense.PaidBy}} recorded a payment of {{amount .Share}} {{.Currency}} to you in "{{.Group.Name}}".
+{{end}}`,
+
+	models.NotificationWeeklyDigest: `{{define "subject"}}Your week in Splitwise: {{.Digest.From.Format "2 Jan"}} to {{(.Digest.To.AddDate 0 0 -1).Format "2 Jan"}}{{end}}
+{{define "body"}}Hi {{.Recipient}},
+
+Here is your summary for {{.Digest.From.Format "2 Jan"}} to {{(.Digest.To.AddDate 0 0 -1).Format "2 Jan 2006"}}.
+{{range .Digest.Groups}}
+{{.Name}}
+{{- if .NewExpenses}}
+  New expenses:
+{{- range .NewExpenses}}
+    {{.CreatedAt.Format "Mon 2 Jan"}}  {{.Description}}: {{amount .Amount}} {{$.Currency}}, paid by {{.PaidBy}}
+{{- end}}
+{{- end}}
+  Your balance: {{amount .Balance}} {{$.Currency}}{{if gt .Balance 0.0}} (you are owed){{else if lt .Balance 0.0}} (you owe){{end}}
+{{- if .Settlements}}
+  Suggested settlements:
+{{- range .Settlements}}
+    {{.From}} pays {{.To}} {{amount .Amount}} {{$.Currency}}
+{{- end}}
+{{- end}}
+{{end}}{{end}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"join":   strings.Join,
+	"amount": func(v float64) string { return fmt.Sprintf("%.2f", v) },
+}
+
+// EmailData is what notification templates are rendered with
+type EmailData struct {
+	Recipient string
+	Group     *models.Group        // Nil for weekly_digest
+	Expense   *models.Expense      // Nil for member_added and weekly_digest
+	Share     float64              // The recipient's share of the expense, or the amount paid to them
+	Digest    *models.WeeklyDigest // Only for weekly_digest
+	Currency  string
+}
+
+// Templates renders notification emails by kind
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+// LoadTemplates parses the default templates, replacing any that have a
+// <kind>.tmpl file in dir. An empty dir uses only the defaults.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{templates: make(map[string]*template.Template)}
+
+	for kind, text := range defaultTemplates {
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, kind+".tmpl"))
+			if err == nil {
+				text = string(data)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+
+		tmpl, err := template.New(kind).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", kind, err)
+		}
+		for _, name := range []string{"subject", "body"} {
+			if tmpl.Lookup(name) == nil {
+				return nil, fmt.Errorf("template %s: missing %q", kind, name)
+			}
+		}
+		t.templates[kind] = tmpl
+	}
+
+	return t, nil
+}
+
+// Render returns the subject and body of a notification
+func (t *Templates) Render(kind string, data *EmailData) (string, string, error) {
+	tmpl, ok := t.templates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("unknown notification kind %q", kind)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+
+	// Subjects are a single header line
+	return strings.Join(strings.Fields(subject.String()), " "), strings.TrimLeft(body.String(), "\n"), nil
+}
@@ -0,0 +1,332 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// errAlreadySettled is returned when a delivery is acked or nacked a second time
+var errAlreadySettled = errors.New("delivery already acknowledged or rejected")
+
+// MemoryBroker is an in-process broker for tests and single-process local runs.
+// It follows RabbitMQ's semantics: messages stay on their queue until acknowledged,
+// a nacked message is requeued at the front or dead-lettered, consumers of a queue
+// share its messages, and queues with a message TTL dead-letter expired messages.
+// Nothing is persisted.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	changed   *sync.Cond // Broadcast whenever a consumer may be able to take a message
+	queues    map[string]*memoryQueue
+	consumers map[string]*memoryConsumer
+	prefetch  int
+	closed    bool
+
+	retrier
+}
+
+// memoryQueue is a declared queue and the messages waiting on it
+type memoryQueue struct {
+	ttl        time.Duration // Zero for no expiry
+	deadLetter string        // Queue that rejected and expired messages move to, if any
+	ready      []*memoryMessage
+}
+
+type memoryMessage struct {
+	id          string
+	contentType string
+	headers     map[string]interface{}
+	body        []byte
+	redelivered bool
+}
+
+// memoryConsumer is a subscription to a queue
+type memoryConsumer struct {
+	queue    *memoryQueue
+	out      chan Delivery
+	prefetch int
+	unacked  int
+	done     chan struct{} // Closed on cancellation or Close
+}
+
+// NewMemoryBroker returns an empty in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		queues:    make(map[string]*memoryQueue),
+		consumers: make(map[string]*memoryConsumer),
+	}
+	b.changed = sync.NewCond(&b.mu)
+	b.retrier = newRetrier(b.declare, b.republish)
+	return b
+}
+
+// DeclareQueue declares a queue
+func (b *MemoryBroker) DeclareQueue(queueName string) error {
+	return b.declare(queueName, nil)
+}
+
+// declare declares a queue, understanding the TTL and dead-letter arguments
+// DeclareRetryQueues uses. Declaring an existing queue again changes nothing.
+func (b *MemoryBroker) declare(queueName string, args amqp.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	if _, ok := b.queues[queueName]; ok {
+		return nil
+	}
+
+	q := &memoryQueue{}
+	if ttl, ok := args["x-message-ttl"].(int64); ok {
+		q.ttl = time.Duration(ttl) * time.Millisecond
+	}
+	if deadLetter, ok := args["x-dead-letter-routing-key"].(string); ok {
+		q.deadLetter = deadLetter
+	}
+	b.queues[queueName] = q
+	return nil
+}
+
+// PublishMessage publishes a message to a queue
+func (b *MemoryBroker) PublishMessage(queueName string, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return b.publish(queueName, &memoryMessage{
+		id:          newMessageID(),
+		contentType: "application/json",
+		body:        body,
+	})
+}
+
+// republish publishes a received message to another queue
+func (b *MemoryBroker) republish(queueName string, msg Delivery) error {
+	return b.publish(queueName, &memoryMessage{
+		id:          msg.MessageID,
+		contentType: msg.ContentType,
+		headers:     msg.Headers,
+		body:        msg.Body,
+	})
+}
+
+func (b *MemoryBroker) publish(queueName string, msg *memoryMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnroutable, queueName)
+	}
+
+	b.enqueue(q, msg)
+	return nil
+}
+
+// enqueue adds a message to the back of a queue, and schedules its expiry if the
+// queue has a TTL. Call with b.mu held.
+func (b *MemoryBroker) enqueue(q *memoryQueue, msg *memoryMessage) {
+	q.ready = append(q.ready, msg)
+	b.changed.Broadcast()
+
+	if q.ttl > 0 {
+		time.AfterFunc(q.ttl, func() { b.expire(q, msg) })
+	}
+}
+
+// expire dead-letters a message whose TTL passed while it waited on its queue
+func (b *MemoryBroker) expire(q *memoryQueue, msg *memoryMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := slices.Index(q.ready, msg)
+	if i < 0 || b.closed {
+		return // Already consumed
+	}
+	q.ready = slices.Delete(q.ready, i, i+1)
+	b.deadLetter(q, msg)
+}
+
+// deadLetter moves a message to its queue's dead-letter queue, or drops it if the
+// queue has none. Call with b.mu held.
+func (b *MemoryBroker) deadLetter(q *memoryQueue, msg *memoryMessage) {
+	if target, ok := b.queues[q.deadLetter]; ok {
+		b.enqueue(target, &memoryMessage{
+			id:          msg.id,
+			contentType: msg.contentType,
+			headers:     msg.headers,
+			body:        msg.body,
+		})
+	}
+}
+
+// SetPrefetch limits how many unacknowledged messages each consumer started
+// afterwards is sent
+func (b *MemoryBroker) SetPrefetch(count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prefetch = count
+	return nil
+}
+
+// ConsumeMessages consumes messages from a queue. The consumer tag identifies the
+// consumer to CancelConsumer, which closes the returned channel.
+func (b *MemoryBroker) ConsumeMessages(queueName, consumerTag string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("queue %s not declared", queueName)
+	}
+	if _, ok := b.consumers[consumerTag]; ok {
+		return nil, fmt.Errorf("consumer %s already exists", consumerTag)
+	}
+
+	c := &memoryConsumer{
+		queue:    q,
+		out:      make(chan Delivery),
+		prefetch: b.prefetch,
+		done:     make(chan struct{}),
+	}
+	b.consumers[consumerTag] = c
+
+	go b.deliver(c)
+	return c.out, nil
+}
+
+// deliver sends a consumer the messages of its queue until it is cancelled
+func (b *MemoryBroker) deliver(c *memoryConsumer) {
+	defer close(c.out)
+
+	for {
+		b.mu.Lock()
+		for !isDone(c.done) && (len(c.queue.ready) == 0 || (c.prefetch > 0 && c.unacked >= c.prefetch)) {
+			b.changed.Wait()
+		}
+		if isDone(c.done) {
+			b.mu.Unlock()
+			return
+		}
+
+		msg := c.queue.ready[0]
+		c.queue.ready = c.queue.ready[1:]
+		c.unacked++
+		b.mu.Unlock()
+
+		delivery := Delivery{
+			MessageID:    msg.id,
+			ContentType:  msg.contentType,
+			Headers:      msg.headers,
+			Body:         msg.body,
+			Redelivered:  msg.redelivered,
+			acknowledger: &memoryAcknowledger{broker: b, consumer: c, msg: msg},
+		}
+
+		select {
+		case c.out <- delivery:
+		case <-c.done:
+			// Cancelled before the message was received; put it back
+			delivery.Nack(true)
+			return
+		}
+	}
+}
+
+// CancelConsumer stops new deliveries to a consumer and closes its delivery channel.
+// Deliveries already received can still be acknowledged.
+func (b *MemoryBroker) CancelConsumer(consumerTag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.consumers[consumerTag]
+	if !ok {
+		return fmt.Errorf("consumer %s not found", consumerTag)
+	}
+	delete(b.consumers, consumerTag)
+	close(c.done)
+	b.changed.Broadcast()
+	return nil
+}
+
+// Close stops every consumer. Publishes and acknowledgements afterwards fail.
+func (b *MemoryBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for tag, c := range b.consumers {
+		delete(b.consumers, tag)
+		close(c.done)
+	}
+	b.changed.Broadcast()
+}
+
+// memoryAcknowledger settles a delivery from a MemoryBroker
+type memoryAcknowledger struct {
+	broker   *MemoryBroker
+	consumer *memoryConsumer
+	msg      *memoryMessage
+	settled  bool
+}
+
+func (a *memoryAcknowledger) ack() error {
+	b := a.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return a.settle()
+}
+
+func (a *memoryAcknowledger) nack(requeue bool) error {
+	b := a.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := a.settle(); err != nil {
+		return err
+	}
+
+	q := a.consumer.queue
+	if requeue {
+		a.msg.redelivered = true
+		q.ready = slices.Insert(q.ready, 0, a.msg)
+		return nil
+	}
+	b.deadLetter(q, a.msg)
+	return nil
+}
+
+// settle marks the delivery settled, freeing its consumer's prefetch slot. Call with
+// the broker's lock held.
+func (a *memoryAcknowledger) settle() error {
+	if a.broker.closed {
+		return ErrClosed
+	}
+	if a.settled {
+		return errAlreadySettled
+	}
+
+	a.settled = true
+	a.consumer.unacked--
+	a.broker.changed.Broadcast()
+	return nil
+}
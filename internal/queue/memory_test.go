@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// receive returns the next delivery of deliveries
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+
+	select {
+	case msg, ok := <-deliveries:
+		if !ok {
+			t.Fatal("delivery channel closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return Delivery{}
+	}
+}
+
+// receiveBody returns the next delivery of deliveries and checks its body
+func receiveBody(t *testing.T, deliveries <-chan Delivery, want string) Delivery {
+	t.Helper()
+
+	msg := receive(t, deliveries)
+	var body string
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != want {
+		t.Fatalf("received %q, want %q", body, want)
+	}
+	return msg
+}
+
+// expectNone fails if a message arrives on deliveries shortly
+func expectNone(t *testing.T, deliveries <-chan Delivery) {
+	t.Helper()
+
+	select {
+	case msg := <-deliveries:
+		t.Fatalf("unexpected message %s", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// newTestBroker returns a memory broker with the given prefetch and declared queues
+func newTestBroker(t *testing.T, prefetch int, queues ...string) *MemoryBroker {
+	t.Helper()
+
+	broker := NewMemoryBroker()
+	t.Cleanup(broker.Close)
+	if err := broker.SetPrefetch(prefetch); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range queues {
+		if err := broker.DeclareQueue(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return broker
+}
+
+func consume(t *testing.T, broker Broker, queueName string) <-chan Delivery {
+	t.Helper()
+
+	deliveries, err := broker.ConsumeMessages(queueName, queueName+"-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func publish(t *testing.T, broker Broker, queueName string, bodies ...string) {
+	t.Helper()
+
+	for _, body := range bodies {
+		if err := broker.PublishMessage(queueName, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryBrokerAck(t *testing.T) {
+	broker := newTestBroker(t, 0, "work")
+	publish(t, broker, "work", "a")
+	deliveries := consume(t, broker, "work")
+
+	msg := receiveBody(t, deliveries, "a")
+	if msg.Redelivered || msg.MessageID == "" {
+		t.Errorf("delivery = %+v, want a first delivery with a message ID", msg)
+	}
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Ack(); !errors.Is(err, errAlreadySettled) {
+		t.Errorf("second Ack = %v, want %v", err, errAlreadySettled)
+	}
+	expectNone(t, deliveries)
+
+	if err := broker.PublishMessage("missing", "b"); !errors.Is(err, ErrUnroutable) {
+		t.Errorf("publish to an undeclared queue = %v, want %v", err, ErrUnroutable)
+	}
+}
+
+func TestMemoryBrokerNackRequeuesAtFront(t *testing.T) {
+	// With a prefetch of 1, b waits on the queue while a is unacknowledged
+	broker := newTestBroker(t, 1, "work")
+	publish(t, broker, "work", "a", "b")
+	deliveries := consume(t, broker, "work")
+
+	if err := receiveBody(t, deliveries, "a").Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	redelivered := receiveBody(t, deliveries, "a")
+	if !redelivered.Redelivered {
+		t.Error("requeued message not marked redelivered")
+	}
+	if err := redelivered.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiveBody(t, deliveries, "b").Ack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryBrokerNackDeadLetters(t *testing.T) {
+	broker := newTestBroker(t, 0, "work.dlq")
+	if err := broker.declare("work", amqp.Table{"x-dead-letter-routing-key": "work.dlq"}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, "work", "a")
+
+	if err := receiveBody(t, consume(t, broker, "work"), "a").Nack(false); err != nil {
+		t.Fatal(err)
+	}
+	receiveBody(t, consume(t, broker, "work.dlq"), "a").Ack()
+}
+
+func TestMemoryBrokerPrefetch(t *testing.T) {
+	broker := newTestBroker(t, 2, "work")
+	publish(t, broker, "work", "a", "b", "c")
+	deliveries := consume(t, broker, "work")
+
+	a := receiveBody(t, deliveries, "a")
+	receiveBody(t, deliveries, "b")
+	expectNone(t, deliveries)
+
+	// Settling a delivery frees a slot for the next message
+	if err := a.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	receiveBody(t, deliveries, "c")
+}
+
+func TestMemoryBrokerTTLDeadLetters(t *testing.T) {
+	broker := newTestBroker(t, 0, "work.dlq")
+	err := broker.declare("work.delayed", amqp.Table{
+		"x-message-ttl":             int64(20),
+		"x-dead-letter-routing-key": "work.dlq",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, "work.delayed", "a")
+
+	dlq := consume(t, broker, "work.dlq")
+	start := time.Now()
+	receiveBody(t, dlq, "a").Ack()
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("message dead-lettered after %v, before its TTL", waited)
+	}
+}
+
+func TestMemoryBrokerRetryDeadLettersAfterMaxAttempts(t *testing.T) {
+	broker := newTestBroker(t, 0)
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second}
+	if err := broker.DeclareRetryQueues("work", policy); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, "work", "a")
+	deliveries := consume(t, broker, "work")
+
+	msg := receiveBody(t, deliveries, "a")
+	messageID := msg.MessageID
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		if err := broker.Retry("work", msg, errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Back on the queue once the retry queue's TTL passes
+		msg = receiveBody(t, deliveries, "a")
+		if RetryCount(msg) != attempt || msg.MessageID != messageID {
+			t.Fatalf("retry %d has count %d and ID %s, want %d and %s", attempt, RetryCount(msg), msg.MessageID, attempt, messageID)
+		}
+	}
+
+	if err := broker.Retry("work", msg, errors.New("still failing")); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, deliveries)
+
+	dead := receiveBody(t, consume(t, broker, DeadLetterQueueName("work")), "a")
+	if RetryCount(dead) != policy.MaxAttempts || dead.Headers[LastErrorHeader] != "still failing" {
+		t.Errorf("dead-lettered headers = %v, want %d attempts and the last error", dead.Headers, policy.MaxAttempts)
+	}
+	if err := msg.Ack(); !errors.Is(err, errAlreadySettled) {
+		t.Errorf("Ack after Retry = %v, want the original already acknowledged", err)
+	}
+}
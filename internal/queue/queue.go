@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Queue backends selectable with Open
+const (
+	BackendRabbitMQ = "rabbitmq"
+	BackendRedis    = "redis"
+)
+
+var (
+	// ErrClosed is returned by operations attempted after Close
+	ErrClosed = errors.New("queue: broker closed")
+	// ErrUnroutable is returned when no queue receives a message, e.g. the queue does not exist
+	ErrUnroutable = errors.New("queue: message unroutable")
+)
+
+// Publisher publishes messages to named queues
+type Publisher interface {
+	// PublishMessage publishes message, encoded as JSON, to a queue. It returns once
+	// the broker has taken responsibility for the message.
+	PublishMessage(queueName string, message interface{}) error
+}
+
+// Consumer consumes messages from named queues, retrying failed ones
+type Consumer interface {
+	// DeclareRetryQueues declares a queue with delayed retries and a dead-letter queue
+	DeclareRetryQueues(queueName string, policy RetryPolicy) error
+	// DeclareDeadLetterQueue declares a queue and its dead-letter queue only
+	DeclareDeadLetterQueue(queueName string) error
+	// SetPrefetch limits how many unacknowledged messages each consumer started
+	// afterwards is sent
+	SetPrefetch(count int) error
+	// ConsumeMessages subscribes to a queue. The consumer tag identifies the
+	// subscription to CancelConsumer, which closes the returned channel.
+	ConsumeMessages(queueName, consumer string) (<-chan Delivery, error)
+	// CancelConsumer stops new deliveries to a consumer. Deliveries already
+	// received can still be acknowledged.
+	CancelConsumer(consumer string) error
+	// Retry schedules a failed message for a delayed retry, or dead-letters it once
+	// it has used all its attempts
+	Retry(queueName string, msg Delivery, cause error) error
+	// DeadLetter moves a message that can never succeed to the dead-letter queue
+	DeadLetter(queueName string, msg Delivery, cause error) error
+}
+
+// Broker is a message broker, such as RabbitMQClient or MemoryBroker
+type Broker interface {
+	Publisher
+	Consumer
+	// DeclareQueue declares a durable queue
+	DeclareQueue(queueName string) error
+	Close()
+}
+
+var (
+	_ Broker = (*RabbitMQClient)(nil)
+	_ Broker = (*RedisStreamsBroker)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+)
+
+// Open connects to the broker of the given backend. The Redis backend stores its
+// queues through redisClient and dead-letters messages delivered more than
+// maxDeliveries times.
+func Open(backend, rabbitmqURL string, redisClient *redis.Client, maxDeliveries int) (Broker, error) {
+	switch backend {
+	case BackendRabbitMQ:
+		return NewRabbitMQClient(rabbitmqURL)
+	case BackendRedis:
+		return NewRedisStreamsBroker(redisClient, maxDeliveries), nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", backend)
+	}
+}
+
+// Delivery is a message received from a queue. Each delivery must be settled exactly
+// once, with Ack or Nack.
+type Delivery struct {
+	MessageID   string
+	ContentType string
+	Headers     map[string]interface{}
+	Body        []byte
+	Redelivered bool // Whether the message was delivered before and not acknowledged
+
+	acknowledger acknowledger
+}
+
+// acknowledger settles deliveries with the broker that sent them
+type acknowledger interface {
+	ack() error
+	nack(requeue bool) error
+}
+
+// Ack acknowledges the message, removing it from the queue
+func (d Delivery) Ack() error {
+	return d.acknowledger.ack()
+}
+
+// Nack rejects the message. It is put back on the queue if requeue is set, and
+// dead-lettered or discarded otherwise.
+func (d Delivery) Nack(requeue bool) error {
+	return d.acknowledger.nack(requeue)
+}
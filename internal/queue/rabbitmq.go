@@ -1,52 +1,269 @@
 package queue
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
 )
 
-// RabbitMQClient wraps the RabbitMQ connection and channel
+const (
+	// reconnectBaseDelay is the wait before the first reconnection attempt; each later attempt waits twice as long
+	reconnectBaseDelay = time.Second
+	// reconnectMaxDelay caps the wait between reconnection attempts
+	reconnectMaxDelay = 30 * time.Second
+	// publishTimeout bounds how long a publish waits for the broker to confirm it
+	publishTimeout = 5 * time.Second
+)
+
+// ErrNotConnected is returned by operations attempted while the client is reconnecting
+var ErrNotConnected = errors.New("rabbitmq: not connected, reconnecting")
+
+var (
+	// ErrNacked is returned when the broker refuses to take responsibility for a message
+	ErrNacked = errors.New("rabbitmq: message nacked by broker")
+	// ErrConfirmTimeout is returned when the broker does not confirm a message in time.
+	// The message may or may not have been delivered.
+	ErrConfirmTimeout = errors.New("rabbitmq: timed out waiting for publish confirmation")
+)
+
+// RabbitMQClient wraps the RabbitMQ connection and channel. When the connection or
+// channel closes unexpectedly it reconnects with backoff, declares the queues it had
+// declared again, restores the prefetch and resubscribes consumers, whose delivery
+// channels stay open throughout. Publishes fail with ErrNotConnected until reconnected.
+// Published messages are persistent, and a publish only succeeds once the broker has
+// confirmed it and routed it to a queue. Publishes do not wait for each other's
+// confirmations.
 type RabbitMQClient struct {
-	Conn    *amqp.Connection
-	Channel *amqp.Channel
+	url string
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	publisher *publisher
+	connected chan struct{} // Closed while connected; replaced on disconnect
+	closed    bool
+	queues    map[string]amqp.Table // Declared queues and their arguments
+	prefetch  int
+	consumers map[string]*consumer
+
+	retrier
+}
+
+// consumer is a subscription that survives reconnections
+type consumer struct {
+	queue     string
+	out       chan Delivery
+	cancelled chan struct{}
+}
+
+// publisher publishes on a channel in confirm mode and routes the channel's
+// confirmations and returns to the publishes waiting for them
+type publisher struct {
+	channel  *amqp.Channel
+	confirms <-chan amqp.Confirmation
+	returns  <-chan amqp.Return
+
+	mu       sync.Mutex
+	sent     uint64                     // Delivery tag of the latest publish on the channel
+	pending  map[uint64]*pendingPublish // By delivery tag; nil once the channel closed
+	returned map[string]bool            // IDs of messages returned but not yet confirmed
+}
+
+// pendingPublish is a publish waiting for its confirmation
+type pendingPublish struct {
+	queue     string
+	messageID string
+	confirmed chan error // Receives the outcome; buffered, so dispatch never blocks
 }
 
 // NewRabbitMQClient initializes and returns a RabbitMQ client
 func NewRabbitMQClient(url string) (*RabbitMQClient, error) {
-	conn, err := amqp.Dial(url)
-	if err != nil {
+	r := &RabbitMQClient{
+		url:       url,
+		connected: make(chan struct{}),
+		queues:    make(map[string]amqp.Table),
+		consumers: make(map[string]*consumer),
+	}
+	r.retrier = newRetrier(r.declare, r.republish)
+
+	if err := r.connect(); err != nil {
 		return nil, err
 	}
 
+	log.Println("✅ Connected to RabbitMQ")
+	return r, nil
+}
+
+// connect dials the broker, opens a channel, restores the declared queues and prefetch,
+// and starts watching for the connection to close
+func (r *RabbitMQClient) connect() error {
+	conn, err := amqp.Dial(r.url)
+	if err != nil {
+		return err
+	}
+
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return err
 	}
 
-	log.Println("✅ Connected to RabbitMQ")
+	// Have the broker confirm every publish
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+	pub := &publisher{
+		channel:  ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 64)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 64)),
+		pending:  make(map[uint64]*pendingPublish),
+		returned: make(map[string]bool),
+	}
+	go pub.dispatch()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Close may have run while dialing
+	if r.closed {
+		conn.Close()
+		return ErrClosed
+	}
+
+	for name, args := range r.queues {
+		if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	if r.prefetch > 0 {
+		if err := ch.Qos(r.prefetch, 0, false); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	r.conn = conn
+	r.channel = ch
+	r.publisher = pub
+	close(r.connected)
+
+	go r.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), ch.NotifyClose(make(chan *amqp.Error, 1)))
+	return nil
+}
 
-	return &RabbitMQClient{
-		Conn:    conn,
-		Channel: ch,
-	}, nil
+// watch reconnects when the connection or channel closes, unless Close closed it
+func (r *RabbitMQClient) watch(connClosed, channelClosed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.connected = make(chan struct{})
+	conn := r.conn
+	r.conn, r.channel, r.publisher = nil, nil, nil
+	r.mu.Unlock()
+
+	// A channel error leaves the connection open; close it to start afresh
+	conn.Close()
+	log.Printf("❌ Lost connection to RabbitMQ: %v", reason)
+
+	delay := reconnectBaseDelay
+	for {
+		time.Sleep(delay)
+
+		r.mu.RLock()
+		closed := r.closed
+		r.mu.RUnlock()
+		if closed {
+			return
+		}
+
+		err := r.connect()
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Failed to reconnect to RabbitMQ, retrying in %s: %v", delay, err)
+			delay = min(delay*2, reconnectMaxDelay)
+			continue
+		}
+
+		log.Println("✅ Reconnected to RabbitMQ")
+		return
+	}
+}
+
+// current returns the channel, or an error while reconnecting or after Close
+func (r *RabbitMQClient) current() (*amqp.Channel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if r.channel == nil {
+		return nil, ErrNotConnected
+	}
+	return r.channel, nil
+}
+
+// currentPublisher is current for publishing, returning the channel with its confirmations
+func (r *RabbitMQClient) currentPublisher() (*publisher, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if r.publisher == nil {
+		return nil, ErrNotConnected
+	}
+	return r.publisher, nil
 }
 
 // DeclareQueue declares a queue
 func (r *RabbitMQClient) DeclareQueue(queueName string) error {
-	_, err := r.Channel.QueueDeclare(
+	return r.declare(queueName, nil)
+}
+
+// declare declares a durable queue and remembers it to declare again after reconnecting
+func (r *RabbitMQClient) declare(queueName string, args amqp.Table) error {
+	ch, err := r.current()
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
 		queueName, // name
 		true,      // durable
 		false,     // delete when unused
 		false,     // exclusive
 		false,     // no-wait
-		nil,       // arguments
+		args,      // arguments
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.queues[queueName] = args
+	r.mu.Unlock()
+	return nil
 }
 
 // PublishMessage publishes a message to a queue
@@ -56,41 +273,354 @@ func (r *RabbitMQClient) PublishMessage(queueName string, message interface{}) e
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	return r.publish(queueName, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// republish publishes a received message to another queue
+func (r *RabbitMQClient) republish(queueName string, msg Delivery) error {
+	return r.publish(queueName, amqp.Publishing{
+		Headers:     amqp.Table(msg.Headers),
+		ContentType: msg.ContentType,
+		MessageId:   msg.MessageID,
+		Body:        msg.Body,
+	})
+}
+
+// publish publishes a persistent message to a queue through the default exchange and
+// waits for the broker to confirm it. Messages without an ID are given one. Publishes
+// run concurrently, each waiting for its own confirmation.
+func (r *RabbitMQClient) publish(queueName string, msg amqp.Publishing) error {
+	pub, err := r.currentPublisher()
+	if err != nil {
+		return err
+	}
+
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+	msg.DeliveryMode = amqp.Persistent
 
-	return r.Channel.PublishWithContext(
-		ctx,
+	tag, confirmed, err := pub.send(queueName, msg)
+	if err != nil {
+		return err
+	}
+	return pub.wait(tag, confirmed, queueName)
+}
+
+// send publishes msg and returns its delivery tag and the channel its outcome is
+// sent on. Publishing and numbering happen under the lock, so tags follow the order
+// the broker receives the messages in.
+func (p *publisher) send(queueName string, msg amqp.Publishing) (uint64, <-chan error, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		return 0, nil, ErrNotConnected // The channel closed
+	}
+
+	err := p.channel.Publish(
 		"",        // exchange
 		queueName, // routing key
-		false,     // mandatory
+		true,      // mandatory, so unroutable messages are returned
 		false,     // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
+		msg,
 	)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tag, confirmed := p.track(queueName, msg.MessageId)
+	return tag, confirmed, nil
 }
 
-// ConsumeMessages consumes messages from a queue
-func (r *RabbitMQClient) ConsumeMessages(queueName string) (<-chan amqp.Delivery, error) {
-	return r.Channel.Consume(
-		queueName, // queue
-		"",        // consumer
-		false,     // auto-ack (we'll manually ack)
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
+// track numbers the message just published and registers it to be confirmed.
+// p.mu must be held.
+func (p *publisher) track(queueName, messageID string) (uint64, <-chan error) {
+	p.sent++
+	confirmed := make(chan error, 1)
+	p.pending[p.sent] = &pendingPublish{queue: queueName, messageID: messageID, confirmed: confirmed}
+	return p.sent, confirmed
+}
+
+// wait waits for the outcome of the publish with the given delivery tag
+func (p *publisher) wait(tag uint64, confirmed <-chan error, queueName string) error {
+	timeout := time.NewTimer(publishTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-confirmed:
+		return err
+	case <-timeout.C:
+		// Forget the publish; its confirmation, if it comes, is ignored
+		p.mu.Lock()
+		if pending, ok := p.pending[tag]; ok {
+			delete(p.returned, pending.messageID)
+			delete(p.pending, tag)
+		}
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrConfirmTimeout, queueName)
+	}
+}
+
+// dispatch hands the channel's confirmations to the publishes waiting for them, until
+// the channel closes and the publishes still waiting fail with ErrNotConnected
+func (p *publisher) dispatch() {
+	returns := p.returns
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			p.markReturned(ret)
+
+		case confirm, ok := <-p.confirms:
+			if !ok {
+				p.mu.Lock()
+				for _, pending := range p.pending {
+					pending.confirmed <- ErrNotConnected
+				}
+				p.pending = nil
+				p.mu.Unlock()
+				return
+			}
+
+			// The broker returns an unroutable message before confirming it, but the
+			// select above may pick the confirmation first
+			p.drainReturns()
+
+			p.mu.Lock()
+			pending, ok := p.pending[confirm.DeliveryTag]
+			if ok {
+				delete(p.pending, confirm.DeliveryTag)
+				returned := p.returned[pending.messageID]
+				delete(p.returned, pending.messageID)
+
+				switch {
+				case !confirm.Ack:
+					pending.confirmed <- fmt.Errorf("%w: %s", ErrNacked, pending.queue)
+				case returned:
+					pending.confirmed <- fmt.Errorf("%w: %s", ErrUnroutable, pending.queue)
+				default:
+					pending.confirmed <- nil
+				}
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// drainReturns records the returns already received
+func (p *publisher) drainReturns() {
+	for {
+		select {
+		case ret, ok := <-p.returns:
+			if !ok {
+				return
+			}
+			p.markReturned(ret)
+		default:
+			return
+		}
+	}
+}
+
+// markReturned records that a message was returned as unroutable
+func (p *publisher) markReturned(ret amqp.Return) {
+	log.Printf("❌ Message %s to %s was returned: %s", ret.MessageId, ret.RoutingKey, ret.ReplyText)
+
+	p.mu.Lock()
+	p.returned[ret.MessageId] = true
+	p.mu.Unlock()
+}
+
+// newMessageID returns a random message ID
+func newMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetPrefetch limits how many unacknowledged messages each consumer started
+// afterwards is sent. It is restored after reconnecting.
+func (r *RabbitMQClient) SetPrefetch(count int) error {
+	ch, err := r.current()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Qos(
+		count, // prefetch count
+		0,     // prefetch size
+		false, // global
 	)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.prefetch = count
+	r.mu.Unlock()
+	return nil
 }
 
-// Close closes the RabbitMQ connection
+// ConsumeMessages consumes messages from a queue. The consumer tag identifies the
+// consumer to CancelConsumer. The returned channel stays open across reconnections,
+// which resubscribe the consumer; it is closed once the consumer is cancelled.
+// Deliveries received before a reconnection can no longer be acknowledged, and are
+// redelivered by the broker.
+func (r *RabbitMQClient) ConsumeMessages(queueName, consumerTag string) (<-chan Delivery, error) {
+	c := &consumer{
+		queue:     queueName,
+		out:       make(chan Delivery),
+		cancelled: make(chan struct{}),
+	}
+
+	deliveries, err := r.consume(queueName, consumerTag)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.consumers[consumerTag] = c
+	r.mu.Unlock()
+
+	go r.deliver(consumerTag, c, deliveries)
+	return c.out, nil
+}
+
+func (r *RabbitMQClient) consume(queueName, consumerTag string) (<-chan amqp.Delivery, error) {
+	ch, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+
+	return ch.Consume(
+		queueName,   // queue
+		consumerTag, // consumer
+		false,       // auto-ack (we'll manually ack)
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+}
+
+// deliver passes deliveries to the consumer's channel, resubscribing after each
+// reconnection until the consumer is cancelled or the client closed
+func (r *RabbitMQClient) deliver(consumerTag string, c *consumer, deliveries <-chan amqp.Delivery) {
+	defer close(c.out)
+
+	for {
+		for msg := range deliveries {
+			c.out <- Delivery{
+				MessageID:    msg.MessageId,
+				ContentType:  msg.ContentType,
+				Headers:      msg.Headers,
+				Body:         msg.Body,
+				Redelivered:  msg.Redelivered,
+				acknowledger: amqpAcknowledger{msg},
+			}
+		}
+
+		// The deliveries channel closes when the consumer is cancelled or the connection is lost
+		for {
+			r.mu.RLock()
+			connected, closed := r.connected, r.closed
+			r.mu.RUnlock()
+			if closed || isDone(c.cancelled) {
+				return
+			}
+
+			select {
+			case <-c.cancelled:
+				return
+			case <-connected:
+			}
+
+			var err error
+			deliveries, err = r.consume(c.queue, consumerTag)
+			if err == nil {
+				log.Printf("✅ Resubscribed consumer %s to queue: %s", consumerTag, c.queue)
+				// CancelConsumer may have run before the subscription existed
+				if isDone(c.cancelled) {
+					if ch, err := r.current(); err == nil {
+						ch.Cancel(consumerTag, false)
+					}
+				}
+				break
+			}
+			if errors.Is(err, ErrClosed) {
+				return
+			}
+			if !errors.Is(err, ErrNotConnected) {
+				log.Printf("❌ Failed to resubscribe consumer %s: %v", consumerTag, err)
+				time.Sleep(reconnectBaseDelay)
+			}
+		}
+	}
+}
+
+// amqpAcknowledger settles a delivery from RabbitMQ
+type amqpAcknowledger struct {
+	delivery amqp.Delivery
+}
+
+func (a amqpAcknowledger) ack() error {
+	return a.delivery.Ack(false)
+}
+
+func (a amqpAcknowledger) nack(requeue bool) error {
+	return a.delivery.Nack(false, requeue)
+}
+
+// isDone reports whether a channel used as a signal has been closed
+func isDone(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// CancelConsumer stops new deliveries to a consumer and closes its delivery channel.
+// Deliveries already received can still be acknowledged.
+func (r *RabbitMQClient) CancelConsumer(consumerTag string) error {
+	r.mu.Lock()
+	c, ok := r.consumers[consumerTag]
+	if ok {
+		delete(r.consumers, consumerTag)
+		close(c.cancelled)
+	}
+	r.mu.Unlock()
+
+	ch, err := r.current()
+	if err != nil {
+		return nil // Not subscribed while disconnected; the delivering goroutine sees the cancellation
+	}
+	return ch.Cancel(consumerTag, false)
+}
+
+// Close closes the RabbitMQ connection and stops reconnecting
 func (r *RabbitMQClient) Close() {
-	if r.Channel != nil {
-		r.Channel.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.channel != nil {
+		r.channel.Close()
 	}
-	if r.Conn != nil {
-		r.Conn.Close()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+
+	// Wake consumers waiting to resubscribe, so their delivery channels close
+	if !isDone(r.connected) {
+		close(r.connected)
 	}
 }
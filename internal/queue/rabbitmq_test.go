@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// newTestPublisher returns a publisher fed by the returned confirmation and return
+// channels, without a broker
+func newTestPublisher() (*publisher, chan amqp.Confirmation, chan amqp.Return) {
+	confirms := make(chan amqp.Confirmation, 8)
+	returns := make(chan amqp.Return, 8)
+	pub := &publisher{
+		confirms: confirms,
+		returns:  returns,
+		pending:  make(map[uint64]*pendingPublish),
+		returned: make(map[string]bool),
+	}
+	go pub.dispatch()
+	return pub, confirms, returns
+}
+
+// track registers a publish as send does after publishing it
+func track(pub *publisher, messageID string) (uint64, <-chan error) {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	return pub.track("work", messageID)
+}
+
+func TestPublisherConfirmsConcurrentPublishes(t *testing.T) {
+	pub, confirms, returns := newTestPublisher()
+	defer close(confirms)
+
+	tags := make([]uint64, 4)
+	outcomes := make([]chan error, 4)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		tag, confirmed := track(pub, id)
+		tags[i] = tag
+		outcomes[i] = make(chan error, 1)
+		go func() { outcomes[i] <- pub.wait(tag, confirmed, "work") }()
+	}
+
+	// Confirmed out of order: c is returned first, b is nacked
+	returns <- amqp.Return{MessageId: "c", RoutingKey: "work"}
+	confirms <- amqp.Confirmation{DeliveryTag: tags[3], Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: tags[2], Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: tags[1], Ack: false}
+	confirms <- amqp.Confirmation{DeliveryTag: tags[0], Ack: true}
+
+	want := []error{nil, ErrNacked, ErrUnroutable, nil}
+	for i, outcome := range outcomes {
+		if err := <-outcome; !errors.Is(err, want[i]) || (want[i] == nil && err != nil) {
+			t.Errorf("publish %d = %v, want %v", i, err, want[i])
+		}
+	}
+}
+
+func TestPublisherFailsPendingPublishesWhenTheChannelCloses(t *testing.T) {
+	pub, confirms, _ := newTestPublisher()
+
+	tag, confirmed := track(pub, "a")
+	close(confirms)
+	if err := pub.wait(tag, confirmed, "work"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("wait = %v, want ErrNotConnected", err)
+	}
+
+	// Later publishes on the closed channel fail at once
+	if _, _, err := pub.send("work", amqp.Publishing{}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("send = %v, want ErrNotConnected", err)
+	}
+}
+
+// newDisconnectedClient returns a client that lost its connection and is waiting to
+// reconnect, without a broker
+func newDisconnectedClient() *RabbitMQClient {
+	r := &RabbitMQClient{
+		connected: make(chan struct{}),
+		queues:    make(map[string]amqp.Table),
+		consumers: make(map[string]*consumer),
+	}
+	r.retrier = newRetrier(r.declare, r.republish)
+	return r
+}
+
+// subscribe adds a consumer whose subscription ended with the connection
+func subscribe(r *RabbitMQClient, consumerTag string) <-chan Delivery {
+	c := &consumer{queue: "work", out: make(chan Delivery), cancelled: make(chan struct{})}
+	r.mu.Lock()
+	r.consumers[consumerTag] = c
+	r.mu.Unlock()
+
+	lost := make(chan amqp.Delivery)
+	close(lost)
+	go r.deliver(consumerTag, c, lost)
+	return c.out
+}
+
+// expectClosed fails unless deliveries is closed shortly
+func expectClosed(t *testing.T, deliveries <-chan Delivery) {
+	t.Helper()
+
+	select {
+	case msg, ok := <-deliveries:
+		if ok {
+			t.Fatalf("unexpected message %s", msg.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery channel not closed")
+	}
+}
+
+func TestRabbitMQClientFailsFastWhileDisconnected(t *testing.T) {
+	r := newDisconnectedClient()
+
+	if err := r.PublishMessage("work", "a"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PublishMessage = %v, want ErrNotConnected", err)
+	}
+	if err := r.DeclareQueue("work"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("DeclareQueue = %v, want ErrNotConnected", err)
+	}
+	if err := r.SetPrefetch(10); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("SetPrefetch = %v, want ErrNotConnected", err)
+	}
+
+	r.Close()
+	if err := r.PublishMessage("work", "a"); !errors.Is(err, ErrClosed) {
+		t.Errorf("PublishMessage after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestRabbitMQClientKeepsConsumersAcrossOutages(t *testing.T) {
+	r := newDisconnectedClient()
+	deliveries := subscribe(r, "worker")
+
+	// The delivery channel stays open while the consumer waits to resubscribe
+	expectNone(t, deliveries)
+
+	if err := r.CancelConsumer("worker"); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, deliveries)
+}
+
+func TestRabbitMQClientCloseStopsConsumersWhileDisconnected(t *testing.T) {
+	r := newDisconnectedClient()
+	deliveries := subscribe(r, "worker")
+	expectNone(t, deliveries)
+
+	r.Close()
+	expectClosed(t, deliveries)
+}
@@ -0,0 +1,523 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// streamGroup is the consumer group every queue's consumers read through
+	streamGroup = "workers"
+	// streamBlock bounds how long a read waits for new entries, and so how late
+	// delayed retries and requeued messages can be
+	streamBlock = time.Second
+	// streamClaimIdle is how long an entry can stay unacknowledged before it is
+	// taken to belong to a crashed consumer and reclaimed
+	streamClaimIdle = 5 * time.Minute
+	// streamClaimInterval is how often consumers look for entries to reclaim
+	streamClaimInterval = 30 * time.Second
+	// streamBatch is how many entries a read takes when there is no prefetch limit
+	streamBatch = 10
+)
+
+// promoteDelayed moves messages whose delay has passed from a queue's delayed set
+// (KEYS[1]) to its stream (KEYS[2]). ARGV[1] is the current time in milliseconds.
+var promoteDelayed = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, message in ipairs(due) do
+	redis.call('XADD', KEYS[2], '*', 'message', message)
+	redis.call('ZREM', KEYS[1], message)
+end
+return #due
+`)
+
+// RedisStreamsBroker is a Broker on Redis Streams, for deployments without RabbitMQ.
+// Each queue is a stream read through a consumer group; entries are deleted once
+// acknowledged. Entries left unacknowledged by a crashed consumer are reclaimed with
+// XAUTOCLAIM, and an entry delivered more than maxDeliveries times is moved to the
+// queue's dead-letter queue. Delayed retries wait in a sorted set until they are due.
+type RedisStreamsBroker struct {
+	client        *redis.Client
+	maxDeliveries int64
+
+	mu        sync.Mutex
+	delayed   map[string]delayedQueue // Retry queue name -> where and when its messages go
+	consumers map[string]*streamConsumer
+	prefetch  int
+	closed    bool
+
+	retrier
+}
+
+// delayedQueue is a queue declared with a message TTL; its messages move to target
+// once the TTL passes
+type delayedQueue struct {
+	ttl    time.Duration
+	target string
+}
+
+// streamMessage is a message as stored in a stream entry
+type streamMessage struct {
+	ID          string                 `json:"id"`
+	ContentType string                 `json:"contentType,omitempty"`
+	Headers     map[string]interface{} `json:"headers,omitempty"`
+	Body        []byte                 `json:"body"`
+}
+
+// streamConsumer is a subscription to a queue
+type streamConsumer struct {
+	queue    string
+	name     string // Consumer name within the group
+	out      chan Delivery
+	slots    chan struct{} // Holds a token per unacknowledged delivery; nil without a prefetch limit
+	cancel   context.CancelFunc
+	requeued chan string // IDs of nacked entries to deliver again
+}
+
+// NewRedisStreamsBroker returns a broker storing queues in Redis
+func NewRedisStreamsBroker(client *redis.Client, maxDeliveries int) *RedisStreamsBroker {
+	b := &RedisStreamsBroker{
+		client:        client,
+		maxDeliveries: int64(maxDeliveries),
+		delayed:       make(map[string]delayedQueue),
+		consumers:     make(map[string]*streamConsumer),
+	}
+	b.retrier = newRetrier(b.declare, b.republish)
+	return b
+}
+
+func streamKey(queueName string) string {
+	return "queue:" + queueName
+}
+
+func delayedKey(queueName string) string {
+	return "queue:" + queueName + ":delayed"
+}
+
+// DeclareQueue declares a queue
+func (b *RedisStreamsBroker) DeclareQueue(queueName string) error {
+	return b.declare(queueName, nil)
+}
+
+// declare creates a queue's stream and consumer group. A queue declared with a
+// message TTL and dead-letter routing key, as DeclareRetryQueues does, gets no stream:
+// its messages wait in the target queue's delayed set instead.
+func (b *RedisStreamsBroker) declare(queueName string, args amqp.Table) error {
+	ttl, hasTTL := args["x-message-ttl"].(int64)
+	target, hasTarget := args["x-dead-letter-routing-key"].(string)
+	if hasTTL && hasTarget {
+		b.mu.Lock()
+		b.delayed[queueName] = delayedQueue{ttl: time.Duration(ttl) * time.Millisecond, target: target}
+		b.mu.Unlock()
+		return nil
+	}
+
+	err := b.client.XGroupCreateMkStream(context.Background(), streamKey(queueName), streamGroup, "0").Err()
+	if err != nil && !redis.HasErrorPrefix(err, "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// PublishMessage publishes a message to a queue
+func (b *RedisStreamsBroker) PublishMessage(queueName string, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return b.publish(queueName, streamMessage{
+		ID:          newMessageID(),
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// republish publishes a received message to another queue
+func (b *RedisStreamsBroker) republish(queueName string, msg Delivery) error {
+	return b.publish(queueName, streamMessage{
+		ID:          msg.MessageID,
+		ContentType: msg.ContentType,
+		Headers:     msg.Headers,
+		Body:        msg.Body,
+	})
+}
+
+func (b *RedisStreamsBroker) publish(queueName string, msg streamMessage) error {
+	b.mu.Lock()
+	closed := b.closed
+	delayed, isDelayed := b.delayed[queueName]
+	b.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if isDelayed {
+		due := time.Now().Add(delayed.ttl).UnixMilli()
+		return b.client.ZAdd(ctx, delayedKey(delayed.target), redis.Z{Score: float64(due), Member: encoded}).Err()
+	}
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(queueName),
+		Values: map[string]interface{}{"message": encoded},
+	}).Err()
+}
+
+// SetPrefetch limits how many unacknowledged messages each consumer started
+// afterwards is sent
+func (b *RedisStreamsBroker) SetPrefetch(count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prefetch = count
+	return nil
+}
+
+// ConsumeMessages consumes messages from a queue. The consumer tag identifies the
+// consumer to CancelConsumer, which closes the returned channel.
+func (b *RedisStreamsBroker) ConsumeMessages(queueName, consumerTag string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := b.consumers[consumerTag]; ok {
+		return nil, fmt.Errorf("consumer %s already exists", consumerTag)
+	}
+
+	// Name consumers per host, so a restarted worker reclaims what it left behind
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &streamConsumer{
+		queue:    queueName,
+		name:     consumerTag + "@" + hostname,
+		out:      make(chan Delivery),
+		cancel:   cancel,
+		requeued: make(chan string, 64),
+	}
+	if b.prefetch > 0 {
+		c.slots = make(chan struct{}, b.prefetch)
+	}
+	b.consumers[consumerTag] = c
+
+	go b.deliver(ctx, c)
+	return c.out, nil
+}
+
+// deliver sends a consumer its queue's messages until it is cancelled: requeued
+// entries first, then reclaimed ones, then new ones
+func (b *RedisStreamsBroker) deliver(ctx context.Context, c *streamConsumer) {
+	defer close(c.out)
+
+	// Take back what this consumer left unacknowledged when it last stopped
+	pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   streamKey(c.queue),
+		Group:    streamGroup,
+		Start:    "-",
+		End:      "+",
+		Count:    int64(cap(c.requeued)),
+		Consumer: c.name,
+	}).Result()
+	if err != nil {
+		log.Printf("❌ Failed to read pending messages of %s: %v", c.queue, err)
+	}
+	for _, p := range pending {
+		c.requeued <- p.ID
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		err := b.poll(ctx, c, &lastClaim)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("❌ Failed to read queue %s: %v", c.queue, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(streamBlock):
+			}
+		}
+	}
+}
+
+// poll delivers one batch of messages
+func (b *RedisStreamsBroker) poll(ctx context.Context, c *streamConsumer, lastClaim *time.Time) error {
+	stream := streamKey(c.queue)
+
+	// Redeliver nacked entries
+	var requeued []string
+drain:
+	for {
+		select {
+		case id := <-c.requeued:
+			requeued = append(requeued, id)
+		default:
+			break drain
+		}
+	}
+	if len(requeued) > 0 {
+		messages, err := b.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    streamGroup,
+			Consumer: c.name,
+			Messages: requeued,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if err := b.redeliver(ctx, c, messages); err != nil {
+			return err
+		}
+	}
+
+	// Move delayed retries that are due onto the stream
+	err := promoteDelayed.Run(ctx, b.client, []string{delayedKey(c.queue), stream}, time.Now().UnixMilli()).Err()
+	if err != nil {
+		return err
+	}
+
+	// Reclaim entries from consumers that crashed or hung
+	if time.Since(*lastClaim) >= streamClaimInterval {
+		messages, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    streamGroup,
+			Consumer: c.name,
+			MinIdle:  streamClaimIdle,
+			Start:    "0-0",
+			Count:    streamBatch,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			log.Printf("🔁 Reclaimed %d unacknowledged messages from %s", len(messages), c.queue)
+		}
+		if err := b.redeliver(ctx, c, messages); err != nil {
+			return err
+		}
+		*lastClaim = time.Now()
+	}
+
+	// Read new entries
+	count := int64(streamBatch)
+	if c.slots != nil {
+		count = int64(max(cap(c.slots)-len(c.slots), 1))
+	}
+	streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    streamGroup,
+		Consumer: c.name,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    streamBlock,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, s := range streams {
+		for _, entry := range s.Messages {
+			b.send(ctx, c, entry, false)
+		}
+	}
+	return nil
+}
+
+// redeliver sends claimed entries again, dead-lettering those delivered too often
+func (b *RedisStreamsBroker) redeliver(ctx context.Context, c *streamConsumer, messages []redis.XMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// Claiming counted as a delivery; look the counts up
+	stream := streamKey(c.queue)
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.XPendingExtCmd, len(messages))
+	for i, entry := range messages {
+		cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  streamGroup,
+			Start:  entry.ID,
+			End:    entry.ID,
+			Count:  1,
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	for i, entry := range messages {
+		var deliveries int64
+		if pending := cmds[i].Val(); len(pending) > 0 {
+			deliveries = pending[0].RetryCount
+		}
+		if b.maxDeliveries > 0 && deliveries > b.maxDeliveries {
+			log.Printf("☠️ Dead-lettering message from %s after %d deliveries", c.queue, deliveries)
+			if err := b.deadLetter(c.queue, entry); err != nil {
+				return err
+			}
+			continue
+		}
+		b.send(ctx, c, entry, true)
+	}
+	return nil
+}
+
+// send passes an entry to the consumer once it has a free prefetch slot. Entries the
+// consumer is cancelled before receiving stay pending, and are reclaimed later.
+func (b *RedisStreamsBroker) send(ctx context.Context, c *streamConsumer, entry redis.XMessage, redelivered bool) {
+	encoded, ok := entry.Values["message"].(string)
+	if !ok {
+		// Deleted while pending; nothing is left to deliver
+		b.client.XAck(context.Background(), streamKey(c.queue), streamGroup, entry.ID)
+		return
+	}
+
+	var msg streamMessage
+	if err := json.Unmarshal([]byte(encoded), &msg); err != nil {
+		log.Printf("❌ Dead-lettering malformed entry %s from %s: %v", entry.ID, c.queue, err)
+		b.deadLetter(c.queue, entry)
+		return
+	}
+
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	delivery := Delivery{
+		MessageID:    msg.ID,
+		ContentType:  msg.ContentType,
+		Headers:      msg.Headers,
+		Body:         msg.Body,
+		Redelivered:  redelivered,
+		acknowledger: &streamAcknowledger{broker: b, consumer: c, entry: entry},
+	}
+
+	select {
+	case c.out <- delivery:
+	case <-ctx.Done():
+		if c.slots != nil {
+			<-c.slots
+		}
+	}
+}
+
+// deadLetter moves an entry to its queue's dead-letter queue
+func (b *RedisStreamsBroker) deadLetter(queueName string, entry redis.XMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	stream := streamKey(queueName)
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamKey(DeadLetterQueueName(queueName)),
+			Values: entry.Values,
+		})
+		pipe.XAck(ctx, stream, streamGroup, entry.ID)
+		pipe.XDel(ctx, stream, entry.ID)
+		return nil
+	})
+	return err
+}
+
+// CancelConsumer stops new deliveries to a consumer and closes its delivery channel.
+// Deliveries already received can still be acknowledged.
+func (b *RedisStreamsBroker) CancelConsumer(consumerTag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.consumers[consumerTag]
+	if !ok {
+		return fmt.Errorf("consumer %s not found", consumerTag)
+	}
+	delete(b.consumers, consumerTag)
+	c.cancel()
+	return nil
+}
+
+// Close stops every consumer. It does not close the Redis client.
+func (b *RedisStreamsBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for tag, c := range b.consumers {
+		delete(b.consumers, tag)
+		c.cancel()
+	}
+}
+
+// streamAcknowledger settles a delivery from a RedisStreamsBroker
+type streamAcknowledger struct {
+	broker   *RedisStreamsBroker
+	consumer *streamConsumer
+	entry    redis.XMessage
+	settled  atomic.Bool
+}
+
+func (a *streamAcknowledger) ack() error {
+	if err := a.settle(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	stream := streamKey(a.consumer.queue)
+	_, err := a.broker.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, streamGroup, a.entry.ID)
+		pipe.XDel(ctx, stream, a.entry.ID)
+		return nil
+	})
+	return err
+}
+
+func (a *streamAcknowledger) nack(requeue bool) error {
+	if err := a.settle(); err != nil {
+		return err
+	}
+
+	if !requeue {
+		return a.broker.deadLetter(a.consumer.queue, a.entry)
+	}
+
+	// Left pending, the entry is reclaimed once idle if the requeue is lost
+	select {
+	case a.consumer.requeued <- a.entry.ID:
+	default:
+	}
+	return nil
+}
+
+// settle marks the delivery settled and frees its consumer's prefetch slot
+func (a *streamAcknowledger) settle() error {
+	if !a.settled.CompareAndSwap(false, true) {
+		return errAlreadySettled
+	}
+	if a.consumer.slots != nil {
+		<-a.consumer.slots
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestStreamsBroker returns a Redis Streams broker on miniredis with the given
+// declared queues, and the server
+func newTestStreamsBroker(t *testing.T, maxDeliveries int, queues ...string) (*RedisStreamsBroker, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	broker := NewRedisStreamsBroker(client, maxDeliveries)
+	t.Cleanup(broker.Close)
+	for _, name := range queues {
+		if err := broker.DeclareQueue(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return broker, server
+}
+
+// cancelConsumer cancels a consumer and waits for its delivery channel to close
+func cancelConsumer(t *testing.T, broker Broker, consumerTag string, deliveries <-chan Delivery) {
+	t.Helper()
+
+	if err := broker.CancelConsumer(consumerTag); err != nil {
+		t.Fatal(err)
+	}
+	for range deliveries {
+	}
+}
+
+// expectStreamLength fails unless a queue's stream holds n entries
+func expectStreamLength(t *testing.T, broker *RedisStreamsBroker, queueName string, n int64) {
+	t.Helper()
+
+	length, err := broker.client.XLen(context.Background(), streamKey(queueName)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != n {
+		t.Errorf("%s holds %d entries, want %d", queueName, length, n)
+	}
+}
+
+func TestRedisStreamsBrokerAckDeletesTheEntry(t *testing.T) {
+	broker, _ := newTestStreamsBroker(t, 0, "work")
+	publish(t, broker, "work", "a")
+
+	msg := receiveBody(t, consume(t, broker, "work"), "a")
+	if msg.Redelivered || msg.MessageID == "" {
+		t.Errorf("delivery = %+v, want a first delivery with a message ID", msg)
+	}
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Ack(); !errors.Is(err, errAlreadySettled) {
+		t.Errorf("second Ack = %v, want %v", err, errAlreadySettled)
+	}
+	expectStreamLength(t, broker, "work", 0)
+}
+
+func TestRedisStreamsBrokerReclaimsIdleEntries(t *testing.T) {
+	broker, server := newTestStreamsBroker(t, 5, "work")
+	now := time.Now()
+	server.SetTime(now)
+	publish(t, broker, "work", "a")
+
+	// A consumer stops without settling its delivery
+	crashed, err := broker.ConsumeMessages("work", "crashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveBody(t, crashed, "a")
+	cancelConsumer(t, broker, "crashed", crashed)
+
+	// Another consumer leaves it while it could still be in progress...
+	deliveries, err := broker.ConsumeMessages("work", "early")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, deliveries)
+	cancelConsumer(t, broker, "early", deliveries)
+
+	// ...and reclaims it once it has been idle long enough
+	server.SetTime(now.Add(streamClaimIdle))
+	msg := receiveBody(t, consume(t, broker, "work"), "a")
+	if !msg.Redelivered {
+		t.Error("reclaimed message not marked redelivered")
+	}
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	expectStreamLength(t, broker, "work", 0)
+}
+
+func TestRedisStreamsBrokerResumesItsOwnPendingEntries(t *testing.T) {
+	broker, _ := newTestStreamsBroker(t, 5, "work")
+	publish(t, broker, "work", "a")
+
+	deliveries := consume(t, broker, "work")
+	receiveBody(t, deliveries, "a")
+	cancelConsumer(t, broker, "work-test", deliveries)
+
+	// Restarted under the same tag, the consumer takes it back without waiting
+	msg := receiveBody(t, consume(t, broker, "work"), "a")
+	if !msg.Redelivered {
+		t.Error("resumed message not marked redelivered")
+	}
+	msg.Ack()
+}
+
+func TestRedisStreamsBrokerDeadLettersAfterMaxDeliveries(t *testing.T) {
+	broker, _ := newTestStreamsBroker(t, 2)
+	if err := broker.DeclareDeadLetterQueue("work"); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, "work", "a")
+	deliveries := consume(t, broker, "work")
+
+	// Delivered twice, then dead-lettered instead of a third time
+	if err := receiveBody(t, deliveries, "a").Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	redelivered := receiveBody(t, deliveries, "a")
+	if !redelivered.Redelivered {
+		t.Error("requeued message not marked redelivered")
+	}
+	if err := redelivered.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, deliveries)
+
+	receiveBody(t, consume(t, broker, "work.dlq"), "a").Ack()
+	expectStreamLength(t, broker, "work", 0)
+}
+
+func TestRedisStreamsBrokerNackDeadLetters(t *testing.T) {
+	broker, _ := newTestStreamsBroker(t, 0)
+	if err := broker.DeclareDeadLetterQueue("work"); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, "work", "a")
+
+	if err := receiveBody(t, consume(t, broker, "work"), "a").Nack(false); err != nil {
+		t.Fatal(err)
+	}
+	receiveBody(t, consume(t, broker, "work.dlq"), "a").Ack()
+	expectStreamLength(t, broker, "work", 0)
+}
+
+func TestRedisStreamsBrokerPromotesDelayedRetries(t *testing.T) {
+	broker, _ := newTestStreamsBroker(t, 0)
+	policy := RetryPolicy{MaxAttempts: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	if err := broker.DeclareRetryQueues("work", policy); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, "work", "a")
+	deliveries := consume(t, broker, "work")
+
+	msg := receiveBody(t, deliveries, "a")
+	messageID := msg.MessageID
+	start := time.Now()
+	if err := broker.Retry("work", msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry waits in the delayed set, not on the stream
+	delayed, err := broker.client.ZCard(context.Background(), delayedKey("work")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delayed != 1 {
+		t.Errorf("%d delayed messages, want 1", delayed)
+	}
+
+	msg = receiveBody(t, deliveries, "a")
+	if waited := time.Since(start); waited < policy.BaseDelay {
+		t.Errorf("message retried after %v, before its delay", waited)
+	}
+	if RetryCount(msg) != 1 || msg.MessageID != messageID || msg.Headers[LastErrorHeader] != "boom" {
+		t.Errorf("retried delivery = %+v, want retry count 1 with the same message ID and the error", msg)
+	}
+
+	// Out of attempts, the next failure dead-letters it
+	if err := broker.Retry("work", msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, deliveries)
+	if msg := receiveBody(t, consume(t, broker, "work.dlq"), "a"); RetryCount(msg) != 2 {
+		t.Errorf("dead-lettered retry count = %d, want 2", RetryCount(msg))
+	}
+}
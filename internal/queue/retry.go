@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// RetryCountHeader counts how many times a message has failed
+	RetryCountHeader = "x-retry-count"
+	// LastErrorHeader holds the error of a message's most recent failure
+	LastErrorHeader = "x-last-error"
+)
+
+// RetryPolicy bounds how often and how quickly a failed message is retried
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts, including the first, before a message is dead-lettered
+	BaseDelay   time.Duration // Delay before the first retry; each later retry waits twice as long
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy retries after 1s, 2s, 4s and 8s, then dead-letters the message
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    5 * time.Minute,
+}
+
+// Delay returns the delay before the nth retry
+func (p RetryPolicy) Delay(n int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// delays returns the delay before each retry
+func (p RetryPolicy) delays() []time.Duration {
+	var delays []time.Duration
+	for i := 1; i < p.MaxAttempts; i++ {
+		delays = append(delays, p.Delay(i))
+	}
+	return delays
+}
+
+// RetryQueueName is the queue holding messages of queueName waiting for their nth retry
+func RetryQueueName(queueName string, n int) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, n)
+}
+
+// DeadLetterQueueName is the queue of messages of queueName that failed every attempt
+func DeadLetterQueueName(queueName string) string {
+	return queueName + ".dlq"
+}
+
+// retrier implements DeclareRetryQueues, DeclareDeadLetterQueue, Retry and DeadLetter
+// on top of a broker's queue declarations and publishes
+type retrier struct {
+	declare func(queueName string, args amqp.Table) error
+	publish func(queueName string, msg Delivery) error
+
+	mu       sync.RWMutex
+	policies map[string]RetryPolicy // Queue name -> policy, see DeclareRetryQueues
+}
+
+func newRetrier(declare func(string, amqp.Table) error, publish func(string, Delivery) error) retrier {
+	return retrier{
+		declare:  declare,
+		publish:  publish,
+		policies: make(map[string]RetryPolicy),
+	}
+}
+
+// DeclareRetryQueues declares a queue with delayed retries and a dead-letter queue.
+// Each retry has its own queue whose TTL is the retry's delay; expired messages are
+// dead-lettered back to the original queue. Per-queue TTLs, unlike per-message ones,
+// never leave a short delay stuck behind a longer one.
+func (r *retrier) DeclareRetryQueues(queueName string, policy RetryPolicy) error {
+	if err := r.declare(queueName, nil); err != nil {
+		return err
+	}
+
+	for i, delay := range policy.delays() {
+		err := r.declare(RetryQueueName(queueName, i+1), amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.declare(DeadLetterQueueName(queueName), nil); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.policies[queueName] = policy
+	r.mu.Unlock()
+	return nil
+}
+
+// DeclareDeadLetterQueue declares a queue and its dead-letter queue, for consumers
+// that retry failed messages themselves and only dead-letter them
+func (r *retrier) DeclareDeadLetterQueue(queueName string) error {
+	if err := r.declare(queueName, nil); err != nil {
+		return err
+	}
+	return r.declare(DeadLetterQueueName(queueName), nil)
+}
+
+// Retry schedules a failed message of queueName for a delayed retry, or moves it to
+// the dead-letter queue once it has used all its attempts, then acks it. The queue's
+// retry queues must have been declared with DeclareRetryQueues.
+func (r *retrier) Retry(queueName string, msg Delivery, cause error) error {
+	r.mu.RLock()
+	policy, ok := r.policies[queueName]
+	r.mu.RUnlock()
+	if !ok {
+		msg.Nack(true)
+		return fmt.Errorf("no retry queues declared for %s", queueName)
+	}
+
+	attempts := RetryCount(msg) + 1
+	if attempts >= policy.MaxAttempts {
+		log.Printf("☠️ Dead-lettering message from %s after %d attempts: %v", queueName, attempts, cause)
+		return r.forward(DeadLetterQueueName(queueName), msg, attempts, cause)
+	}
+
+	log.Printf("🔁 Retrying message from %s (attempt %d of %d): %v", queueName, attempts+1, policy.MaxAttempts, cause)
+	return r.forward(RetryQueueName(queueName, attempts), msg, attempts, cause)
+}
+
+// DeadLetter moves a message that can never succeed, such as one that cannot be
+// parsed, straight to queueName's dead-letter queue, then acks it
+func (r *retrier) DeadLetter(queueName string, msg Delivery, cause error) error {
+	log.Printf("☠️ Dead-lettering message from %s: %v", queueName, cause)
+	return r.forward(DeadLetterQueueName(queueName), msg, RetryCount(msg)+1, cause)
+}
+
+// forward republishes msg to a queue with updated retry headers and acks the
+// original. If the publish fails the original is requeued instead, so it is not lost.
+func (r *retrier) forward(queueName string, msg Delivery, attempts int, cause error) error {
+	headers := make(map[string]interface{}, len(msg.Headers)+2)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[RetryCountHeader] = int32(attempts)
+	if cause != nil {
+		headers[LastErrorHeader] = cause.Error()
+	}
+
+	republished := msg
+	republished.Headers = headers
+	if err := r.publish(queueName, republished); err != nil {
+		msg.Nack(true)
+		return err
+	}
+
+	return msg.Ack()
+}
+
+// WithRetryCount returns msg with its retry count set to count, for consumers that
+// retry a message in place before handing it to Retry or DeadLetter
+func WithRetryCount(msg Delivery, count int) Delivery {
+	headers := make(map[string]interface{}, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[RetryCountHeader] = int32(count)
+
+	msg.Headers = headers
+	return msg
+}
+
+// RetryCount returns how many times a message has already failed
+func RetryCount(msg Delivery) int {
+	switch count := msg.Headers[RetryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	case float64: // Headers decoded from JSON, as by RedisStreamsBroker
+		return int(count)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// recordingAcknowledger records how a delivery was settled
+type recordingAcknowledger struct {
+	acked    bool
+	requeued bool
+}
+
+func (a *recordingAcknowledger) ack() error {
+	a.acked = true
+	return nil
+}
+
+func (a *recordingAcknowledger) nack(requeue bool) error {
+	a.requeued = requeue
+	return nil
+}
+
+// newTestDelivery returns a delivery that records how it is settled
+func newTestDelivery(headers map[string]interface{}) (Delivery, *recordingAcknowledger) {
+	ack := &recordingAcknowledger{}
+	return Delivery{MessageID: "m1", Headers: headers, Body: []byte(`"a"`), acknowledger: ack}, ack
+}
+
+func TestRetryPolicyDelaysDoubleUpToTheMax(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 6, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if delays := policy.delays(); !slices.Equal(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+	if delays := DefaultRetryPolicy.delays(); len(delays) != DefaultRetryPolicy.MaxAttempts-1 || delays[3] != 8*time.Second {
+		t.Errorf("default delays = %v, want 1s, 2s, 4s and 8s", delays)
+	}
+}
+
+func TestWithRetryCountCopiesHeaders(t *testing.T) {
+	headers := map[string]interface{}{"trace": "t1", RetryCountHeader: int32(1)}
+	msg, _ := newTestDelivery(headers)
+
+	counted := WithRetryCount(msg, 3)
+	if RetryCount(counted) != 3 || counted.Headers["trace"] != "t1" {
+		t.Errorf("headers = %v, want a retry count of 3 and the trace kept", counted.Headers)
+	}
+	if RetryCount(msg) != 1 {
+		t.Errorf("original retry count = %d, want it left at 1", RetryCount(msg))
+	}
+}
+
+func TestRetryRequeuesWhenTheRetryCannotBePublished(t *testing.T) {
+	declared := make(map[string]amqp.Table)
+	r := newRetrier(
+		func(queueName string, args amqp.Table) error {
+			declared[queueName] = args
+			return nil
+		},
+		func(queueName string, msg Delivery) error {
+			return errors.New("broker down")
+		},
+	)
+
+	// Without retry queues the message goes back on its queue
+	msg, ack := newTestDelivery(nil)
+	if err := r.Retry("work", msg, errors.New("boom")); err == nil || !ack.requeued {
+		t.Errorf("Retry before DeclareRetryQueues = %v, requeued %v, want an error and the message requeued", err, ack.requeued)
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	if err := r.DeclareRetryQueues("work", policy); err != nil {
+		t.Fatal(err)
+	}
+	if args := declared[RetryQueueName("work", 2)]; args["x-message-ttl"] != int64(2000) || args["x-dead-letter-routing-key"] != "work" {
+		t.Errorf("second retry queue declared with %v, want a 2s TTL routing back to work", args)
+	}
+	if _, ok := declared[DeadLetterQueueName("work")]; !ok {
+		t.Error("dead-letter queue not declared")
+	}
+
+	// A failed publish must not lose the message
+	msg, ack = newTestDelivery(nil)
+	if err := r.Retry("work", msg, errors.New("boom")); err == nil || ack.acked || !ack.requeued {
+		t.Errorf("Retry with the broker down = %v, acked %v, requeued %v, want an error and the message requeued", err, ack.acked, ack.requeued)
+	}
+}
@@ -0,0 +1,1211 @@
+package repository
+
+import (
+	"context"
+	"expense-split-wise/internal/models"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memorySearchWeights weighs matches in each searchable field, as the Mongo text index does
+var memorySearchWeights = map[string]float64{"description": 10, "notes": 5, "comments": 1}
+
+// memoryDB holds the documents of a memory store. Stored documents are never
+// modified in place, only replaced, so a snapshot only copies the maps.
+type memoryDB struct {
+	mu       sync.Mutex
+	groups   map[primitive.ObjectID]*models.Group
+	expenses map[primitive.ObjectID]*models.Expense
+	balances map[primitive.ObjectID]*models.Balance // By group ID
+	outbox   map[primitive.ObjectID]*models.OutboxEntry
+
+	statements map[primitive.ObjectID]*models.StatementTransaction
+
+	preferences       map[string]*models.NotificationPreferences // By user
+	notificationsSent map[string]time.Time                       // By event ID and recipient
+	digestRuns        map[string]*models.DigestRun               // By week
+
+	subscriptions map[primitive.ObjectID]*models.WebhookSubscription
+	deliveries    map[primitive.ObjectID]*models.WebhookDelivery
+	budgets       map[primitive.ObjectID]*models.Budget
+}
+
+// memoryTxKey marks a context as running in a transaction on a memoryDB
+type memoryTxKey struct{}
+
+// NewMemoryStore returns empty repositories that keep their data in memory, for tests
+// and local runs without MongoDB. They are safe for concurrent use. Transactions
+// run one at a time and block other operations until they finish.
+func NewMemoryStore() *Store {
+	db := &memoryDB{
+		groups:   make(map[primitive.ObjectID]*models.Group),
+		expenses: make(map[primitive.ObjectID]*models.Expense),
+		balances: make(map[primitive.ObjectID]*models.Balance),
+		outbox:   make(map[primitive.ObjectID]*models.OutboxEntry),
+
+		statements: make(map[primitive.ObjectID]*models.StatementTransaction),
+
+		preferences:       make(map[string]*models.NotificationPreferences),
+		notificationsSent: make(map[string]time.Time),
+		digestRuns:        make(map[string]*models.DigestRun),
+
+		subscriptions: make(map[primitive.ObjectID]*models.WebhookSubscription),
+		deliveries:    make(map[primitive.ObjectID]*models.WebhookDelivery),
+		budgets:       make(map[primitive.ObjectID]*models.Budget),
+	}
+
+	return &Store{
+		Transactor: &MemoryTransactor{db: db},
+		Groups:     &MemoryGroupRepository{db: db},
+		Expenses:   &MemoryExpenseRepository{db: db},
+		Balances:   &MemoryBalanceRepository{db: db},
+		Outbox:     &MemoryOutboxRepository{db: db},
+		Statements: &MemoryStatementRepository{db: db},
+
+		Notifications: &MemoryNotificationRepository{db: db},
+		Webhooks:      &MemoryWebhookRepository{db: db},
+		Budgets:       &MemoryBudgetRepository{db: db},
+	}
+}
+
+// lock locks the database unless ctx runs in a transaction on it, which already
+// holds the lock, and returns the matching unlock
+func (db *memoryDB) lock(ctx context.Context) func() {
+	if ctx.Value(memoryTxKey{}) == db {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+// MemoryTransactor runs transactions on a memory store
+type MemoryTransactor struct {
+	db *memoryDB
+}
+
+// WithTransaction runs fn holding the store's lock, and restores the documents as
+// they were before if fn fails
+func (t *MemoryTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	db := t.db
+	if ctx.Value(memoryTxKey{}) == db {
+		return fn(ctx) // Already in a transaction
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snapshot := db.snapshot()
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, db)); err != nil {
+		db.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+// snapshot copies the maps of db, which is enough to restore it as documents are
+// never modified in place. Call with the lock held.
+func (db *memoryDB) snapshot() *memoryDB {
+	return &memoryDB{
+		groups:   maps.Clone(db.groups),
+		expenses: maps.Clone(db.expenses),
+		balances: maps.Clone(db.balances),
+		outbox:   maps.Clone(db.outbox),
+
+		statements: maps.Clone(db.statements),
+
+		preferences:       maps.Clone(db.preferences),
+		notificationsSent: maps.Clone(db.notificationsSent),
+		digestRuns:        maps.Clone(db.digestRuns),
+
+		subscriptions: maps.Clone(db.subscriptions),
+		deliveries:    maps.Clone(db.deliveries),
+		budgets:       maps.Clone(db.budgets),
+	}
+}
+
+// restore replaces the documents of db with those of a snapshot. Call with the lock held.
+func (db *memoryDB) restore(snapshot *memoryDB) {
+	db.groups, db.expenses, db.balances, db.outbox = snapshot.groups, snapshot.expenses, snapshot.balances, snapshot.outbox
+	db.statements = snapshot.statements
+	db.preferences, db.notificationsSent, db.digestRuns = snapshot.preferences, snapshot.notificationsSent, snapshot.digestRuns
+	db.subscriptions, db.deliveries, db.budgets = snapshot.subscriptions, snapshot.deliveries, snapshot.budgets
+}
+
+// MemoryGroupRepository stores groups in memory
+type MemoryGroupRepository struct {
+	db *memoryDB
+}
+
+// Create saves a new group
+func (r *MemoryGroupRepository) Create(ctx context.Context, group *models.Group) error {
+	defer r.db.lock(ctx)()
+
+	if group.ID.IsZero() {
+		group.ID = primitive.NewObjectID()
+	}
+	r.db.groups[group.ID] = copyGroup(group)
+	return nil
+}
+
+// Get retrieves a group by ID
+func (r *MemoryGroupRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Group, error) {
+	defer r.db.lock(ctx)()
+
+	group, ok := r.db.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyGroup(group), nil
+}
+
+// List returns every group, ordered by name
+func (r *MemoryGroupRepository) List(ctx context.Context) ([]models.Group, error) {
+	defer r.db.lock(ctx)()
+
+	groups := []models.Group{}
+	for _, group := range r.db.groups {
+		groups = append(groups, *copyGroup(group))
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].ID.Hex() < groups[j].ID.Hex()
+	})
+	return groups, nil
+}
+
+// AddMembers adds members to a group and returns the group as it was before
+func (r *MemoryGroupRepository) AddMembers(ctx context.Context, id primitive.ObjectID, members []string, updatedAt time.Time) (*models.Group, error) {
+	defer r.db.lock(ctx)()
+
+	before, ok := r.db.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	group := copyGroup(before)
+	for _, member := range members {
+		if !slices.Contains(group.Members, member) {
+			group.Members = append(group.Members, member)
+		}
+	}
+	group.UpdatedAt = updatedAt
+	r.db.groups[id] = group
+
+	return copyGroup(before), nil
+}
+
+// ListByMember returns the groups a member belongs to, oldest first
+func (r *MemoryGroupRepository) ListByMember(ctx context.Context, member string) ([]models.Group, error) {
+	defer r.db.lock(ctx)()
+
+	groups := []models.Group{}
+	for _, group := range r.db.groups {
+		if slices.Contains(group.Members, member) {
+			groups = append(groups, *copyGroup(group))
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID.Hex() < groups[j].ID.Hex()
+	})
+	return groups, nil
+}
+
+// MemoryExpenseRepository stores expenses in memory
+type MemoryExpenseRepository struct {
+	db *memoryDB
+}
+
+// Create saves a new expense
+func (r *MemoryExpenseRepository) Create(ctx context.Context, expense *models.Expense) error {
+	defer r.db.lock(ctx)()
+
+	if expense.ID.IsZero() {
+		expense.ID = primitive.NewObjectID()
+	}
+	r.db.expenses[expense.ID] = copyExpense(expense)
+	return nil
+}
+
+// CreateMany saves new expenses
+func (r *MemoryExpenseRepository) CreateMany(ctx context.Context, expenses []models.Expense) error {
+	defer r.db.lock(ctx)()
+
+	for i := range expenses {
+		if expenses[i].ID.IsZero() {
+			expenses[i].ID = primitive.NewObjectID()
+		}
+		r.db.expenses[expenses[i].ID] = copyExpense(&expenses[i])
+	}
+	return nil
+}
+
+// Get retrieves an expense by ID
+func (r *MemoryExpenseRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Expense, error) {
+	defer r.db.lock(ctx)()
+
+	expense, ok := r.db.expenses[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyExpense(expense), nil
+}
+
+// Update replaces the editable fields of an expense and returns its previous version
+func (r *MemoryExpenseRepository) Update(ctx context.Context, expense *models.Expense) (*models.Expense, error) {
+	defer r.db.lock(ctx)()
+
+	previous, ok := r.db.expenses[expense.ID]
+	if !ok || previous.GroupID != expense.GroupID {
+		return nil, ErrNotFound
+	}
+
+	updated := copyExpense(previous)
+	updated.Description = expense.Description
+	updated.Amount = expense.Amount
+	updated.PaidBy = expense.PaidBy
+	updated.SplitBetween = slices.Clone(expense.SplitBetween)
+	updated.Category = expense.Category
+	updated.Currency = expense.Currency
+	updated.Notes = expense.Notes
+	r.db.expenses[expense.ID] = updated
+
+	return copyExpense(previous), nil
+}
+
+// Delete deletes an expense and returns it
+func (r *MemoryExpenseRepository) Delete(ctx context.Context, groupID, expenseID primitive.ObjectID) (*models.Expense, error) {
+	defer r.db.lock(ctx)()
+
+	expense, ok := r.db.expenses[expenseID]
+	if !ok || expense.GroupID != groupID {
+		return nil, ErrNotFound
+	}
+	delete(r.db.expenses, expenseID)
+	return copyExpense(expense), nil
+}
+
+// ListByGroup retrieves all expenses for a group in creation order
+func (r *MemoryExpenseRepository) ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Expense, error) {
+	defer r.db.lock(ctx)()
+
+	expenses := []models.Expense{}
+	for _, expense := range r.db.groupExpenses(groupID) {
+		expenses = append(expenses, *copyExpense(expense))
+	}
+	return expenses, nil
+}
+
+// Find returns the expenses matching filter
+func (r *MemoryExpenseRepository) Find(ctx context.Context, filter ExpenseFilter) ([]models.Expense, error) {
+	defer r.db.lock(ctx)()
+
+	expenses := []models.Expense{}
+	for _, expense := range r.db.groupExpenses(filter.GroupID) {
+		if matchesExpenseFilter(expense, filter) {
+			expenses = append(expenses, *copyExpense(expense))
+		}
+	}
+	return expenses, nil
+}
+
+// matchesExpenseFilter reports whether an expense of the filter's group matches the rest of it
+func matchesExpenseFilter(expense *models.Expense, filter ExpenseFilter) bool {
+	switch {
+	case filter.PaidBy != "" && expense.PaidBy != filter.PaidBy,
+		filter.Category != "" && expense.Category != filter.Category,
+		filter.ExcludeCategory != "" && expense.Category == filter.ExcludeCategory,
+		filter.From != nil && expense.CreatedAt.Before(*filter.From),
+		filter.To != nil && !expense.CreatedAt.Before(*filter.To),
+		slices.Contains(filter.ExcludeIDs, expense.ID):
+		return false
+	}
+	return true
+}
+
+// SpendingTotals sums the matching expenses as the MongoDB pipeline does
+func (r *MemoryExpenseRepository) SpendingTotals(ctx context.Context, filter ExpenseFilter, loc *time.Location) (*SpendingTotals, error) {
+	defer r.db.lock(ctx)()
+
+	paid := make(map[string]*models.SpendingTotal)
+	consumed := make(map[string]*models.SpendingTotal)
+	categories := make(map[string]*models.SpendingTotal)
+	months := make(map[string]*models.SpendingTotal)
+	weekdays := make(map[string]*models.SpendingTotal)
+	add := func(totals map[string]*models.SpendingTotal, key string, amount float64) {
+		if totals[key] == nil {
+			totals[key] = &models.SpendingTotal{Key: key}
+		}
+		totals[key].Total += amount
+		totals[key].Count++
+	}
+
+	totals := &SpendingTotals{}
+	for _, expense := range r.db.groupExpenses(filter.GroupID) {
+		if !matchesExpenseFilter(expense, filter) {
+			continue
+		}
+
+		totals.Total += expense.Amount
+		totals.Count++
+		add(paid, expense.PaidBy, expense.Amount)
+		for _, member := range expense.SplitBetween {
+			add(consumed, member, expense.Amount/float64(len(expense.SplitBetween)))
+		}
+		add(categories, expense.Category, expense.Amount)
+
+		local := expense.CreatedAt.In(loc)
+		add(months, local.Format("2006-01"), expense.Amount)
+		add(weekdays, local.Weekday().String(), expense.Amount)
+	}
+
+	bucketList := func(totals map[string]*models.SpendingTotal) []models.SpendingTotal {
+		list := []models.SpendingTotal{}
+		for _, total := range totals {
+			list = append(list, *total)
+		}
+		return list
+	}
+	totals.Paid = bucketList(paid)
+	totals.Consumed = bucketList(consumed)
+	totals.Categories = bucketList(categories)
+	totals.Months = bucketList(months)
+	totals.Weekdays = bucketList(weekdays)
+	return totals, nil
+}
+
+// StreamByGroup calls fn for each expense of a group in creation order. The
+// expenses are read before fn is first called, so fn may use the repositories.
+func (r *MemoryExpenseRepository) StreamByGroup(ctx context.Context, groupID primitive.ObjectID, fn func(*models.Expense) error) error {
+	expenses, err := r.ListByGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	for i := range expenses {
+		if err := fn(&expenses[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search approximates a Mongo text search: an expense matches if a word of its
+// searchable fields has the stem of a query term and no word has the stem of a
+// negated ("-term") one. Its score is the sum of the weights of the fields of the
+// matching words.
+func (r *MemoryExpenseRepository) Search(ctx context.Context, groupIDs []primitive.ObjectID, query string, limit int64) ([]models.ExpenseSearchResult, error) {
+	terms, negated := parseSearchQuery(query)
+	terms, negated = searchStems(terms), searchStems(negated)
+
+	defer r.db.lock(ctx)()
+
+	results := []models.ExpenseSearchResult{}
+	for _, expense := range r.db.expenses {
+		if !slices.Contains(groupIDs, expense.GroupID) {
+			continue
+		}
+
+		fields := map[string]string{
+			"description": expense.Description,
+			"notes":       expense.Notes,
+			"comments":    strings.Join(expense.Comments, "\n"),
+		}
+		score := 0.0
+		excluded := false
+		for field, text := range fields {
+			for _, word := range searchWords(text) {
+				stem := SearchStem(word)
+				if stem == "" {
+					continue
+				}
+				if slices.Contains(negated, stem) {
+					excluded = true
+				}
+				if slices.Contains(terms, stem) {
+					score += memorySearchWeights[field]
+				}
+			}
+		}
+		if score > 0 && !excluded {
+			results = append(results, models.ExpenseSearchResult{Expense: *copyExpense(expense), Score: score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	if limit > 0 && int64(len(results)) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// groupExpenses returns a group's expenses sorted by creation time, then ID. Call
+// with the lock held.
+func (db *memoryDB) groupExpenses(groupID primitive.ObjectID) []*models.Expense {
+	var expenses []*models.Expense
+	for _, expense := range db.expenses {
+		if expense.GroupID == groupID {
+			expenses = append(expenses, expense)
+		}
+	}
+	sort.Slice(expenses, func(i, j int) bool {
+		if !expenses[i].CreatedAt.Equal(expenses[j].CreatedAt) {
+			return expenses[i].CreatedAt.Before(expenses[j].CreatedAt)
+		}
+		return expenses[i].ID.Hex() < expenses[j].ID.Hex()
+	})
+	return expenses
+}
+
+// searchWords splits text into lower-case words
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchStems returns the stems of query terms, leaving out stop words
+func searchStems(terms []string) []string {
+	var stems []string
+	for _, term := range terms {
+		for _, word := range searchWords(term) {
+			if stem := SearchStem(word); stem != "" {
+				stems = append(stems, stem)
+			}
+		}
+	}
+	return stems
+}
+
+// MemoryBalanceRepository stores balances in memory
+type MemoryBalanceRepository struct {
+	db *memoryDB
+}
+
+// Get retrieves a group's balances
+func (r *MemoryBalanceRepository) Get(ctx context.Context, groupID primitive.ObjectID) (*models.Balance, error) {
+	defer r.db.lock(ctx)()
+
+	balance, ok := r.db.balances[groupID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBalance(balance), nil
+}
+
+// NextSequence increments the group's event sequence number
+func (r *MemoryBalanceRepository) NextSequence(ctx context.Context, groupID primitive.ObjectID) (int64, error) {
+	defer r.db.lock(ctx)()
+
+	balance := r.db.balance(groupID)
+	balance.EventSeq++
+	r.db.balances[groupID] = balance
+	return balance.EventSeq, nil
+}
+
+// Lock returns the group's event sequence number. Transactions already hold the
+// store's lock, so nothing else can change the group until they end.
+func (r *MemoryBalanceRepository) Lock(ctx context.Context, groupID primitive.ObjectID) (int64, error) {
+	defer r.db.lock(ctx)()
+
+	balance := r.db.balance(groupID)
+	r.db.balances[groupID] = balance
+	return balance.EventSeq, nil
+}
+
+// Save replaces a group's balances
+func (r *MemoryBalanceRepository) Save(ctx context.Context, groupID primitive.ObjectID, balances map[string]float64, seq int64) error {
+	defer r.db.lock(ctx)()
+
+	balance := r.db.balance(groupID)
+	balance.Balances = maps.Clone(balances)
+	balance.RecalculatedSeq = seq
+	balance.UpdatedAt = time.Now()
+	r.db.balances[groupID] = balance
+	return nil
+}
+
+// ApplyDelta adds delta to a group's balances unless key is among the last
+// appliedDeltaHistory keys applied or a recalculation counted the event
+func (r *MemoryBalanceRepository) ApplyDelta(ctx context.Context, groupID primitive.ObjectID, key string, seq int64, delta map[string]float64) (map[string]float64, bool, error) {
+	defer r.db.lock(ctx)()
+
+	balance := r.db.balance(groupID)
+	if slices.Contains(balance.Applied, key) || (seq > 0 && seq <= balance.RecalculatedSeq) {
+		return nil, false, nil
+	}
+
+	for member, amount := range delta {
+		balance.Balances[member] += amount
+	}
+	balance.Applied = append(balance.Applied, key)
+	if len(balance.Applied) > appliedDeltaHistory {
+		balance.Applied = balance.Applied[len(balance.Applied)-appliedDeltaHistory:]
+	}
+	balance.UpdatedAt = time.Now()
+	r.db.balances[groupID] = balance
+
+	return maps.Clone(balance.Balances), true, nil
+}
+
+// balance returns a copy of a group's balances to modify, or new empty balances.
+// Call with the lock held.
+func (db *memoryDB) balance(groupID primitive.ObjectID) *models.Balance {
+	if stored, ok := db.balances[groupID]; ok {
+		balance := copyBalance(stored)
+		if balance.Balances == nil {
+			balance.Balances = make(map[string]float64)
+		}
+		return balance
+	}
+	return &models.Balance{
+		ID:       primitive.NewObjectID(),
+		GroupID:  groupID,
+		Balances: make(map[string]float64),
+	}
+}
+
+// MemoryOutboxRepository stores outbox entries in memory. Sent entries are kept.
+type MemoryOutboxRepository struct {
+	db *memoryDB
+}
+
+// Add saves an entry
+func (r *MemoryOutboxRepository) Add(ctx context.Context, entry *models.OutboxEntry) error {
+	defer r.db.lock(ctx)()
+
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	r.db.outbox[entry.ID] = copyOutboxEntry(entry)
+	return nil
+}
+
+// ClaimDue claims the entry due the longest and returns it as it was before the claim
+func (r *MemoryOutboxRepository) ClaimDue(ctx context.Context, now, retryAt time.Time) (*models.OutboxEntry, error) {
+	defer r.db.lock(ctx)()
+
+	var due *models.OutboxEntry
+	for _, entry := range r.db.outbox {
+		if entry.SentAt == nil && !entry.NextAttemptAt.After(now) && (due == nil || entry.NextAttemptAt.Before(due.NextAttemptAt)) {
+			due = entry
+		}
+	}
+	if due == nil {
+		return nil, ErrNotFound
+	}
+
+	claimed := copyOutboxEntry(due)
+	claimed.NextAttemptAt = retryAt
+	claimed.Attempts++
+	r.db.outbox[due.ID] = claimed
+
+	return copyOutboxEntry(due), nil
+}
+
+// MarkSent records that an entry was published
+func (r *MemoryOutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID, sentAt time.Time) error {
+	defer r.db.lock(ctx)()
+
+	entry, ok := r.db.outbox[id]
+	if !ok {
+		return nil // As with an update matching no entry
+	}
+
+	sent := copyOutboxEntry(entry)
+	sent.SentAt = &sentAt
+	r.db.outbox[id] = sent
+	return nil
+}
+
+// MemoryNotificationRepository stores notification preferences, sent notifications
+// and digest runs in memory
+type MemoryNotificationRepository struct {
+	db *memoryDB
+}
+
+// GetPreferences retrieves a user's preferences
+func (r *MemoryNotificationRepository) GetPreferences(ctx context.Context, user string) (*models.NotificationPreferences, error) {
+	defer r.db.lock(ctx)()
+
+	preferences, ok := r.db.preferences[user]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPreferences(preferences), nil
+}
+
+// SavePreferences creates or replaces a user's preferences
+func (r *MemoryNotificationRepository) SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error {
+	defer r.db.lock(ctx)()
+
+	r.db.preferences[preferences.User] = copyPreferences(preferences)
+	return nil
+}
+
+// ListPreferences retrieves the preferences of users
+func (r *MemoryNotificationRepository) ListPreferences(ctx context.Context, users []string) (map[string]models.NotificationPreferences, error) {
+	defer r.db.lock(ctx)()
+
+	preferences := make(map[string]models.NotificationPreferences)
+	for _, user := range users {
+		if stored, ok := r.db.preferences[user]; ok {
+			preferences[user] = *copyPreferences(stored)
+		}
+	}
+	return preferences, nil
+}
+
+// MarkSent records the notification unless it already is
+func (r *MemoryNotificationRepository) MarkSent(ctx context.Context, eventID, recipient string, sentAt time.Time) (bool, error) {
+	defer r.db.lock(ctx)()
+
+	key := eventID + ":" + recipient
+	if _, ok := r.db.notificationsSent[key]; ok {
+		return false, nil
+	}
+	r.db.notificationsSent[key] = sentAt
+	return true, nil
+}
+
+// UnmarkSent forgets the notification
+func (r *MemoryNotificationRepository) UnmarkSent(ctx context.Context, eventID, recipient string) error {
+	defer r.db.lock(ctx)()
+
+	delete(r.db.notificationsSent, eventID+":"+recipient)
+	return nil
+}
+
+// ClaimDigestRun claims a week's run unless it is completed or its claim is recent
+func (r *MemoryNotificationRepository) ClaimDigestRun(ctx context.Context, week string, startedAt, staleBefore time.Time) (bool, error) {
+	defer r.db.lock(ctx)()
+
+	if run, ok := r.db.digestRuns[week]; ok && (run.CompletedAt != nil || !run.StartedAt.Before(staleBefore)) {
+		return false, nil
+	}
+	r.db.digestRuns[week] = &models.DigestRun{Week: week, StartedAt: startedAt}
+	return true, nil
+}
+
+// CompleteDigestRun records that a week's digests were sent
+func (r *MemoryNotificationRepository) CompleteDigestRun(ctx context.Context, week string, digests int, completedAt time.Time) error {
+	defer r.db.lock(ctx)()
+
+	run, ok := r.db.digestRuns[week]
+	if !ok {
+		return nil // As with an update matching no run
+	}
+	completed := *run
+	completed.CompletedAt = &completedAt
+	completed.Digests = digests
+	r.db.digestRuns[week] = &completed
+	return nil
+}
+
+// MemoryWebhookRepository stores webhook subscriptions and deliveries in memory
+type MemoryWebhookRepository struct {
+	db *memoryDB
+}
+
+// CreateSubscription saves a new subscription
+func (r *MemoryWebhookRepository) CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	defer r.db.lock(ctx)()
+
+	if subscription.ID.IsZero() {
+		subscription.ID = primitive.NewObjectID()
+	}
+	r.db.subscriptions[subscription.ID] = copySubscription(subscription)
+	return nil
+}
+
+// GetSubscription retrieves a subscription by ID
+func (r *MemoryWebhookRepository) GetSubscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	defer r.db.lock(ctx)()
+
+	subscription, ok := r.db.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copySubscription(subscription), nil
+}
+
+// ListSubscriptions retrieves a group's subscriptions in creation order
+func (r *MemoryWebhookRepository) ListSubscriptions(ctx context.Context, groupID primitive.ObjectID) ([]models.WebhookSubscription, error) {
+	return r.findSubscriptions(ctx, func(subscription *models.WebhookSubscription) bool {
+		return subscription.GroupID == groupID
+	})
+}
+
+// ListActiveSubscriptions retrieves a group's active subscriptions to event
+func (r *MemoryWebhookRepository) ListActiveSubscriptions(ctx context.Context, groupID primitive.ObjectID, event string) ([]models.WebhookSubscription, error) {
+	return r.findSubscriptions(ctx, func(subscription *models.WebhookSubscription) bool {
+		return subscription.GroupID == groupID && subscription.Active && slices.Contains(subscription.Events, event)
+	})
+}
+
+func (r *MemoryWebhookRepository) findSubscriptions(ctx context.Context, match func(*models.WebhookSubscription) bool) ([]models.WebhookSubscription, error) {
+	defer r.db.lock(ctx)()
+
+	subscriptions := []models.WebhookSubscription{}
+	for _, subscription := range r.db.subscriptions {
+		if match(subscription) {
+			subscriptions = append(subscriptions, *copySubscription(subscription))
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID.Hex() < subscriptions[j].ID.Hex()
+	})
+	return subscriptions, nil
+}
+
+// DeleteSubscription deletes a subscription of a group and its deliveries
+func (r *MemoryWebhookRepository) DeleteSubscription(ctx context.Context, groupID, id primitive.ObjectID) error {
+	defer r.db.lock(ctx)()
+
+	subscription, ok := r.db.subscriptions[id]
+	if !ok || subscription.GroupID != groupID {
+		return ErrNotFound
+	}
+	delete(r.db.subscriptions, id)
+	maps.DeleteFunc(r.db.deliveries, func(_ primitive.ObjectID, delivery *models.WebhookDelivery) bool {
+		return delivery.SubscriptionID == id
+	})
+	return nil
+}
+
+// EnableSubscription activates a subscription of a group and resets its failures
+func (r *MemoryWebhookRepository) EnableSubscription(ctx context.Context, groupID, id primitive.ObjectID) error {
+	defer r.db.lock(ctx)()
+
+	subscription, ok := r.db.subscriptions[id]
+	if !ok || subscription.GroupID != groupID {
+		return ErrNotFound
+	}
+	enabled := copySubscription(subscription)
+	enabled.Active = true
+	enabled.ConsecutiveFailures = 0
+	enabled.DisabledAt = nil
+	r.db.subscriptions[id] = enabled
+	return nil
+}
+
+// DisableSubscription deactivates a subscription
+func (r *MemoryWebhookRepository) DisableSubscription(ctx context.Context, id primitive.ObjectID, disabledAt time.Time) error {
+	defer r.db.lock(ctx)()
+
+	subscription, ok := r.db.subscriptions[id]
+	if !ok {
+		return nil // As with an update matching no subscription
+	}
+	disabled := copySubscription(subscription)
+	disabled.Active = false
+	disabled.DisabledAt = &disabledAt
+	r.db.subscriptions[id] = disabled
+	return nil
+}
+
+// IncrementFailures counts a failed delivery and returns the updated subscription
+func (r *MemoryWebhookRepository) IncrementFailures(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	defer r.db.lock(ctx)()
+
+	subscription, ok := r.db.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	updated := copySubscription(subscription)
+	updated.ConsecutiveFailures++
+	r.db.subscriptions[id] = updated
+	return copySubscription(updated), nil
+}
+
+// ResetFailures resets a subscription's consecutive failures
+func (r *MemoryWebhookRepository) ResetFailures(ctx context.Context, id primitive.ObjectID) error {
+	defer r.db.lock(ctx)()
+
+	subscription, ok := r.db.subscriptions[id]
+	if !ok {
+		return nil // As with an update matching no subscription
+	}
+	updated := copySubscription(subscription)
+	updated.ConsecutiveFailures = 0
+	r.db.subscriptions[id] = updated
+	return nil
+}
+
+// AddDelivery saves a delivery unless the subscription already has one of the event
+func (r *MemoryWebhookRepository) AddDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	defer r.db.lock(ctx)()
+
+	for _, existing := range r.db.deliveries {
+		if existing.SubscriptionID == delivery.SubscriptionID && existing.EventID == delivery.EventID {
+			return nil
+		}
+	}
+
+	if delivery.ID.IsZero() {
+		delivery.ID = primitive.NewObjectID()
+	}
+	r.db.deliveries[delivery.ID] = copyDelivery(delivery)
+	return nil
+}
+
+// ListDeliveries retrieves the latest deliveries of a subscription
+func (r *MemoryWebhookRepository) ListDeliveries(ctx context.Context, groupID, subscriptionID primitive.ObjectID, limit int64) ([]models.WebhookDelivery, error) {
+	defer r.db.lock(ctx)()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range r.db.deliveries {
+		if delivery.GroupID == groupID && delivery.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, *copyDelivery(delivery))
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID.Hex() > deliveries[j].ID.Hex()
+	})
+	if limit > 0 && int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// ClaimDueDelivery claims the pending delivery due the longest and returns it as it
+// was before the claim
+func (r *MemoryWebhookRepository) ClaimDueDelivery(ctx context.Context, now, leaseUntil time.Time) (*models.WebhookDelivery, error) {
+	defer r.db.lock(ctx)()
+
+	var due *models.WebhookDelivery
+	for _, delivery := range r.db.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) && (due == nil || delivery.NextAttemptAt.Before(due.NextAttemptAt)) {
+			due = delivery
+		}
+	}
+	if due == nil {
+		return nil, ErrNotFound
+	}
+
+	claimed := copyDelivery(due)
+	claimed.NextAttemptAt = leaseUntil
+	r.db.deliveries[due.ID] = claimed
+
+	return copyDelivery(due), nil
+}
+
+// UpdateDelivery saves the outcome of an attempt
+func (r *MemoryWebhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	defer r.db.lock(ctx)()
+
+	stored, ok := r.db.deliveries[delivery.ID]
+	if !ok {
+		return nil // As with an update matching no delivery
+	}
+
+	updated := copyDelivery(stored)
+	updated.Status = delivery.Status
+	updated.Attempts = delivery.Attempts
+	updated.NextAttemptAt = delivery.NextAttemptAt
+	updated.LastAttemptAt = delivery.LastAttemptAt
+	updated.ResponseStatus = delivery.ResponseStatus
+	updated.LastError = delivery.LastError
+	r.db.deliveries[delivery.ID] = updated
+	return nil
+}
+
+// MemoryBudgetRepository stores budgets in memory
+type MemoryBudgetRepository struct {
+	db *memoryDB
+}
+
+// Create saves a new budget
+func (r *MemoryBudgetRepository) Create(ctx context.Context, budget *models.Budget) error {
+	defer r.db.lock(ctx)()
+
+	if budget.ID.IsZero() {
+		budget.ID = primitive.NewObjectID()
+	}
+	r.db.budgets[budget.ID] = copyBudget(budget)
+	return nil
+}
+
+// Delete deletes a budget of a group
+func (r *MemoryBudgetRepository) Delete(ctx context.Context, groupID, id primitive.ObjectID) error {
+	defer r.db.lock(ctx)()
+
+	budget, ok := r.db.budgets[id]
+	if !ok || budget.GroupID != groupID {
+		return ErrNotFound
+	}
+	delete(r.db.budgets, id)
+	return nil
+}
+
+// ListByGroup retrieves a group's budgets in creation order
+func (r *MemoryBudgetRepository) ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Budget, error) {
+	defer r.db.lock(ctx)()
+
+	var budgets []models.Budget
+	for _, budget := range r.db.budgets {
+		if budget.GroupID == groupID {
+			budgets = append(budgets, *copyBudget(budget))
+		}
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		return budgets[i].ID.Hex() < budgets[j].ID.Hex()
+	})
+	return budgets, nil
+}
+
+// MarkAlerted records the alert unless it already is
+func (r *MemoryBudgetRepository) MarkAlerted(ctx context.Context, id primitive.ObjectID, period string, threshold int) (bool, error) {
+	defer r.db.lock(ctx)()
+
+	budget, ok := r.db.budgets[id]
+	if !ok || slices.Contains(budget.Alerts[period], threshold) {
+		return false, nil
+	}
+
+	alerted := copyBudget(budget)
+	if alerted.Alerts == nil {
+		alerted.Alerts = make(map[string][]int)
+	}
+	alerted.Alerts[period] = append(alerted.Alerts[period], threshold)
+	r.db.budgets[id] = alerted
+	return true, nil
+}
+
+// UnmarkAlerted forgets the alert
+func (r *MemoryBudgetRepository) UnmarkAlerted(ctx context.Context, id primitive.ObjectID, period string, threshold int) error {
+	defer r.db.lock(ctx)()
+
+	budget, ok := r.db.budgets[id]
+	if !ok || !slices.Contains(budget.Alerts[period], threshold) {
+		return nil // As with an update matching no budget
+	}
+
+	unmarked := copyBudget(budget)
+	unmarked.Alerts[period] = slices.DeleteFunc(unmarked.Alerts[period], func(alerted int) bool { return alerted == threshold })
+	r.db.budgets[id] = unmarked
+	return nil
+}
+
+// MemoryStatementRepository stores statement transactions in memory
+type MemoryStatementRepository struct {
+	db *memoryDB
+}
+
+// Add saves a new transaction unless the member already has its external ID
+func (r *MemoryStatementRepository) Add(ctx context.Context, transaction *models.StatementTransaction) (bool, error) {
+	defer r.db.lock(ctx)()
+
+	for _, stored := range r.db.statements {
+		if stored.GroupID == transaction.GroupID && stored.Member == transaction.Member && stored.ExternalID == transaction.ExternalID {
+			return false, nil
+		}
+	}
+
+	if transaction.ID.IsZero() {
+		transaction.ID = primitive.NewObjectID()
+	}
+	r.db.statements[transaction.ID] = copyStatementTransaction(transaction)
+	return true, nil
+}
+
+// ExistingExternalIDs returns which of externalIDs a member already has in a group
+func (r *MemoryStatementRepository) ExistingExternalIDs(ctx context.Context, groupID primitive.ObjectID, member string, externalIDs []string) (map[string]bool, error) {
+	defer r.db.lock(ctx)()
+
+	existing := make(map[string]bool)
+	for _, stored := range r.db.statements {
+		if stored.GroupID == groupID && stored.Member == member && slices.Contains(externalIDs, stored.ExternalID) {
+			existing[stored.ExternalID] = true
+		}
+	}
+	return existing, nil
+}
+
+// LinkedExpenseIDs returns the expenses of a group that a transaction is linked to
+func (r *MemoryStatementRepository) LinkedExpenseIDs(ctx context.Context, groupID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	defer r.db.lock(ctx)()
+
+	var ids []primitive.ObjectID
+	for _, stored := range r.db.statements {
+		if stored.GroupID == groupID && stored.ExpenseID != nil && !slices.Contains(ids, *stored.ExpenseID) {
+			ids = append(ids, *stored.ExpenseID)
+		}
+	}
+	return ids, nil
+}
+
+// Get retrieves a transaction of a group
+func (r *MemoryStatementRepository) Get(ctx context.Context, groupID, id primitive.ObjectID) (*models.StatementTransaction, error) {
+	defer r.db.lock(ctx)()
+
+	transaction, ok := r.db.statements[id]
+	if !ok || transaction.GroupID != groupID {
+		return nil, ErrNotFound
+	}
+	return copyStatementTransaction(transaction), nil
+}
+
+// List returns a group's transactions by date, optionally filtered by status
+func (r *MemoryStatementRepository) List(ctx context.Context, groupID primitive.ObjectID, status string) ([]models.StatementTransaction, error) {
+	defer r.db.lock(ctx)()
+
+	transactions := []models.StatementTransaction{}
+	for _, stored := range r.db.statements {
+		if stored.GroupID == groupID && (status == "" || stored.Status == status) {
+			transactions = append(transactions, *copyStatementTransaction(stored))
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		if !transactions[i].Date.Equal(transactions[j].Date) {
+			return transactions[i].Date.Before(transactions[j].Date)
+		}
+		return transactions[i].ID.Hex() < transactions[j].ID.Hex()
+	})
+	return transactions, nil
+}
+
+// Accept marks a pending transaction accepted, linked to expenseID
+func (r *MemoryStatementRepository) Accept(ctx context.Context, groupID, id, expenseID primitive.ObjectID) error {
+	defer r.db.lock(ctx)()
+
+	transaction, ok := r.db.statements[id]
+	if !ok || transaction.GroupID != groupID || transaction.Status != models.TransactionPending {
+		return ErrNotFound
+	}
+
+	accepted := copyStatementTransaction(transaction)
+	accepted.Status = models.TransactionAccepted
+	accepted.ExpenseID = &expenseID
+	r.db.statements[id] = accepted
+	return nil
+}
+
+// Reject marks the pending transactions of a group among ids as rejected
+func (r *MemoryStatementRepository) Reject(ctx context.Context, groupID primitive.ObjectID, ids []primitive.ObjectID) error {
+	defer r.db.lock(ctx)()
+
+	for _, id := range ids {
+		transaction, ok := r.db.statements[id]
+		if !ok || transaction.GroupID != groupID || transaction.Status != models.TransactionPending {
+			continue
+		}
+
+		rejected := copyStatementTransaction(transaction)
+		rejected.Status = models.TransactionRejected
+		r.db.statements[id] = rejected
+	}
+	return nil
+}
+
+var (
+	_ GroupRepository        = (*MemoryGroupRepository)(nil)
+	_ ExpenseRepository      = (*MemoryExpenseRepository)(nil)
+	_ BalanceRepository      = (*MemoryBalanceRepository)(nil)
+	_ OutboxRepository       = (*MemoryOutboxRepository)(nil)
+	_ StatementRepository    = (*MemoryStatementRepository)(nil)
+	_ NotificationRepository = (*MemoryNotificationRepository)(nil)
+	_ WebhookRepository      = (*MemoryWebhookRepository)(nil)
+	_ BudgetRepository       = (*MemoryBudgetRepository)(nil)
+)
+
+// The copy functions keep callers from sharing slices and maps with stored documents
+
+func copyGroup(group *models.Group) *models.Group {
+	c := *group
+	c.Members = slices.Clone(group.Members)
+	return &c
+}
+
+func copyExpense(expense *models.Expense) *models.Expense {
+	c := *expense
+	c.SplitBetween = slices.Clone(expense.SplitBetween)
+	c.Comments = slices.Clone(expense.Comments)
+	return &c
+}
+
+func copyBalance(balance *models.Balance) *models.Balance {
+	c := *balance
+	c.Balances = maps.Clone(balance.Balances)
+	c.Applied = slices.Clone(balance.Applied)
+	return &c
+}
+
+func copyOutboxEntry(entry *models.OutboxEntry) *models.OutboxEntry {
+	c := *entry
+	c.Body = slices.Clone(entry.Body)
+	if entry.SentAt != nil {
+		sentAt := *entry.SentAt
+		c.SentAt = &sentAt
+	}
+	return &c
+}
+
+func copyStatementTransaction(transaction *models.StatementTransaction) *models.StatementTransaction {
+	c := *transaction
+	if transaction.ExpenseID != nil {
+		expenseID := *transaction.ExpenseID
+		c.ExpenseID = &expenseID
+	}
+	return &c
+}
+
+func copyPreferences(preferences *models.NotificationPreferences) *models.NotificationPreferences {
+	c := *preferences
+	c.OptOut = slices.Clone(preferences.OptOut)
+	return &c
+}
+
+func copySubscription(subscription *models.WebhookSubscription) *models.WebhookSubscription {
+	c := *subscription
+	c.Events = slices.Clone(subscription.Events)
+	if subscription.DisabledAt != nil {
+		disabledAt := *subscription.DisabledAt
+		c.DisabledAt = &disabledAt
+	}
+	return &c
+}
+
+func copyDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	c := *delivery
+	if delivery.LastAttemptAt != nil {
+		lastAttemptAt := *delivery.LastAttemptAt
+		c.LastAttemptAt = &lastAttemptAt
+	}
+	return &c
+}
+
+func copyBudget(budget *models.Budget) *models.Budget {
+	c := *budget
+	if budget.StartDate != nil {
+		startDate := *budget.StartDate
+		c.StartDate = &startDate
+	}
+	if budget.EndDate != nil {
+		endDate := *budget.EndDate
+		c.EndDate = &endDate
+	}
+	if budget.Alerts != nil {
+		c.Alerts = make(map[string][]int, len(budget.Alerts))
+		for period, thresholds := range budget.Alerts {
+			c.Alerts[period] = slices.Clone(thresholds)
+		}
+	}
+	return &c
+}
@@ -0,0 +1,65 @@
+-- Groups, expenses, balances and the outbox, mirroring the MongoDB collections.
+-- IDs are ObjectIDs in hex, so models and API responses are the same on both backends.
+
+CREATE TABLE groups (
+    id         CHAR(24) PRIMARY KEY,
+    name       TEXT NOT NULL,
+    members    TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX groups_name_idx ON groups (name);
+CREATE INDEX groups_members_idx ON groups USING GIN (members);
+
+CREATE TABLE expenses (
+    id            CHAR(24) PRIMARY KEY,
+    group_id      CHAR(24) NOT NULL,
+    description   TEXT NOT NULL,
+    amount        DOUBLE PRECISION NOT NULL,
+    paid_by       TEXT NOT NULL,
+    split_between TEXT[] NOT NULL DEFAULT '{}',
+    category      TEXT NOT NULL DEFAULT '',
+    currency      TEXT NOT NULL DEFAULT '',
+    notes         TEXT NOT NULL DEFAULT '',
+    comments      TEXT[] NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMPTZ NOT NULL,
+    search        TSVECTOR -- Maintained by expenses_search_update
+);
+
+CREATE INDEX expenses_group_created_idx ON expenses (group_id, created_at, id);
+CREATE INDEX expenses_search_idx ON expenses USING GIN (search);
+
+-- Weighted like the MongoDB text index: description A, notes B, comments C
+CREATE FUNCTION expenses_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search :=
+        setweight(to_tsvector('english', NEW.description), 'A') ||
+        setweight(to_tsvector('english', NEW.notes), 'B') ||
+        setweight(to_tsvector('english', array_to_string(NEW.comments, ' ')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER expenses_search_update BEFORE INSERT OR UPDATE ON expenses
+    FOR EACH ROW EXECUTE FUNCTION expenses_search_update();
+
+CREATE TABLE balances (
+    group_id   CHAR(24) PRIMARY KEY,
+    balances   JSONB NOT NULL DEFAULT '{}',
+    applied    TEXT[] NOT NULL DEFAULT '{}', -- Recently applied delta keys
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE outbox (
+    id              CHAR(24) PRIMARY KEY,
+    queue           TEXT NOT NULL,
+    body            BYTEA NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    sent_at         TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_due_idx ON outbox (next_attempt_at) WHERE sent_at IS NULL;
+CREATE INDEX outbox_sent_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
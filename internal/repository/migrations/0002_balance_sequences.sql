@@ -0,0 +1,5 @@
+-- Expense events are sequenced per group so recalculations can tell which deltas
+-- they already counted
+ALTER TABLE balances
+    ADD COLUMN event_seq        BIGINT NOT NULL DEFAULT 0, -- Sequence number of the group's last expense event
+    ADD COLUMN recalculated_seq BIGINT NOT NULL DEFAULT 0; -- Last event counted by a recalculation
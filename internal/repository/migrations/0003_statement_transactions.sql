@@ -0,0 +1,18 @@
+-- Transactions imported from members' statements, kept with the expenses so that
+-- accepting a proposal saves the expense and the transaction together
+CREATE TABLE statement_transactions (
+    id          CHAR(24) PRIMARY KEY,
+    group_id    CHAR(24) NOT NULL,
+    member      TEXT NOT NULL,
+    external_id TEXT NOT NULL, -- FITID, or a hash of the row for CSV
+    date        TIMESTAMPTZ NOT NULL,
+    amount      DOUBLE PRECISION NOT NULL,
+    description TEXT NOT NULL,
+    status      TEXT NOT NULL,
+    expense_id  CHAR(24), -- Linked or created expense
+    match_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    created_at  TIMESTAMPTZ NOT NULL,
+    UNIQUE (group_id, member, external_id)
+);
+
+CREATE INDEX statement_transactions_group_date_idx ON statement_transactions (group_id, date, id);
@@ -0,0 +1,1065 @@
+package repository
+
+import (
+	"context"
+	"expense-split-wise/internal/database"
+	"expense-split-wise/internal/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// appliedDeltaHistory is how many applied delta keys each balance document remembers.
+	// Redelivered messages arrive well within this many later expenses.
+	appliedDeltaHistory = 1000
+	// outboxRetention is how long sent outbox entries are kept
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// NewMongoStore returns the repositories backed by MongoDB
+func NewMongoStore(mongo *database.MongoClient) *Store {
+	return &Store{
+		Transactor: &MongoTransactor{mongo: mongo},
+		Groups:     &MongoGroupRepository{mongo: mongo},
+		Expenses:   &MongoExpenseRepository{mongo: mongo},
+		Balances:   &MongoBalanceRepository{mongo: mongo},
+		Outbox:     &MongoOutboxRepository{mongo: mongo},
+		Statements: &MongoStatementRepository{mongo: mongo},
+
+		Notifications: &MongoNotificationRepository{mongo: mongo},
+		Webhooks:      &MongoWebhookRepository{mongo: mongo},
+		Budgets:       &MongoBudgetRepository{mongo: mongo},
+	}
+}
+
+// MongoTransactor runs MongoDB transactions, which need MongoDB to run as a replica
+// set (a single node is enough)
+type MongoTransactor struct {
+	mongo *database.MongoClient
+}
+
+// WithTransaction runs fn in a transaction, retrying it on transient errors
+func (t *MongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return t.mongo.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) error {
+		return fn(sessionCtx)
+	})
+}
+
+// MongoGroupRepository stores groups in the groups collection
+type MongoGroupRepository struct {
+	mongo *database.MongoClient
+}
+
+// Create saves a new group
+func (r *MongoGroupRepository) Create(ctx context.Context, group *models.Group) error {
+	result, err := r.mongo.Collection("groups").InsertOne(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	group.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Get retrieves a group by ID
+func (r *MongoGroupRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Group, error) {
+	var group models.Group
+	if err := r.mongo.Collection("groups").FindOne(ctx, bson.M{"_id": id}).Decode(&group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// List returns every group, ordered by name
+func (r *MongoGroupRepository) List(ctx context.Context) ([]models.Group, error) {
+	cursor, err := r.mongo.Collection("groups").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// AddMembers adds members to a group and returns the group as it was before
+func (r *MongoGroupRepository) AddMembers(ctx context.Context, id primitive.ObjectID, members []string, updatedAt time.Time) (*models.Group, error) {
+	var before models.Group
+	err := r.mongo.Collection("groups").FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$addToSet": bson.M{"members": bson.M{"$each": members}},
+			"$set":      bson.M{"updatedAt": updatedAt},
+		},
+	).Decode(&before)
+	if err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+// ListByMember returns the groups a member belongs to
+func (r *MongoGroupRepository) ListByMember(ctx context.Context, member string) ([]models.Group, error) {
+	cursor, err := r.mongo.Collection("groups").Find(ctx, bson.M{"members": member})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// MongoExpenseRepository stores expenses in the expenses collection
+type MongoExpenseRepository struct {
+	mongo *database.MongoClient
+}
+
+// EnsureIndexes creates the text index used by Search
+func (r *MongoExpenseRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.mongo.Collection("expenses").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "description", Value: "text"}, {Key: "notes", Value: "text"}, {Key: "comments", Value: "text"}},
+		Options: options.Index().
+			SetName("expenses_text").
+			SetWeights(bson.D{{Key: "description", Value: 10}, {Key: "notes", Value: 5}, {Key: "comments", Value: 1}}),
+	})
+	return err
+}
+
+// Create saves a new expense
+func (r *MongoExpenseRepository) Create(ctx context.Context, expense *models.Expense) error {
+	result, err := r.mongo.Collection("expenses").InsertOne(ctx, expense)
+	if err != nil {
+		return err
+	}
+
+	expense.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// CreateMany saves new expenses with a single insert
+func (r *MongoExpenseRepository) CreateMany(ctx context.Context, expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(expenses))
+	for i := range expenses {
+		docs[i] = &expenses[i]
+	}
+
+	result, err := r.mongo.Collection("expenses").InsertMany(ctx, docs)
+	if err != nil {
+		return err
+	}
+	for i, id := range result.InsertedIDs {
+		expenses[i].ID = id.(primitive.ObjectID)
+	}
+	return nil
+}
+
+// Get retrieves an expense by ID
+func (r *MongoExpenseRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Expense, error) {
+	var expense models.Expense
+	if err := r.mongo.Collection("expenses").FindOne(ctx, bson.M{"_id": id}).Decode(&expense); err != nil {
+		return nil, err
+	}
+	return &expense, nil
+}
+
+// Update replaces the editable fields of an expense and returns its previous version
+func (r *MongoExpenseRepository) Update(ctx context.Context, expense *models.Expense) (*models.Expense, error) {
+	var previous models.Expense
+	err := r.mongo.Collection("expenses").FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": expense.ID, "groupId": expense.GroupID},
+		bson.M{"$set": bson.M{
+			"description":  expense.Description,
+			"amount":       expense.Amount,
+			"paidBy":       expense.PaidBy,
+			"splitBetween": expense.SplitBetween,
+			"category":     expense.Category,
+			"currency":     expense.Currency,
+			"notes":        expense.Notes,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+// Delete deletes an expense and returns it
+func (r *MongoExpenseRepository) Delete(ctx context.Context, groupID, expenseID primitive.ObjectID) (*models.Expense, error) {
+	var expense models.Expense
+	err := r.mongo.Collection("expenses").FindOneAndDelete(ctx, bson.M{"_id": expenseID, "groupId": groupID}).Decode(&expense)
+	if err != nil {
+		return nil, err
+	}
+	return &expense, nil
+}
+
+// ListByGroup retrieves all expenses for a group
+func (r *MongoExpenseRepository) ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Expense, error) {
+	cursor, err := r.mongo.Collection("expenses").Find(ctx, bson.M{"groupId": groupID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var expenses []models.Expense
+	if err := cursor.All(ctx, &expenses); err != nil {
+		return nil, err
+	}
+	return expenses, nil
+}
+
+// Find returns the expenses matching filter
+func (r *MongoExpenseRepository) Find(ctx context.Context, filter ExpenseFilter) ([]models.Expense, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.mongo.Collection("expenses").Find(ctx, expenseQuery(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	expenses := []models.Expense{}
+	if err := cursor.All(ctx, &expenses); err != nil {
+		return nil, err
+	}
+	return expenses, nil
+}
+
+// expenseQuery returns the query matching the expenses selected by filter
+func expenseQuery(filter ExpenseFilter) bson.M {
+	query := bson.M{"groupId": filter.GroupID}
+	if filter.PaidBy != "" {
+		query["paidBy"] = filter.PaidBy
+	}
+	if filter.Category != "" {
+		query["category"] = filter.Category
+	} else if filter.ExcludeCategory != "" {
+		query["category"] = bson.M{"$ne": filter.ExcludeCategory}
+	}
+	if filter.From != nil || filter.To != nil {
+		createdAt := bson.M{}
+		if filter.From != nil {
+			createdAt["$gte"] = *filter.From
+		}
+		if filter.To != nil {
+			createdAt["$lt"] = *filter.To
+		}
+		query["createdAt"] = createdAt
+	}
+	if len(filter.ExcludeIDs) > 0 {
+		query["_id"] = bson.M{"$nin": filter.ExcludeIDs}
+	}
+	return query
+}
+
+// SpendingTotals sums the matching expenses in one aggregation, a $facet per bucket
+func (r *MongoExpenseRepository) SpendingTotals(ctx context.Context, filter ExpenseFilter, loc *time.Location) (*SpendingTotals, error) {
+	sumBy := func(key interface{}, stages ...bson.M) bson.A {
+		pipeline := bson.A{}
+		for _, stage := range stages {
+			pipeline = append(pipeline, stage)
+		}
+		return append(pipeline, bson.M{"$group": bson.M{
+			"_id":   key,
+			"total": bson.M{"$sum": "$amount"},
+			"count": bson.M{"$sum": 1},
+		}})
+	}
+	timezone := loc.String()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: expenseQuery(filter)}},
+		{{Key: "$facet", Value: bson.M{
+			"total": sumBy(nil),
+			"paid":  sumBy("$paidBy"),
+			// Each member of the split consumes an equal share
+			"consumed": sumBy("$splitBetween",
+				bson.M{"$match": bson.M{"splitBetween.0": bson.M{"$exists": true}}},
+				bson.M{"$set": bson.M{"amount": bson.M{"$divide": bson.A{"$amount", bson.M{"$size": "$splitBetween"}}}}},
+				bson.M{"$unwind": "$splitBetween"},
+			),
+			"categories": sumBy(bson.M{"$ifNull": bson.A{"$category", ""}}),
+			"months":     sumBy(bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$createdAt", "timezone": timezone}}),
+			"weekdays":   sumBy(bson.M{"$dayOfWeek": bson.M{"date": "$createdAt", "timezone": timezone}}),
+		}}},
+	}
+
+	cursor, err := r.mongo.Collection("expenses").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	type bucket struct {
+		Key   string  `bson:"_id"`
+		Total float64 `bson:"total"`
+		Count int     `bson:"count"`
+	}
+	var results []struct {
+		Total []struct {
+			Total float64 `bson:"total"`
+			Count int     `bson:"count"`
+		} `bson:"total"`
+		Paid       []bucket `bson:"paid"`
+		Consumed   []bucket `bson:"consumed"`
+		Categories []bucket `bson:"categories"`
+		Months     []bucket `bson:"months"`
+		Weekdays   []struct {
+			Day   int     `bson:"_id"` // 1 for Sunday to 7 for Saturday
+			Total float64 `bson:"total"`
+			Count int     `bson:"count"`
+		} `bson:"weekdays"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	totals := &SpendingTotals{}
+	if len(results) == 0 {
+		return totals, nil
+	}
+	result := results[0]
+	if len(result.Total) > 0 {
+		totals.Total, totals.Count = result.Total[0].Total, result.Total[0].Count
+	}
+	spending := func(buckets []bucket) []models.SpendingTotal {
+		list := []models.SpendingTotal{}
+		for _, b := range buckets {
+			list = append(list, models.SpendingTotal{Key: b.Key, Total: b.Total, Count: b.Count})
+		}
+		return list
+	}
+	totals.Paid = spending(result.Paid)
+	totals.Consumed = spending(result.Consumed)
+	totals.Categories = spending(result.Categories)
+	totals.Months = spending(result.Months)
+	totals.Weekdays = []models.SpendingTotal{}
+	for _, day := range result.Weekdays {
+		totals.Weekdays = append(totals.Weekdays, models.SpendingTotal{Key: time.Weekday(day.Day - 1).String(), Total: day.Total, Count: day.Count})
+	}
+	return totals, nil
+}
+
+// StreamByGroup reads a group's expenses from the cursor one document at a time
+// instead of loading the whole group
+func (r *MongoExpenseRepository) StreamByGroup(ctx context.Context, groupID primitive.ObjectID, fn func(*models.Expense) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.mongo.Collection("expenses").Find(ctx, bson.M{"groupId": groupID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var expense models.Expense
+		if err := cursor.Decode(&expense); err != nil {
+			return err
+		}
+		if err := fn(&expense); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// Search runs a $text query over the expenses of groups, ranked by text score
+func (r *MongoExpenseRepository) Search(ctx context.Context, groupIDs []primitive.ObjectID, query string, limit int64) ([]models.ExpenseSearchResult, error) {
+	filter := bson.M{
+		"groupId": bson.M{"$in": groupIDs},
+		"$text":   bson.M{"$search": query},
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "createdAt", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := r.mongo.Collection("expenses").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []models.ExpenseSearchResult{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// MongoBalanceRepository stores balances in the balances collection, one document per group
+type MongoBalanceRepository struct {
+	mongo *database.MongoClient
+}
+
+// EnsureIndexes creates the unique index ApplyDelta relies on to detect deltas already applied
+func (r *MongoBalanceRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.mongo.Collection("balances").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "groupId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Get retrieves a group's balances
+func (r *MongoBalanceRepository) Get(ctx context.Context, groupID primitive.ObjectID) (*models.Balance, error) {
+	var balance models.Balance
+	if err := r.mongo.Collection("balances").FindOne(ctx, bson.M{"groupId": groupID}).Decode(&balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// NextSequence increments the group's event sequence number, creating its document
+// if needed. Writing the document makes a concurrent transaction that locked it
+// conflict with this one, so one of them is retried.
+func (r *MongoBalanceRepository) NextSequence(ctx context.Context, groupID primitive.ObjectID) (int64, error) {
+	return r.touch(ctx, groupID, bson.M{"$inc": bson.M{"eventSeq": 1}})
+}
+
+// Lock writes the group's document, creating it if needed, so the transaction ctx
+// runs in conflicts with any other writing it before it commits
+func (r *MongoBalanceRepository) Lock(ctx context.Context, groupID primitive.ObjectID) (int64, error) {
+	return r.touch(ctx, groupID, bson.M{"$set": bson.M{"updatedAt": time.Now()}})
+}
+
+// touch applies update to the group's document, creating it if needed, and returns
+// the group's event sequence number
+func (r *MongoBalanceRepository) touch(ctx context.Context, groupID primitive.ObjectID, update bson.M) (int64, error) {
+	update["$setOnInsert"] = bson.M{"balances": bson.M{}}
+
+	var balance models.Balance
+	err := r.mongo.Collection("balances").FindOneAndUpdate(
+		ctx,
+		bson.M{"groupId": groupID},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&balance)
+	if err != nil {
+		return 0, err
+	}
+	return balance.EventSeq, nil
+}
+
+// Save replaces a group's balances, creating its document if needed
+func (r *MongoBalanceRepository) Save(ctx context.Context, groupID primitive.ObjectID, balances map[string]float64, seq int64) error {
+	_, err := r.mongo.Collection("balances").UpdateOne(
+		ctx,
+		bson.M{"groupId": groupID},
+		bson.M{"$set": bson.M{"groupId": groupID, "balances": balances, "recalculatedSeq": seq, "updatedAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// ApplyDelta adds delta with a single atomic update that also records key, and is
+// skipped if key is among the last appliedDeltaHistory keys or a recalculation
+// counted the event. Member names that cannot be used in a field path return
+// ErrDeltaUnsupported.
+func (r *MongoBalanceRepository) ApplyDelta(ctx context.Context, groupID primitive.ObjectID, key string, seq int64, delta map[string]float64) (map[string]float64, bool, error) {
+	inc := bson.M{}
+	for member, amount := range delta {
+		if member == "" || strings.Contains(member, ".") || strings.HasPrefix(member, "$") {
+			return nil, false, ErrDeltaUnsupported
+		}
+		inc["balances."+member] = amount
+	}
+
+	filter := bson.M{"groupId": groupID, "applied": bson.M{"$ne": key}}
+	if seq > 0 {
+		// $not also matches documents no recalculation has set the field on
+		filter["recalculatedSeq"] = bson.M{"$not": bson.M{"$gte": seq}}
+	}
+
+	var balance models.Balance
+	err := r.mongo.Collection("balances").FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{
+			"$inc":  inc,
+			"$push": bson.M{"applied": bson.M{"$each": bson.A{key}, "$slice": -appliedDeltaHistory}},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&balance)
+	if mongo.IsDuplicateKeyError(err) {
+		// The balance document exists and already counts the delta, so the upsert collided with it
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return balance.Balances, true, nil
+}
+
+// MongoOutboxRepository stores outbox entries in the outbox collection
+type MongoOutboxRepository struct {
+	mongo *database.MongoClient
+}
+
+// EnsureIndexes creates the index ClaimDue polls and the TTL index that removes sent entries
+func (r *MongoOutboxRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.mongo.Collection("outbox").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "nextAttemptAt", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"sentAt": bson.M{"$exists": false}}),
+		},
+		{
+			Keys:    bson.D{{Key: "sentAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// Add saves an entry
+func (r *MongoOutboxRepository) Add(ctx context.Context, entry *models.OutboxEntry) error {
+	result, err := r.mongo.Collection("outbox").InsertOne(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ClaimDue claims the entry due the longest with a single atomic update, so relays
+// in several processes never claim the same entry
+func (r *MongoOutboxRepository) ClaimDue(ctx context.Context, now, retryAt time.Time) (*models.OutboxEntry, error) {
+	var entry models.OutboxEntry
+	err := r.mongo.Collection("outbox").FindOneAndUpdate(
+		ctx,
+		bson.M{"sentAt": bson.M{"$exists": false}, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{
+			"$set": bson.M{"nextAttemptAt": retryAt},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}),
+	).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// MarkSent records that an entry was published
+func (r *MongoOutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID, sentAt time.Time) error {
+	_, err := r.mongo.Collection("outbox").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"sentAt": sentAt}})
+	return err
+}
+
+// MongoNotificationRepository stores notification preferences in the
+// notification_preferences collection, keyed by user, sent notifications in the
+// notifications_sent collection and digest runs in the digest_runs collection
+type MongoNotificationRepository struct {
+	mongo *database.MongoClient
+}
+
+// GetPreferences retrieves a user's preferences
+func (r *MongoNotificationRepository) GetPreferences(ctx context.Context, user string) (*models.NotificationPreferences, error) {
+	var preferences models.NotificationPreferences
+	if err := r.mongo.Collection("notification_preferences").FindOne(ctx, bson.M{"_id": user}).Decode(&preferences); err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+// SavePreferences creates or replaces a user's preferences
+func (r *MongoNotificationRepository) SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error {
+	_, err := r.mongo.Collection("notification_preferences").ReplaceOne(
+		ctx,
+		bson.M{"_id": preferences.User},
+		preferences,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// ListPreferences retrieves the preferences of users
+func (r *MongoNotificationRepository) ListPreferences(ctx context.Context, users []string) (map[string]models.NotificationPreferences, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := r.mongo.Collection("notification_preferences").Find(ctx, bson.M{"_id": bson.M{"$in": users}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var list []models.NotificationPreferences
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	preferences := make(map[string]models.NotificationPreferences, len(list))
+	for _, pref := range list {
+		preferences[pref.User] = pref
+	}
+	return preferences, nil
+}
+
+// MarkSent inserts a document keyed by event and recipient, which fails as a
+// duplicate if the notification was already sent
+func (r *MongoNotificationRepository) MarkSent(ctx context.Context, eventID, recipient string, sentAt time.Time) (bool, error) {
+	_, err := r.mongo.Collection("notifications_sent").InsertOne(ctx, bson.M{
+		"_id":    eventID + ":" + recipient,
+		"sentAt": sentAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UnmarkSent deletes the document MarkSent inserted
+func (r *MongoNotificationRepository) UnmarkSent(ctx context.Context, eventID, recipient string) error {
+	_, err := r.mongo.Collection("notifications_sent").DeleteOne(ctx, bson.M{"_id": eventID + ":" + recipient})
+	return err
+}
+
+// ClaimDigestRun upserts the week's document in the digest_runs collection unless it
+// is completed or its claim is recent, in which case the upsert fails as a duplicate
+func (r *MongoNotificationRepository) ClaimDigestRun(ctx context.Context, week string, startedAt, staleBefore time.Time) (bool, error) {
+	_, err := r.mongo.Collection("digest_runs").UpdateOne(
+		ctx,
+		bson.M{"_id": week, "completedAt": bson.M{"$exists": false}, "startedAt": bson.M{"$lt": staleBefore}},
+		bson.M{"$set": bson.M{"startedAt": startedAt}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompleteDigestRun records that a week's digests were sent
+func (r *MongoNotificationRepository) CompleteDigestRun(ctx context.Context, week string, digests int, completedAt time.Time) error {
+	_, err := r.mongo.Collection("digest_runs").UpdateOne(
+		ctx,
+		bson.M{"_id": week},
+		bson.M{"$set": bson.M{"completedAt": completedAt, "digests": digests}},
+	)
+	return err
+}
+
+// MongoWebhookRepository stores webhook subscriptions in the webhook_subscriptions
+// collection and deliveries in the webhook_deliveries collection
+type MongoWebhookRepository struct {
+	mongo *database.MongoClient
+}
+
+// EnsureIndexes creates the unique index that keeps an event from being delivered
+// twice to a subscription, and the index ClaimDueDelivery polls
+func (r *MongoWebhookRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.mongo.Collection("webhook_deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "subscriptionId", Value: 1}, {Key: "eventId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+		},
+	})
+	return err
+}
+
+// CreateSubscription saves a new subscription
+func (r *MongoWebhookRepository) CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	result, err := r.mongo.Collection("webhook_subscriptions").InsertOne(ctx, subscription)
+	if err != nil {
+		return err
+	}
+
+	subscription.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetSubscription retrieves a subscription by ID
+func (r *MongoWebhookRepository) GetSubscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	if err := r.mongo.Collection("webhook_subscriptions").FindOne(ctx, bson.M{"_id": id}).Decode(&subscription); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ListSubscriptions retrieves a group's subscriptions
+func (r *MongoWebhookRepository) ListSubscriptions(ctx context.Context, groupID primitive.ObjectID) ([]models.WebhookSubscription, error) {
+	return r.findSubscriptions(ctx, bson.M{"groupId": groupID})
+}
+
+// ListActiveSubscriptions retrieves a group's active subscriptions to event
+func (r *MongoWebhookRepository) ListActiveSubscriptions(ctx context.Context, groupID primitive.ObjectID, event string) ([]models.WebhookSubscription, error) {
+	return r.findSubscriptions(ctx, bson.M{"groupId": groupID, "active": true, "events": event})
+}
+
+func (r *MongoWebhookRepository) findSubscriptions(ctx context.Context, filter bson.M) ([]models.WebhookSubscription, error) {
+	cursor, err := r.mongo.Collection("webhook_subscriptions").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	subscriptions := []models.WebhookSubscription{}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// DeleteSubscription deletes a subscription of a group and its deliveries
+func (r *MongoWebhookRepository) DeleteSubscription(ctx context.Context, groupID, id primitive.ObjectID) error {
+	result, err := r.mongo.Collection("webhook_subscriptions").DeleteOne(ctx, bson.M{"_id": id, "groupId": groupID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = r.mongo.Collection("webhook_deliveries").DeleteMany(ctx, bson.M{"subscriptionId": id})
+	return err
+}
+
+// EnableSubscription activates a subscription of a group and resets its failures
+func (r *MongoWebhookRepository) EnableSubscription(ctx context.Context, groupID, id primitive.ObjectID) error {
+	result, err := r.mongo.Collection("webhook_subscriptions").UpdateOne(
+		ctx,
+		bson.M{"_id": id, "groupId": groupID},
+		bson.M{
+			"$set":   bson.M{"active": true, "consecutiveFailures": 0},
+			"$unset": bson.M{"disabledAt": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DisableSubscription deactivates a subscription
+func (r *MongoWebhookRepository) DisableSubscription(ctx context.Context, id primitive.ObjectID, disabledAt time.Time) error {
+	_, err := r.mongo.Collection("webhook_subscriptions").UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"active": false, "disabledAt": disabledAt}},
+	)
+	return err
+}
+
+// IncrementFailures counts a failed delivery and returns the updated subscription
+func (r *MongoWebhookRepository) IncrementFailures(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	err := r.mongo.Collection("webhook_subscriptions").FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"consecutiveFailures": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&subscription)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ResetFailures resets a subscription's consecutive failures
+func (r *MongoWebhookRepository) ResetFailures(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.mongo.Collection("webhook_subscriptions").UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"consecutiveFailures": 0}},
+	)
+	return err
+}
+
+// AddDelivery inserts a delivery; the unique index rejects a second delivery of
+// the same event to a subscription
+func (r *MongoWebhookRepository) AddDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	result, err := r.mongo.Collection("webhook_deliveries").InsertOne(ctx, delivery)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListDeliveries retrieves the latest deliveries of a subscription
+func (r *MongoWebhookRepository) ListDeliveries(ctx context.Context, groupID, subscriptionID primitive.ObjectID, limit int64) ([]models.WebhookDelivery, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit)
+	cursor, err := r.mongo.Collection("webhook_deliveries").Find(ctx, bson.M{"groupId": groupID, "subscriptionId": subscriptionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimDueDelivery claims the pending delivery due the longest
+func (r *MongoWebhookRepository) ClaimDueDelivery(ctx context.Context, now, leaseUntil time.Time) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.mongo.Collection("webhook_deliveries").FindOneAndUpdate(
+		ctx,
+		bson.M{"status": models.DeliveryPending, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptAt": leaseUntil}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}),
+	).Decode(&delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// UpdateDelivery saves the outcome of an attempt
+func (r *MongoWebhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	_, err := r.mongo.Collection("webhook_deliveries").UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{
+		"status":         delivery.Status,
+		"attempts":       delivery.Attempts,
+		"nextAttemptAt":  delivery.NextAttemptAt,
+		"lastAttemptAt":  delivery.LastAttemptAt,
+		"responseStatus": delivery.ResponseStatus,
+		"lastError":      delivery.LastError,
+	}})
+	return err
+}
+
+// MongoBudgetRepository stores budgets in the budgets collection, with the
+// thresholds alerted per period in each budget's alerts field
+type MongoBudgetRepository struct {
+	mongo *database.MongoClient
+}
+
+// EnsureIndexes creates the index ListByGroup uses
+func (r *MongoBudgetRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.mongo.Collection("budgets").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "groupId", Value: 1}},
+	})
+	return err
+}
+
+// Create saves a new budget
+func (r *MongoBudgetRepository) Create(ctx context.Context, budget *models.Budget) error {
+	result, err := r.mongo.Collection("budgets").InsertOne(ctx, budget)
+	if err != nil {
+		return err
+	}
+
+	budget.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Delete deletes a budget of a group
+func (r *MongoBudgetRepository) Delete(ctx context.Context, groupID, id primitive.ObjectID) error {
+	result, err := r.mongo.Collection("budgets").DeleteOne(ctx, bson.M{"_id": id, "groupId": groupID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListByGroup retrieves a group's budgets
+func (r *MongoBudgetRepository) ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Budget, error) {
+	cursor, err := r.mongo.Collection("budgets").Find(ctx, bson.M{"groupId": groupID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var budgets []models.Budget
+	if err := cursor.All(ctx, &budgets); err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// MarkAlerted adds threshold to the period's alerts unless it is already there
+func (r *MongoBudgetRepository) MarkAlerted(ctx context.Context, id primitive.ObjectID, period string, threshold int) (bool, error) {
+	field := "alerts." + period
+	result, err := r.mongo.Collection("budgets").UpdateOne(
+		ctx,
+		bson.M{"_id": id, field: bson.M{"$ne": threshold}},
+		bson.M{"$addToSet": bson.M{field: threshold}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// UnmarkAlerted removes threshold from the period's alerts
+func (r *MongoBudgetRepository) UnmarkAlerted(ctx context.Context, id primitive.ObjectID, period string, threshold int) error {
+	_, err := r.mongo.Collection("budgets").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$pull": bson.M{"alerts." + period: threshold}})
+	return err
+}
+
+// MongoStatementRepository stores statement transactions in the
+// statement_transactions collection
+type MongoStatementRepository struct {
+	mongo *database.MongoClient
+}
+
+// EnsureIndexes creates the unique index that deduplicates re-uploaded statements
+func (r *MongoStatementRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.mongo.Collection("statement_transactions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "groupId", Value: 1}, {Key: "member", Value: 1}, {Key: "externalId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Add saves a new transaction, relying on the unique index to skip duplicates
+func (r *MongoStatementRepository) Add(ctx context.Context, transaction *models.StatementTransaction) (bool, error) {
+	result, err := r.mongo.Collection("statement_transactions").InsertOne(ctx, transaction)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	transaction.ID = result.InsertedID.(primitive.ObjectID)
+	return true, nil
+}
+
+// ExistingExternalIDs returns which of externalIDs a member already has in a group
+func (r *MongoStatementRepository) ExistingExternalIDs(ctx context.Context, groupID primitive.ObjectID, member string, externalIDs []string) (map[string]bool, error) {
+	values, err := r.mongo.Collection("statement_transactions").Distinct(
+		ctx,
+		"externalId",
+		bson.M{"groupId": groupID, "member": member, "externalId": bson.M{"$in": externalIDs}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(values))
+	for _, value := range values {
+		if id, ok := value.(string); ok {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+// LinkedExpenseIDs returns the expenses of a group that a transaction is linked to
+func (r *MongoStatementRepository) LinkedExpenseIDs(ctx context.Context, groupID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	values, err := r.mongo.Collection("statement_transactions").Distinct(
+		ctx,
+		"expenseId",
+		bson.M{"groupId": groupID, "expenseId": bson.M{"$exists": true}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []primitive.ObjectID
+	for _, value := range values {
+		if id, ok := value.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Get retrieves a transaction of a group
+func (r *MongoStatementRepository) Get(ctx context.Context, groupID, id primitive.ObjectID) (*models.StatementTransaction, error) {
+	var transaction models.StatementTransaction
+	err := r.mongo.Collection("statement_transactions").FindOne(ctx, bson.M{"_id": id, "groupId": groupID}).Decode(&transaction)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// List returns a group's transactions by date, optionally filtered by status
+func (r *MongoStatementRepository) List(ctx context.Context, groupID primitive.ObjectID, status string) ([]models.StatementTransaction, error) {
+	filter := bson.M{"groupId": groupID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.mongo.Collection("statement_transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []models.StatementTransaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// Accept marks a pending transaction accepted, linked to expenseID
+func (r *MongoStatementRepository) Accept(ctx context.Context, groupID, id, expenseID primitive.ObjectID) error {
+	result, err := r.mongo.Collection("statement_transactions").UpdateOne(
+		ctx,
+		bson.M{"_id": id, "groupId": groupID, "status": models.TransactionPending},
+		bson.M{"$set": bson.M{"status": models.TransactionAccepted, "expenseId": expenseID}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reject marks the pending transactions of a group among ids as rejected
+func (r *MongoStatementRepository) Reject(ctx context.Context, groupID primitive.ObjectID, ids []primitive.ObjectID) error {
+	_, err := r.mongo.Collection("statement_transactions").UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "groupId": groupID, "status": models.TransactionPending},
+		bson.M{"$set": bson.M{"status": models.TransactionRejected}},
+	)
+	return err
+}
+
+var (
+	_ GroupRepository        = (*MongoGroupRepository)(nil)
+	_ ExpenseRepository      = (*MongoExpenseRepository)(nil)
+	_ BalanceRepository      = (*MongoBalanceRepository)(nil)
+	_ OutboxRepository       = (*MongoOutboxRepository)(nil)
+	_ StatementRepository    = (*MongoStatementRepository)(nil)
+	_ NotificationRepository = (*MongoNotificationRepository)(nil)
+	_ WebhookRepository      = (*MongoWebhookRepository)(nil)
+	_ BudgetRepository       = (*MongoBudgetRepository)(nil)
+)
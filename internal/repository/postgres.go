@@ -0,0 +1,946 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"expense-split-wise/internal/database"
+	"expense-split-wise/internal/models"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// migrations are applied in file name order by Migrate
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockKey is the advisory lock that keeps processes from migrating at the same time
+const migrationLockKey = 4_735_019_211
+
+// expenseColumns are the expense columns, in the order scanExpense reads them
+const expenseColumns = "id, group_id, description, amount, paid_by, split_between, category, currency, notes, comments, created_at"
+
+// statementColumns are the statement transaction columns, in the order scanStatementTransaction reads them
+const statementColumns = "id, group_id, member, external_id, date, amount, description, status, expense_id, match_score, created_at"
+
+// Migrate applies the migrations not yet recorded in schema_migrations, each in its
+// own transaction
+func Migrate(ctx context.Context, postgres *database.PostgresClient) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	conn, err := postgres.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	// The API and the worker both migrate on startup
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		version := strings.TrimSuffix(path.Base(name), ".sql")
+
+		var applied bool
+		err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Without arguments the script runs as a simple query, which may hold several statements
+			if _, err := tx.Exec(ctx, string(script)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", version, err)
+		}
+
+		log.Printf("✅ Applied migration %s", version)
+	}
+
+	return nil
+}
+
+// NewPostgresStore returns the repositories backed by PostgreSQL. The schema must be
+// migrated first, see Migrate.
+func NewPostgresStore(postgres *database.PostgresClient) *Store {
+	db := postgresDB{postgres: postgres}
+	return &Store{
+		Transactor: &PostgresTransactor{db: db},
+		Groups:     &PostgresGroupRepository{db: db},
+		Expenses:   &PostgresExpenseRepository{db: db},
+		Balances:   &PostgresBalanceRepository{db: db},
+		Outbox:     &PostgresOutboxRepository{db: db},
+		Statements: &PostgresStatementRepository{db: db},
+	}
+}
+
+// postgresTxKey holds the pgx.Tx of the transaction a context runs in
+type postgresTxKey struct{}
+
+// postgresQuerier runs queries on the pool or in a transaction
+type postgresQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// postgresDB is the database the PostgreSQL repositories share
+type postgresDB struct {
+	postgres *database.PostgresClient
+}
+
+// querier returns the transaction ctx runs in, or the pool
+func (db postgresDB) querier(ctx context.Context) postgresQuerier {
+	if tx, ok := ctx.Value(postgresTxKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return db.postgres.Pool
+}
+
+// inTx runs fn in the transaction ctx runs in, or in a new one
+func (db postgresDB) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	if tx, ok := ctx.Value(postgresTxKey{}).(pgx.Tx); ok {
+		return fn(tx)
+	}
+	return pgx.BeginFunc(ctx, db.postgres.Pool, fn)
+}
+
+// PostgresTransactor runs PostgreSQL transactions
+type PostgresTransactor struct {
+	db postgresDB
+}
+
+// WithTransaction runs fn in a transaction, or in the one ctx already runs in
+func (t *PostgresTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(postgresTxKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+	return pgx.BeginFunc(ctx, t.db.postgres.Pool, func(tx pgx.Tx) error {
+		return fn(context.WithValue(ctx, postgresTxKey{}, tx))
+	})
+}
+
+// PostgresGroupRepository stores groups in the groups table
+type PostgresGroupRepository struct {
+	db postgresDB
+}
+
+// Create saves a new group
+func (r *PostgresGroupRepository) Create(ctx context.Context, group *models.Group) error {
+	if group.ID.IsZero() {
+		group.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.querier(ctx).Exec(ctx,
+		"INSERT INTO groups (id, name, members, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)",
+		group.ID.Hex(), group.Name, textArray(group.Members), group.CreatedAt, group.UpdatedAt,
+	)
+	return err
+}
+
+// Get retrieves a group by ID
+func (r *PostgresGroupRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Group, error) {
+	return scanGroup(r.db.querier(ctx).QueryRow(ctx,
+		"SELECT id, name, members, created_at, updated_at FROM groups WHERE id = $1", id.Hex(),
+	))
+}
+
+// List returns every group, ordered by name
+func (r *PostgresGroupRepository) List(ctx context.Context) ([]models.Group, error) {
+	return r.query(ctx, "SELECT id, name, members, created_at, updated_at FROM groups ORDER BY name, id")
+}
+
+// AddMembers locks the group's row, then appends the members it does not have yet
+func (r *PostgresGroupRepository) AddMembers(ctx context.Context, id primitive.ObjectID, members []string, updatedAt time.Time) (*models.Group, error) {
+	var before *models.Group
+	err := r.db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		before, err = scanGroup(tx.QueryRow(ctx,
+			"SELECT id, name, members, created_at, updated_at FROM groups WHERE id = $1 FOR UPDATE", id.Hex(),
+		))
+		if err != nil {
+			return err
+		}
+
+		updated := slices.Clone(before.Members)
+		for _, member := range members {
+			if !slices.Contains(updated, member) {
+				updated = append(updated, member)
+			}
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE groups SET members = $2, updated_at = $3 WHERE id = $1", id.Hex(), updated, updatedAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// ListByMember returns the groups a member belongs to, oldest first
+func (r *PostgresGroupRepository) ListByMember(ctx context.Context, member string) ([]models.Group, error) {
+	return r.query(ctx,
+		"SELECT id, name, members, created_at, updated_at FROM groups WHERE members @> ARRAY[$1::text] ORDER BY created_at, id",
+		member,
+	)
+}
+
+func (r *PostgresGroupRepository) query(ctx context.Context, sql string, args ...any) ([]models.Group, error) {
+	rows, err := r.db.querier(ctx).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+	return groups, rows.Err()
+}
+
+// PostgresExpenseRepository stores expenses in the expenses table
+type PostgresExpenseRepository struct {
+	db postgresDB
+}
+
+// Create saves a new expense
+func (r *PostgresExpenseRepository) Create(ctx context.Context, expense *models.Expense) error {
+	sql, args := insertExpense(expense)
+	_, err := r.db.querier(ctx).Exec(ctx, sql, args...)
+	return err
+}
+
+// CreateMany saves new expenses in a single transaction
+func (r *PostgresExpenseRepository) CreateMany(ctx context.Context, expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	return r.db.inTx(ctx, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		for i := range expenses {
+			batch.Queue(insertExpense(&expenses[i]))
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// insertExpense returns the statement inserting an expense, setting its ID if it has none
+func insertExpense(expense *models.Expense) (string, []any) {
+	if expense.ID.IsZero() {
+		expense.ID = primitive.NewObjectID()
+	}
+
+	return "INSERT INTO expenses (" + expenseColumns + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", []any{
+		expense.ID.Hex(), expense.GroupID.Hex(), expense.Description, expense.Amount, expense.PaidBy,
+		textArray(expense.SplitBetween), expense.Category, expense.Currency, expense.Notes,
+		textArray(expense.Comments), expense.CreatedAt,
+	}
+}
+
+// Get retrieves an expense by ID
+func (r *PostgresExpenseRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Expense, error) {
+	return scanExpense(r.db.querier(ctx).QueryRow(ctx, "SELECT "+expenseColumns+" FROM expenses WHERE id = $1", id.Hex()))
+}
+
+// Update locks the expense's row to read its previous version, then updates it
+func (r *PostgresExpenseRepository) Update(ctx context.Context, expense *models.Expense) (*models.Expense, error) {
+	var previous *models.Expense
+	err := r.db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		previous, err = scanExpense(tx.QueryRow(ctx,
+			"SELECT "+expenseColumns+" FROM expenses WHERE id = $1 AND group_id = $2 FOR UPDATE",
+			expense.ID.Hex(), expense.GroupID.Hex(),
+		))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE expenses SET description = $2, amount = $3, paid_by = $4, split_between = $5,
+			category = $6, currency = $7, notes = $8 WHERE id = $1`,
+			expense.ID.Hex(), expense.Description, expense.Amount, expense.PaidBy, textArray(expense.SplitBetween),
+			expense.Category, expense.Currency, expense.Notes,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// Delete deletes an expense and returns it
+func (r *PostgresExpenseRepository) Delete(ctx context.Context, groupID, expenseID primitive.ObjectID) (*models.Expense, error) {
+	return scanExpense(r.db.querier(ctx).QueryRow(ctx,
+		"DELETE FROM expenses WHERE id = $1 AND group_id = $2 RETURNING "+expenseColumns,
+		expenseID.Hex(), groupID.Hex(),
+	))
+}
+
+// ListByGroup retrieves all expenses for a group in creation order
+func (r *PostgresExpenseRepository) ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Expense, error) {
+	return r.Find(ctx, ExpenseFilter{GroupID: groupID})
+}
+
+// Find returns the expenses matching filter
+func (r *PostgresExpenseRepository) Find(ctx context.Context, filter ExpenseFilter) ([]models.Expense, error) {
+	where, args := expenseConditions(filter)
+	rows, err := r.db.querier(ctx).Query(ctx,
+		"SELECT "+expenseColumns+" FROM expenses WHERE "+where+" ORDER BY created_at, id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expenses := []models.Expense{}
+	for rows.Next() {
+		expense, err := scanExpense(rows)
+		if err != nil {
+			return nil, err
+		}
+		expenses = append(expenses, *expense)
+	}
+	return expenses, rows.Err()
+}
+
+// expenseConditions returns the WHERE conditions matching the expenses selected by
+// filter, with their arguments
+func expenseConditions(filter ExpenseFilter) (string, []any) {
+	args := []any{filter.GroupID.Hex()}
+	conditions := []string{"group_id = $1"}
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.PaidBy != "" {
+		where("paid_by = $%d", filter.PaidBy)
+	}
+	if filter.Category != "" {
+		where("category = $%d", filter.Category)
+	}
+	if filter.ExcludeCategory != "" {
+		where("category <> $%d", filter.ExcludeCategory)
+	}
+	if filter.From != nil {
+		where("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < $%d", *filter.To)
+	}
+	if len(filter.ExcludeIDs) > 0 {
+		where("id <> ALL($%d)", hexIDs(filter.ExcludeIDs))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// SpendingTotals sums the matching expenses with a GROUP BY query per bucket, months
+// and weekdays taken from the local time in loc
+func (r *PostgresExpenseRepository) SpendingTotals(ctx context.Context, filter ExpenseFilter, loc *time.Location) (*SpendingTotals, error) {
+	where, args := expenseConditions(filter)
+	q := r.db.querier(ctx)
+
+	totals := &SpendingTotals{}
+	err := q.QueryRow(ctx, "SELECT COALESCE(SUM(amount), 0), COUNT(*) FROM expenses WHERE "+where, args...).Scan(&totals.Total, &totals.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	// sumBy sums amount over the matching expenses by key, a text expression. from
+	// adds to the FROM clause, and extra are arguments after the filter's: the
+	// timezone, as tz, for the buckets of local time.
+	tz := fmt.Sprintf("$%d", len(args)+1)
+	sumBy := func(key, amount, from string, extra ...any) ([]models.SpendingTotal, error) {
+		rows, err := q.Query(ctx,
+			"SELECT "+key+" AS key, SUM("+amount+"), COUNT(*) FROM expenses"+from+" WHERE "+where+" GROUP BY key",
+			append(slices.Clip(args), extra...)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		buckets := []models.SpendingTotal{}
+		for rows.Next() {
+			var bucket models.SpendingTotal
+			if err := rows.Scan(&bucket.Key, &bucket.Total, &bucket.Count); err != nil {
+				return nil, err
+			}
+			buckets = append(buckets, bucket)
+		}
+		return buckets, rows.Err()
+	}
+
+	if totals.Paid, err = sumBy("paid_by", "amount", ""); err != nil {
+		return nil, err
+	}
+	// Each member of the split consumes an equal share
+	if totals.Consumed, err = sumBy("member", "amount / cardinality(split_between)", ", unnest(split_between) AS member"); err != nil {
+		return nil, err
+	}
+	if totals.Categories, err = sumBy("category", "amount", ""); err != nil {
+		return nil, err
+	}
+	if totals.Months, err = sumBy("to_char(date_trunc('month', created_at AT TIME ZONE "+tz+"), 'YYYY-MM')", "amount", "", loc.String()); err != nil {
+		return nil, err
+	}
+	weekdays, err := sumBy("extract(dow FROM created_at AT TIME ZONE "+tz+")::int::text", "amount", "", loc.String())
+	if err != nil {
+		return nil, err
+	}
+	for i, day := range weekdays {
+		dow, err := strconv.Atoi(day.Key) // 0 for Sunday
+		if err != nil {
+			return nil, err
+		}
+		weekdays[i].Key = time.Weekday(dow).String()
+	}
+	totals.Weekdays = weekdays
+	return totals, nil
+}
+
+// StreamByGroup reads a group's expenses one row at a time instead of loading the
+// whole group. In a transaction, fn must not use the repositories: the
+// transaction's connection is busy until every row is read.
+func (r *PostgresExpenseRepository) StreamByGroup(ctx context.Context, groupID primitive.ObjectID, fn func(*models.Expense) error) error {
+	rows, err := r.db.querier(ctx).Query(ctx,
+		"SELECT "+expenseColumns+" FROM expenses WHERE group_id = $1 ORDER BY created_at, id", groupID.Hex(),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		expense, err := scanExpense(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(expense); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Search matches the stemmed query terms against the weighted search vector, ranked
+// with ts_rank. As with MongoDB's $text, an expense matches any of the terms and none
+// of the negated ones; scores are on a different scale.
+func (r *PostgresExpenseRepository) Search(ctx context.Context, groupIDs []primitive.ObjectID, query string, limit int64) ([]models.ExpenseSearchResult, error) {
+	results := []models.ExpenseSearchResult{}
+	terms, negated := parseSearchQuery(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	args := []any{hexIDs(groupIDs), limit}
+	tsquery := func(words []string, join string) string {
+		var parts []string
+		for _, word := range words {
+			args = append(args, word)
+			parts = append(parts, fmt.Sprintf("plainto_tsquery('english', $%d)", len(args)))
+		}
+		return strings.Join(parts, join)
+	}
+
+	match := "(" + tsquery(terms, " || ") + ")"
+	if len(negated) > 0 {
+		match += " && !!(" + tsquery(negated, " || ") + ")"
+	}
+
+	// Weights for D, C, B and A, in the proportions of the MongoDB text index
+	rows, err := r.db.querier(ctx).Query(ctx, `
+		SELECT `+expenseColumns+`, ts_rank('{0, 0.1, 0.5, 1}'::float4[], search, query) AS score
+		FROM expenses, (SELECT `+match+`) AS q (query)
+		WHERE group_id = ANY($1) AND search @@ query
+		ORDER BY score DESC, created_at DESC
+		LIMIT NULLIF($2, 0)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var score float32
+		expense, err := scanExpense(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, models.ExpenseSearchResult{Expense: *expense, Score: float64(score)})
+	}
+	return results, rows.Err()
+}
+
+// PostgresBalanceRepository stores balances in the balances table, one row per group
+type PostgresBalanceRepository struct {
+	db postgresDB
+}
+
+// Get retrieves a group's balances
+func (r *PostgresBalanceRepository) Get(ctx context.Context, groupID primitive.ObjectID) (*models.Balance, error) {
+	balance := models.Balance{GroupID: groupID}
+	err := r.db.querier(ctx).QueryRow(ctx,
+		"SELECT balances, applied, event_seq, recalculated_seq, updated_at FROM balances WHERE group_id = $1", groupID.Hex(),
+	).Scan(&balance.Balances, &balance.Applied, &balance.EventSeq, &balance.RecalculatedSeq, &balance.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	balance.UpdatedAt = balance.UpdatedAt.UTC()
+	return &balance, nil
+}
+
+// NextSequence increments the group's event sequence number, creating its row if
+// needed. The row stays locked until the transaction ends, so a recalculation
+// locking it waits for the change to commit.
+func (r *PostgresBalanceRepository) NextSequence(ctx context.Context, groupID primitive.ObjectID) (int64, error) {
+	var seq int64
+	err := r.db.querier(ctx).QueryRow(ctx, `
+		INSERT INTO balances (group_id, event_seq, updated_at) VALUES ($1, 1, $2)
+		ON CONFLICT (group_id) DO UPDATE SET event_seq = balances.event_seq + 1
+		RETURNING event_seq`,
+		groupID.Hex(), time.Now(),
+	).Scan(&seq)
+	return seq, err
+}
+
+// Lock locks the group's row, creating it if needed. Changes to the group's expenses
+// sequence themselves through the row, so those committed once it is locked are
+// sequenced later.
+func (r *PostgresBalanceRepository) Lock(ctx context.Context, groupID primitive.ObjectID) (int64, error) {
+	q := r.db.querier(ctx)
+	_, err := q.Exec(ctx,
+		"INSERT INTO balances (group_id, updated_at) VALUES ($1, $2) ON CONFLICT (group_id) DO NOTHING",
+		groupID.Hex(), time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	err = q.QueryRow(ctx, "SELECT event_seq FROM balances WHERE group_id = $1 FOR UPDATE", groupID.Hex()).Scan(&seq)
+	return seq, err
+}
+
+// Save replaces a group's balances, creating its row if needed
+func (r *PostgresBalanceRepository) Save(ctx context.Context, groupID primitive.ObjectID, balances map[string]float64, seq int64) error {
+	if balances == nil {
+		balances = map[string]float64{}
+	}
+
+	_, err := r.db.querier(ctx).Exec(ctx, `
+		INSERT INTO balances (group_id, balances, recalculated_seq, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (group_id) DO UPDATE
+		SET balances = EXCLUDED.balances, recalculated_seq = EXCLUDED.recalculated_seq, updated_at = EXCLUDED.updated_at`,
+		groupID.Hex(), balances, seq, time.Now(),
+	)
+	return err
+}
+
+// ApplyDelta locks the group's row, creating it if needed, and adds delta unless key
+// is among the last appliedDeltaHistory keys applied or a recalculation counted the
+// event. Any member name can be stored, so it never returns ErrDeltaUnsupported.
+func (r *PostgresBalanceRepository) ApplyDelta(ctx context.Context, groupID primitive.ObjectID, key string, seq int64, delta map[string]float64) (map[string]float64, bool, error) {
+	var balances map[string]float64
+	applied := false
+	err := r.db.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO balances (group_id, updated_at) VALUES ($1, $2) ON CONFLICT (group_id) DO NOTHING",
+			groupID.Hex(), time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+
+		var keys []string
+		var recalculatedSeq int64
+		err = tx.QueryRow(ctx,
+			"SELECT balances, applied, recalculated_seq FROM balances WHERE group_id = $1 FOR UPDATE", groupID.Hex(),
+		).Scan(&balances, &keys, &recalculatedSeq)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(keys, key) || (seq > 0 && seq <= recalculatedSeq) {
+			return nil
+		}
+
+		if balances == nil {
+			balances = make(map[string]float64)
+		}
+		for member, amount := range delta {
+			balances[member] += amount
+		}
+		keys = append(keys, key)
+		if len(keys) > appliedDeltaHistory {
+			keys = keys[len(keys)-appliedDeltaHistory:]
+		}
+
+		_, err = tx.Exec(ctx,
+			"UPDATE balances SET balances = $2, applied = $3, updated_at = $4 WHERE group_id = $1",
+			groupID.Hex(), balances, keys, time.Now(),
+		)
+		applied = err == nil
+		return err
+	})
+	if err != nil || !applied {
+		return nil, false, err
+	}
+	return balances, true, nil
+}
+
+// PostgresOutboxRepository stores outbox entries in the outbox table
+type PostgresOutboxRepository struct {
+	db postgresDB
+}
+
+// Add saves an entry
+func (r *PostgresOutboxRepository) Add(ctx context.Context, entry *models.OutboxEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.querier(ctx).Exec(ctx,
+		"INSERT INTO outbox (id, queue, body, attempts, next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		entry.ID.Hex(), entry.Queue, entry.Body, entry.Attempts, entry.NextAttemptAt, entry.CreatedAt,
+	)
+	return err
+}
+
+// ClaimDue claims the entry due the longest, skipping entries other relays are
+// claiming. When none is due, it deletes the entries sent more than outboxRetention
+// ago, as the MongoDB TTL index does.
+func (r *PostgresOutboxRepository) ClaimDue(ctx context.Context, now, retryAt time.Time) (*models.OutboxEntry, error) {
+	var (
+		entry models.OutboxEntry
+		id    string
+	)
+	err := r.db.querier(ctx).QueryRow(ctx, `
+		UPDATE outbox SET next_attempt_at = $2, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM outbox WHERE sent_at IS NULL AND next_attempt_at <= $1
+			ORDER BY next_attempt_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, queue, body, attempts, next_attempt_at, created_at`,
+		now, retryAt,
+	).Scan(&id, &entry.Queue, &entry.Body, &entry.Attempts, &entry.NextAttemptAt, &entry.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		_, err := r.db.querier(ctx).Exec(ctx, "DELETE FROM outbox WHERE sent_at < $1", now.Add(-outboxRetention))
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	entry.NextAttemptAt = entry.NextAttemptAt.UTC()
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	return &entry, nil
+}
+
+// MarkSent records that an entry was published
+func (r *PostgresOutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID, sentAt time.Time) error {
+	_, err := r.db.querier(ctx).Exec(ctx, "UPDATE outbox SET sent_at = $2 WHERE id = $1", id.Hex(), sentAt)
+	return err
+}
+
+// PostgresStatementRepository stores statement transactions in the
+// statement_transactions table
+type PostgresStatementRepository struct {
+	db postgresDB
+}
+
+// Add saves a new transaction, skipping it if the member already has its external ID
+func (r *PostgresStatementRepository) Add(ctx context.Context, transaction *models.StatementTransaction) (bool, error) {
+	id := transaction.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+
+	var expenseID *string
+	if transaction.ExpenseID != nil {
+		hex := transaction.ExpenseID.Hex()
+		expenseID = &hex
+	}
+
+	tag, err := r.db.querier(ctx).Exec(ctx,
+		"INSERT INTO statement_transactions ("+statementColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (group_id, member, external_id) DO NOTHING`,
+		id.Hex(), transaction.GroupID.Hex(), transaction.Member, transaction.ExternalID, transaction.Date,
+		transaction.Amount, transaction.Description, transaction.Status, expenseID, transaction.MatchScore,
+		transaction.CreatedAt,
+	)
+	if err != nil || tag.RowsAffected() == 0 {
+		return false, err
+	}
+
+	transaction.ID = id
+	return true, nil
+}
+
+// ExistingExternalIDs returns which of externalIDs a member already has in a group
+func (r *PostgresStatementRepository) ExistingExternalIDs(ctx context.Context, groupID primitive.ObjectID, member string, externalIDs []string) (map[string]bool, error) {
+	rows, err := r.db.querier(ctx).Query(ctx,
+		"SELECT external_id FROM statement_transactions WHERE group_id = $1 AND member = $2 AND external_id = ANY($3)",
+		groupID.Hex(), member, textArray(externalIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var externalID string
+		if err := rows.Scan(&externalID); err != nil {
+			return nil, err
+		}
+		existing[externalID] = true
+	}
+	return existing, rows.Err()
+}
+
+// LinkedExpenseIDs returns the expenses of a group that a transaction is linked to
+func (r *PostgresStatementRepository) LinkedExpenseIDs(ctx context.Context, groupID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	rows, err := r.db.querier(ctx).Query(ctx,
+		"SELECT DISTINCT expense_id FROM statement_transactions WHERE group_id = $1 AND expense_id IS NOT NULL",
+		groupID.Hex(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []primitive.ObjectID
+	for rows.Next() {
+		var hex string
+		if err := rows.Scan(&hex); err != nil {
+			return nil, err
+		}
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Get retrieves a transaction of a group
+func (r *PostgresStatementRepository) Get(ctx context.Context, groupID, id primitive.ObjectID) (*models.StatementTransaction, error) {
+	return scanStatementTransaction(r.db.querier(ctx).QueryRow(ctx,
+		"SELECT "+statementColumns+" FROM statement_transactions WHERE id = $1 AND group_id = $2",
+		id.Hex(), groupID.Hex(),
+	))
+}
+
+// List returns a group's transactions by date, optionally filtered by status
+func (r *PostgresStatementRepository) List(ctx context.Context, groupID primitive.ObjectID, status string) ([]models.StatementTransaction, error) {
+	rows, err := r.db.querier(ctx).Query(ctx,
+		"SELECT "+statementColumns+" FROM statement_transactions WHERE group_id = $1 AND ($2 = '' OR status = $2) ORDER BY date, id",
+		groupID.Hex(), status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []models.StatementTransaction{}
+	for rows.Next() {
+		transaction, err := scanStatementTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *transaction)
+	}
+	return transactions, rows.Err()
+}
+
+// Accept marks a pending transaction accepted, linked to expenseID
+func (r *PostgresStatementRepository) Accept(ctx context.Context, groupID, id, expenseID primitive.ObjectID) error {
+	tag, err := r.db.querier(ctx).Exec(ctx,
+		"UPDATE statement_transactions SET status = $4, expense_id = $3 WHERE id = $1 AND group_id = $2 AND status = $5",
+		id.Hex(), groupID.Hex(), expenseID.Hex(), models.TransactionAccepted, models.TransactionPending,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reject marks the pending transactions of a group among ids as rejected
+func (r *PostgresStatementRepository) Reject(ctx context.Context, groupID primitive.ObjectID, ids []primitive.ObjectID) error {
+	_, err := r.db.querier(ctx).Exec(ctx,
+		"UPDATE statement_transactions SET status = $3 WHERE id = ANY($1) AND group_id = $2 AND status = $4",
+		hexIDs(ids), groupID.Hex(), models.TransactionRejected, models.TransactionPending,
+	)
+	return err
+}
+
+var (
+	_ GroupRepository     = (*PostgresGroupRepository)(nil)
+	_ ExpenseRepository   = (*PostgresExpenseRepository)(nil)
+	_ BalanceRepository   = (*PostgresBalanceRepository)(nil)
+	_ OutboxRepository    = (*PostgresOutboxRepository)(nil)
+	_ StatementRepository = (*PostgresStatementRepository)(nil)
+)
+
+// scanGroup reads a group selected with its columns in table order
+func scanGroup(row pgx.Row) (*models.Group, error) {
+	var (
+		group models.Group
+		id    string
+	)
+	if err := row.Scan(&id, &group.Name, &group.Members, &group.CreatedAt, &group.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+
+	var err error
+	if group.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	group.CreatedAt = group.CreatedAt.UTC()
+	group.UpdatedAt = group.UpdatedAt.UTC()
+	return &group, nil
+}
+
+// scanExpense reads an expense selected with expenseColumns, followed by extra columns
+func scanExpense(row pgx.Row, extra ...any) (*models.Expense, error) {
+	var (
+		expense     models.Expense
+		id, groupID string
+	)
+	dest := append([]any{
+		&id, &groupID, &expense.Description, &expense.Amount, &expense.PaidBy, &expense.SplitBetween,
+		&expense.Category, &expense.Currency, &expense.Notes, &expense.Comments, &expense.CreatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, notFound(err)
+	}
+
+	var err error
+	if expense.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if expense.GroupID, err = primitive.ObjectIDFromHex(groupID); err != nil {
+		return nil, err
+	}
+	if len(expense.Comments) == 0 {
+		expense.Comments = nil // As when MongoDB omits them
+	}
+	expense.CreatedAt = expense.CreatedAt.UTC()
+	return &expense, nil
+}
+
+// scanStatementTransaction reads a statement transaction selected with statementColumns
+func scanStatementTransaction(row pgx.Row) (*models.StatementTransaction, error) {
+	var (
+		transaction models.StatementTransaction
+		id, groupID string
+		expenseID   *string
+	)
+	err := row.Scan(
+		&id, &groupID, &transaction.Member, &transaction.ExternalID, &transaction.Date, &transaction.Amount,
+		&transaction.Description, &transaction.Status, &expenseID, &transaction.MatchScore, &transaction.CreatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if transaction.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if transaction.GroupID, err = primitive.ObjectIDFromHex(groupID); err != nil {
+		return nil, err
+	}
+	if expenseID != nil {
+		linked, err := primitive.ObjectIDFromHex(*expenseID)
+		if err != nil {
+			return nil, err
+		}
+		transaction.ExpenseID = &linked
+	}
+	transaction.Date = transaction.Date.UTC()
+	transaction.CreatedAt = transaction.CreatedAt.UTC()
+	return &transaction, nil
+}
+
+// notFound converts pgx.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// textArray returns values, or an empty array for NOT NULL columns when it is nil
+func textArray(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func hexIDs(ids []primitive.ObjectID) []string {
+	hex := make([]string, len(ids))
+	for i, id := range ids {
+		hex[i] = id.Hex()
+	}
+	return hex
+}
@@ -0,0 +1,37 @@
+//go:build postgres
+
+// Run against a scratch database with
+//
+//	POSTGRES_TEST_URL=postgres://localhost/expenses_test go test -tags postgres ./internal/repository
+package repository
+
+import (
+	"context"
+	"expense-split-wise/internal/database"
+	"os"
+	"testing"
+)
+
+// newPostgresStore returns a store on the migrated database at POSTGRES_TEST_URL
+func newPostgresStore(t *testing.T) *Store {
+	t.Helper()
+
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if url == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+	postgres, err := database.NewPostgresClient(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(postgres.Close)
+
+	if err := Migrate(context.Background(), postgres); err != nil {
+		t.Fatal(err)
+	}
+	return NewPostgresStore(postgres)
+}
+
+func TestPostgresSpendingTotals(t *testing.T) {
+	testSpendingTotals(t, newPostgresStore(t))
+}
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/database"
+	"expense-split-wise/internal/models"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Storage backends selectable with Open
+const (
+	BackendMongo    = "mongo"
+	BackendPostgres = "postgres"
+)
+
+// ErrNotFound is returned when a group, expense or balance does not exist. It is
+// mongo.ErrNoDocuments, so callers checking for either keep working.
+var ErrNotFound = mongo.ErrNoDocuments
+
+// ErrDeltaUnsupported is returned by BalanceRepository.ApplyDelta when the store
+// cannot apply a delta in place; the caller should recalculate the balances instead
+var ErrDeltaUnsupported = errors.New("balance delta cannot be applied in place")
+
+// GroupRepository stores groups
+type GroupRepository interface {
+	// Create saves a new group and sets its ID
+	Create(ctx context.Context, group *models.Group) error
+	Get(ctx context.Context, id primitive.ObjectID) (*models.Group, error)
+	// List returns every group, ordered by name
+	List(ctx context.Context) ([]models.Group, error)
+	// AddMembers adds the members not already in a group and returns the group as it
+	// was before
+	AddMembers(ctx context.Context, id primitive.ObjectID, members []string, updatedAt time.Time) (*models.Group, error)
+	// ListByMember returns the groups a member belongs to
+	ListByMember(ctx context.Context, member string) ([]models.Group, error)
+}
+
+// ExpenseRepository stores expenses
+type ExpenseRepository interface {
+	// Create saves a new expense and sets its ID
+	Create(ctx context.Context, expense *models.Expense) error
+	// CreateMany saves new expenses and sets their IDs
+	CreateMany(ctx context.Context, expenses []models.Expense) error
+	Get(ctx context.Context, id primitive.ObjectID) (*models.Expense, error)
+	// Update replaces the editable fields of an expense (description, amount, payer,
+	// split, category, currency and notes) and returns the expense as it was before
+	Update(ctx context.Context, expense *models.Expense) (*models.Expense, error)
+	// Delete deletes an expense of a group and returns it
+	Delete(ctx context.Context, groupID, expenseID primitive.ObjectID) (*models.Expense, error)
+	ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Expense, error)
+	// Find returns the expenses matching filter in creation order
+	Find(ctx context.Context, filter ExpenseFilter) ([]models.Expense, error)
+	// SpendingTotals sums the expenses matching filter, bucketing months and weekdays
+	// in loc, without loading them
+	SpendingTotals(ctx context.Context, filter ExpenseFilter, loc *time.Location) (*SpendingTotals, error)
+	// StreamByGroup calls fn for each expense of a group in creation order, stopping
+	// at the first error
+	StreamByGroup(ctx context.Context, groupID primitive.ObjectID, fn func(*models.Expense) error) error
+	// Search runs a full-text search over the description, notes and comments of the
+	// expenses of groups, most relevant first
+	Search(ctx context.Context, groupIDs []primitive.ObjectID, query string, limit int64) ([]models.ExpenseSearchResult, error)
+}
+
+// ExpenseFilter selects the expenses of a group for ExpenseRepository.Find. Zero
+// fields match any expense.
+type ExpenseFilter struct {
+	GroupID         primitive.ObjectID
+	PaidBy          string
+	Category        string               // Only expenses in this category
+	ExcludeCategory string               // Only expenses not in this category
+	From            *time.Time           // Created at or after
+	To              *time.Time           // Created before
+	ExcludeIDs      []primitive.ObjectID // Expenses to leave out
+}
+
+// SpendingTotals is the spending of the expenses matched by a filter, summed by
+// ExpenseRepository.SpendingTotals. Buckets are in no particular order.
+type SpendingTotals struct {
+	Total      float64
+	Count      int
+	Paid       []models.SpendingTotal // Keyed by payer
+	Consumed   []models.SpendingTotal // Keyed by member, each sharing an expense equally with the others it is split between
+	Categories []models.SpendingTotal // Keyed by category, "" for none
+	Months     []models.SpendingTotal // Keyed by YYYY-MM
+	Weekdays   []models.SpendingTotal // Keyed by weekday name, e.g. "Monday"
+}
+
+// BalanceRepository stores each group's balances
+type BalanceRepository interface {
+	Get(ctx context.Context, groupID primitive.ObjectID) (*models.Balance, error)
+	// NextSequence assigns the next sequence number to an event changing a group's
+	// expenses. It must run in the transaction saving the change, so the change is
+	// visible to recalculations locking the balances after it and only to those.
+	NextSequence(ctx context.Context, groupID primitive.ObjectID) (int64, error)
+	// Lock locks a group's balances, creating them if needed, until the transaction
+	// ctx runs in ends, and returns the sequence number of the group's last event.
+	// Changes to the group's expenses sequenced later are not visible in the transaction.
+	Lock(ctx context.Context, groupID primitive.ObjectID) (int64, error)
+	// Save replaces a group's balances with ones counting every event sequenced up to
+	// seq, so ApplyDelta skips the deltas of those events
+	Save(ctx context.Context, groupID primitive.ObjectID, balances map[string]float64, seq int64) error
+	// ApplyDelta atomically adds delta to a group's balances and records key, unless
+	// key was recently applied or a recalculation counted the event sequenced seq (zero
+	// for events from older publishers, which are only checked by key). It returns the
+	// resulting balances and whether the delta was applied.
+	ApplyDelta(ctx context.Context, groupID primitive.ObjectID, key string, seq int64, delta map[string]float64) (map[string]float64, bool, error)
+}
+
+// OutboxRepository stores queue messages waiting to be published, see services.OutboxService
+type OutboxRepository interface {
+	// Add saves an entry and sets its ID
+	Add(ctx context.Context, entry *models.OutboxEntry) error
+	// ClaimDue claims the unsent entry due the longest, counting an attempt and
+	// postponing it to retryAt so other relays skip it. It returns ErrNotFound when
+	// no entry is due.
+	ClaimDue(ctx context.Context, now, retryAt time.Time) (*models.OutboxEntry, error)
+	MarkSent(ctx context.Context, id primitive.ObjectID, sentAt time.Time) error
+}
+
+// StatementRepository stores the transactions imported from members' bank and card
+// statements, see services.StatementService
+type StatementRepository interface {
+	// Add saves a new transaction and sets its ID. It returns false, saving nothing,
+	// if the member already has a transaction with the same external ID in the group.
+	Add(ctx context.Context, transaction *models.StatementTransaction) (bool, error)
+	// ExistingExternalIDs returns which of externalIDs a member already has in a group
+	ExistingExternalIDs(ctx context.Context, groupID primitive.ObjectID, member string, externalIDs []string) (map[string]bool, error)
+	// LinkedExpenseIDs returns the expenses of a group that a transaction is linked to
+	LinkedExpenseIDs(ctx context.Context, groupID primitive.ObjectID) ([]primitive.ObjectID, error)
+	// Get retrieves a transaction of a group
+	Get(ctx context.Context, groupID, id primitive.ObjectID) (*models.StatementTransaction, error)
+	// List returns a group's transactions by date, only those with status if it is set
+	List(ctx context.Context, groupID primitive.ObjectID, status string) ([]models.StatementTransaction, error)
+	// Accept marks a pending transaction of a group as accepted and links it to the
+	// expense created for it. It returns ErrNotFound if the group has no such pending
+	// transaction.
+	Accept(ctx context.Context, groupID, id, expenseID primitive.ObjectID) error
+	// Reject marks the pending transactions of a group among ids as rejected
+	Reject(ctx context.Context, groupID primitive.ObjectID, ids []primitive.ObjectID) error
+}
+
+// NotificationRepository stores users' notification preferences and the
+// notifications sent to them
+type NotificationRepository interface {
+	GetPreferences(ctx context.Context, user string) (*models.NotificationPreferences, error)
+	// SavePreferences creates or replaces a user's preferences
+	SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error
+	// ListPreferences returns the preferences of those of users who have any, by user
+	ListPreferences(ctx context.Context, users []string) (map[string]models.NotificationPreferences, error)
+	// MarkSent records that a notification event was sent to a recipient. It returns
+	// false if it already was.
+	MarkSent(ctx context.Context, eventID, recipient string, sentAt time.Time) (bool, error)
+	// UnmarkSent forgets that a notification event was sent to a recipient
+	UnmarkSent(ctx context.Context, eventID, recipient string) error
+	// ClaimDigestRun claims the sending of a week's digests, starting it at startedAt.
+	// It returns false if the run was completed, or was started by another claim at or
+	// after staleBefore, which that claim still holds.
+	ClaimDigestRun(ctx context.Context, week string, startedAt, staleBefore time.Time) (bool, error)
+	// CompleteDigestRun records that a week's digests were sent
+	CompleteDigestRun(ctx context.Context, week string, digests int, completedAt time.Time) error
+}
+
+// BudgetRepository stores group budgets and the alerts raised for them, see
+// services.BudgetService
+type BudgetRepository interface {
+	// Create saves a new budget and sets its ID
+	Create(ctx context.Context, budget *models.Budget) error
+	// Delete deletes a budget of a group
+	Delete(ctx context.Context, groupID, id primitive.ObjectID) error
+	ListByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Budget, error)
+	// MarkAlerted records that a budget crossed threshold in a period. It returns
+	// false if it already was.
+	MarkAlerted(ctx context.Context, id primitive.ObjectID, period string, threshold int) (bool, error)
+	// UnmarkAlerted forgets that a budget crossed threshold in a period
+	UnmarkAlerted(ctx context.Context, id primitive.ObjectID, period string, threshold int) error
+}
+
+// WebhookRepository stores webhook subscriptions and their deliveries, see
+// services.WebhookService
+type WebhookRepository interface {
+	// CreateSubscription saves a new subscription and sets its ID
+	CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error
+	GetSubscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context, groupID primitive.ObjectID) ([]models.WebhookSubscription, error)
+	// ListActiveSubscriptions returns a group's active subscriptions to event
+	ListActiveSubscriptions(ctx context.Context, groupID primitive.ObjectID, event string) ([]models.WebhookSubscription, error)
+	// DeleteSubscription deletes a subscription of a group and its deliveries
+	DeleteSubscription(ctx context.Context, groupID, id primitive.ObjectID) error
+	// EnableSubscription activates a subscription of a group and resets its failures
+	EnableSubscription(ctx context.Context, groupID, id primitive.ObjectID) error
+	// DisableSubscription deactivates a subscription
+	DisableSubscription(ctx context.Context, id primitive.ObjectID, disabledAt time.Time) error
+	// IncrementFailures counts a failed delivery to a subscription and returns the
+	// subscription as updated
+	IncrementFailures(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error)
+	ResetFailures(ctx context.Context, id primitive.ObjectID) error
+
+	// AddDelivery saves a new delivery and sets its ID, unless the subscription
+	// already has a delivery of the same event
+	AddDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// ListDeliveries returns the latest deliveries of a subscription of a group, newest first
+	ListDeliveries(ctx context.Context, groupID, subscriptionID primitive.ObjectID, limit int64) ([]models.WebhookDelivery, error)
+	// ClaimDueDelivery claims the pending delivery due the longest, postponing it to
+	// leaseUntil so other workers skip it, and returns it as it was before the
+	// claim. It returns ErrNotFound when no delivery is due.
+	ClaimDueDelivery(ctx context.Context, now, leaseUntil time.Time) (*models.WebhookDelivery, error)
+	// UpdateDelivery saves the outcome of an attempt: the status, attempts, next
+	// attempt, last attempt, response status and last error of a delivery
+	UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+}
+
+// Transactor runs functions in transactions
+type Transactor interface {
+	// WithTransaction runs fn in a transaction: its changes are saved together if it
+	// returns nil and discarded otherwise. Repository calls in fn must use the
+	// context fn is given.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Store bundles the repositories of one backend
+type Store struct {
+	Transactor
+	Groups     GroupRepository
+	Expenses   ExpenseRepository
+	Balances   BalanceRepository
+	Outbox     OutboxRepository
+	Statements StatementRepository
+
+	// Stored in MongoDB on either backend
+	Notifications NotificationRepository
+	Webhooks      WebhookRepository
+	Budgets       BudgetRepository
+
+	close func() // Releases the backend's connections, if the store owns them
+}
+
+// Open returns the repositories of the given backend. The MongoDB store uses
+// mongoClient; the PostgreSQL store connects to postgresURL and applies pending
+// migrations first, and keeps notifications, webhooks and budgets in MongoDB.
+func Open(ctx context.Context, backend string, mongoClient *database.MongoClient, postgresURL string) (*Store, error) {
+	switch backend {
+	case BackendMongo:
+		return NewMongoStore(mongoClient), nil
+	case BackendPostgres:
+		postgres, err := database.NewPostgresClient(postgresURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := Migrate(ctx, postgres); err != nil {
+			postgres.Close()
+			return nil, fmt.Errorf("migrating PostgreSQL: %w", err)
+		}
+
+		store := NewPostgresStore(postgres)
+		store.close = postgres.Close
+
+		// The other repositories stay in MongoDB
+		mongoStore := NewMongoStore(mongoClient)
+		store.Notifications = mongoStore.Notifications
+		store.Webhooks = mongoStore.Webhooks
+		store.Budgets = mongoStore.Budgets
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// Close releases the connections the store opened. Clients passed to the store
+// are left for their owner to close.
+func (s *Store) Close() {
+	if s.close != nil {
+		s.close()
+	}
+}
+
+// EnsureIndexes creates the indexes of the repositories that need them
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	for _, repository := range []interface{}{s.Groups, s.Expenses, s.Balances, s.Outbox, s.Statements, s.Notifications, s.Webhooks, s.Budgets} {
+		if indexed, ok := repository.(interface{ EnsureIndexes(context.Context) error }); ok {
+			if err := indexed.EnsureIndexes(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
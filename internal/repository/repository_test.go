@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"expense-split-wise/internal/models"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testSpendingTotals checks store's SpendingTotals filters expenses and buckets them
+// in the requested timezone
+func testSpendingTotals(t *testing.T, store *Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	groupID := primitive.NewObjectID()
+	at := func(value string) time.Time {
+		created, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+	expenses := []models.Expense{
+		// Wednesday 23:30 in UTC is Thursday morning in Kolkata, and February there
+		{GroupID: groupID, Description: "Dinner", Amount: 90, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol"}, Category: "Food", CreatedAt: at("2024-01-31T23:30:00Z")},
+		{GroupID: groupID, Description: "Taxi", Amount: 20, PaidBy: "bob", CreatedAt: at("2024-02-03T12:00:00Z")},
+		// Left out: a settlement, an expense after the range and another group's
+		{GroupID: groupID, Description: "Paying back", Amount: 40, PaidBy: "carol", SplitBetween: []string{"bob"}, Category: models.CategoryPayment, CreatedAt: at("2024-02-04T12:00:00Z")},
+		{GroupID: groupID, Description: "Lunch", Amount: 10, PaidBy: "alice", SplitBetween: []string{"alice", "bob"}, Category: "Food", CreatedAt: at("2024-03-10T12:00:00Z")},
+		{GroupID: primitive.NewObjectID(), Description: "Elsewhere", Amount: 1000, PaidBy: "alice", CreatedAt: at("2024-02-01T12:00:00Z")},
+	}
+	if err := store.Expenses.CreateMany(ctx, expenses); err != nil {
+		t.Fatal(err)
+	}
+
+	to := at("2024-03-01T00:00:00Z")
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals, err := store.Expenses.SpendingTotals(ctx, ExpenseFilter{GroupID: groupID, ExcludeCategory: models.CategoryPayment, To: &to}, kolkata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if totals.Total != 110 || totals.Count != 2 {
+		t.Errorf("total = %v over %d expenses, want 110 over 2", totals.Total, totals.Count)
+	}
+	buckets := []struct {
+		name string
+		got  []models.SpendingTotal
+		want []models.SpendingTotal
+	}{
+		{"paid", totals.Paid, []models.SpendingTotal{{Key: "alice", Total: 90, Count: 1}, {Key: "bob", Total: 20, Count: 1}}},
+		{"consumed", totals.Consumed, []models.SpendingTotal{{Key: "alice", Total: 30, Count: 1}, {Key: "bob", Total: 30, Count: 1}, {Key: "carol", Total: 30, Count: 1}}},
+		{"categories", totals.Categories, []models.SpendingTotal{{Key: "", Total: 20, Count: 1}, {Key: "Food", Total: 90, Count: 1}}},
+		{"months", totals.Months, []models.SpendingTotal{{Key: "2024-02", Total: 110, Count: 2}}},
+		{"weekdays", totals.Weekdays, []models.SpendingTotal{{Key: "Saturday", Total: 20, Count: 1}, {Key: "Thursday", Total: 90, Count: 1}}},
+	}
+	for _, bucket := range buckets {
+		slices.SortFunc(bucket.got, func(a, b models.SpendingTotal) int { return cmp.Compare(a.Key, b.Key) })
+		if !slices.Equal(bucket.got, bucket.want) {
+			t.Errorf("%s = %v, want %v", bucket.name, bucket.got, bucket.want)
+		}
+	}
+}
+
+func TestMemorySpendingTotals(t *testing.T) {
+	testSpendingTotals(t, NewMemoryStore())
+}
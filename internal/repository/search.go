@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/kljensen/snowball/english"
+)
+
+// parseSearchQuery splits a text search query the way MongoDB's $text does, ignoring
+// phrases: an expense matches any of terms, and none of the negated ("-term") ones
+func parseSearchQuery(query string) (terms, negated []string) {
+	for _, term := range strings.Fields(strings.ToLower(strings.ReplaceAll(query, `"`, " "))) {
+		if strings.HasPrefix(term, "-") {
+			if term = strings.TrimLeft(term, "-"); term != "" {
+				negated = append(negated, term)
+			}
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms, negated
+}
+
+// SearchStem returns the stem a text index keeps for word, using the English Snowball
+// stemmer of MongoDB's text indexes and PostgreSQL's 'english' configuration. Stop
+// words are not indexed and stem to "".
+func SearchStem(word string) string {
+	word = strings.ToLower(word)
+	if english.IsStopWord(word) {
+		return ""
+	}
+	return english.Stem(word, true)
+}
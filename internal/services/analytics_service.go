@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/cache"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// analyticsCacheTTL bounds how long analytics are cached; they are also
+// invalidated whenever the group's balances are recalculated
+const analyticsCacheTTL = 30 * time.Minute
+
+type AnalyticsService struct {
+	expenses repository.ExpenseRepository
+	cache    cache.Cache
+}
+
+func NewAnalyticsService(expenses repository.ExpenseRepository, cache cache.Cache) *AnalyticsService {
+	return &AnalyticsService{
+		expenses: expenses,
+		cache:    cache,
+	}
+}
+
+// analyticsCacheKey holds the generation of a group's cached analytics. Each query's
+// analytics are cached under a key including the generation, so deleting this key
+// invalidates them all; analytics computed before the deletion are cached under the
+// old generation and never read.
+func analyticsCacheKey(groupID primitive.ObjectID) string {
+	return fmt.Sprintf("analytics:%s", groupID.Hex())
+}
+
+// analyticsGeneration returns the generation of a group's cached analytics, starting
+// a new one if there is none
+func (s *AnalyticsService) analyticsGeneration(ctx context.Context, groupID primitive.ObjectID) (string, error) {
+	cacheKey := analyticsCacheKey(groupID)
+	generation, err := s.cache.Get(ctx, cacheKey)
+	if err == nil {
+		return string(generation), nil
+	}
+	if !errors.Is(err, cache.ErrMiss) {
+		return "", err
+	}
+
+	generation = []byte(primitive.NewObjectID().Hex())
+	if err := s.cache.Set(ctx, cacheKey, generation, analyticsCacheTTL); err != nil {
+		return "", err
+	}
+	return string(generation), nil
+}
+
+// GetAnalytics computes spending totals for a group over [from, to) (either may be nil),
+// bucketing months and weekdays in the given timezone
+func (s *AnalyticsService) GetAnalytics(ctx context.Context, groupID primitive.ObjectID, from, to *time.Time, loc *time.Location) (*models.GroupAnalytics, error) {
+	// Try cache first; without a generation the analytics are computed uncached
+	generation, cacheErr := s.analyticsGeneration(ctx, groupID)
+	cacheKey := fmt.Sprintf("%s:%s:%s|%s|%s", analyticsCacheKey(groupID), generation, formatBound(from), formatBound(to), loc.String())
+	if cacheErr == nil {
+		cached, err := s.cache.Get(ctx, cacheKey)
+		if err == nil {
+			var analytics models.GroupAnalytics
+			if json.Unmarshal(cached, &analytics) == nil {
+				return &analytics, nil
+			}
+		}
+	}
+
+	analytics, err := s.aggregate(ctx, groupID, from, to, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update cache
+	if cacheErr == nil {
+		data, _ := json.Marshal(analytics)
+		logCacheError(s.cache.Set(ctx, cacheKey, data, analyticsCacheTTL), "cache analytics", groupID)
+	}
+
+	return analytics, nil
+}
+
+// aggregate totals the group's expenses in the range, other than settlements, by
+// member, category, month and weekday
+func (s *AnalyticsService) aggregate(ctx context.Context, groupID primitive.ObjectID, from, to *time.Time, loc *time.Location) (*models.GroupAnalytics, error) {
+	totals, err := s.expenses.SpendingTotals(ctx, repository.ExpenseFilter{
+		GroupID:         groupID,
+		ExcludeCategory: models.CategoryPayment,
+		From:            from,
+		To:              to,
+	}, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics := &models.GroupAnalytics{
+		GroupID:  groupID,
+		From:     from,
+		To:       to,
+		Timezone: loc.String(),
+		Total:    totals.Total,
+		Count:    totals.Count,
+		Members:  []models.MemberSpending{},
+		Weekdays: []models.SpendingTotal{},
+	}
+
+	members := make(map[string]*models.MemberSpending)
+	member := func(name string) *models.MemberSpending {
+		if members[name] == nil {
+			members[name] = &models.MemberSpending{Member: name}
+		}
+		return members[name]
+	}
+	for _, paid := range totals.Paid {
+		member(paid.Key).Paid += paid.Total
+	}
+	for _, consumed := range totals.Consumed {
+		member(consumed.Key).Consumed += consumed.Total
+	}
+	for _, m := range members {
+		m.Net = m.Paid - m.Consumed
+		analytics.Members = append(analytics.Members, *m)
+	}
+	sort.Slice(analytics.Members, func(i, j int) bool {
+		return analytics.Members[i].Member < analytics.Members[j].Member
+	})
+
+	categories := make(map[string]*models.SpendingTotal)
+	for _, category := range totals.Categories {
+		key := category.Key
+		if key == "" {
+			key = "Uncategorized"
+		}
+		if categories[key] == nil {
+			categories[key] = &models.SpendingTotal{Key: key}
+		}
+		categories[key].Total += category.Total
+		categories[key].Count += category.Count
+	}
+	analytics.Categories = sortedTotals(categories)
+
+	months := make(map[string]*models.SpendingTotal)
+	for _, month := range totals.Months {
+		months[month.Key] = &month
+	}
+	analytics.Months = sortedTotals(months)
+
+	weekdays := make(map[string]models.SpendingTotal)
+	for _, weekday := range totals.Weekdays {
+		weekdays[weekday.Key] = weekday
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if total, ok := weekdays[day.String()]; ok {
+			analytics.Weekdays = append(analytics.Weekdays, total)
+		}
+	}
+
+	return analytics, nil
+}
+
+// sortedTotals returns totals ordered by key
+func sortedTotals(totals map[string]*models.SpendingTotal) []models.SpendingTotal {
+	sorted := []models.SpendingTotal{}
+	for _, total := range totals {
+		sorted = append(sorted, *total)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+func formatBound(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
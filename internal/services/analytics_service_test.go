@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/cache"
+	"expense-split-wise/internal/models"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetAnalytics(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	analyticsService := NewAnalyticsService(s.Store.Expenses, cache.NewLRUCache(100, 0))
+
+	groupID := primitive.NewObjectID()
+	monday := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	expenses := []models.Expense{
+		{GroupID: groupID, Amount: 30, PaidBy: "alice", SplitBetween: []string{"alice", "bob"}, Category: "Food", CreatedAt: monday},
+		{GroupID: groupID, Amount: 10, PaidBy: "bob", SplitBetween: []string{"bob"}, CreatedAt: monday.AddDate(0, 0, -1)},
+		{GroupID: groupID, Amount: 5, PaidBy: "bob", SplitBetween: []string{"alice"}, Category: "Uncategorized", CreatedAt: monday.AddDate(0, 1, 0)},
+		{GroupID: groupID, Amount: 15, PaidBy: "bob", SplitBetween: []string{"alice"}, Category: models.CategoryPayment, CreatedAt: monday},
+	}
+	if err := s.Store.Expenses.CreateMany(ctx, expenses); err != nil {
+		t.Fatal(err)
+	}
+
+	analytics, err := analyticsService.GetAnalytics(ctx, groupID, nil, nil, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if analytics.Total != 45 || analytics.Count != 3 {
+		t.Errorf("total = %v over %d expenses, want 45 over 3, without the settlement", analytics.Total, analytics.Count)
+	}
+	wantMembers := []models.MemberSpending{
+		{Member: "alice", Paid: 30, Consumed: 20, Net: 10},
+		{Member: "bob", Paid: 15, Consumed: 25, Net: -10},
+	}
+	if !slices.Equal(analytics.Members, wantMembers) {
+		t.Errorf("members = %+v, want %+v", analytics.Members, wantMembers)
+	}
+	// Expenses without a category are counted with those in "Uncategorized"
+	wantCategories := []models.SpendingTotal{{Key: "Food", Total: 30, Count: 1}, {Key: "Uncategorized", Total: 15, Count: 2}}
+	if !slices.Equal(analytics.Categories, wantCategories) {
+		t.Errorf("categories = %+v, want %+v", analytics.Categories, wantCategories)
+	}
+	wantMonths := []models.SpendingTotal{{Key: "2023-12", Total: 10, Count: 1}, {Key: "2024-01", Total: 30, Count: 1}, {Key: "2024-02", Total: 5, Count: 1}}
+	if !slices.Equal(analytics.Months, wantMonths) {
+		t.Errorf("months = %+v, want %+v", analytics.Months, wantMonths)
+	}
+	// Weekdays are Sunday first
+	wantWeekdays := []models.SpendingTotal{{Key: "Sunday", Total: 10, Count: 1}, {Key: "Monday", Total: 30, Count: 1}, {Key: "Thursday", Total: 5, Count: 1}}
+	if !slices.Equal(analytics.Weekdays, wantWeekdays) {
+		t.Errorf("weekdays = %+v, want %+v", analytics.Weekdays, wantWeekdays)
+	}
+}
@@ -3,106 +3,241 @@ package services
 import (
 	"context"
 	"encoding/json"
-	"expense-split-wise/internal/database"
+	"errors"
+	"expense-split-wise/internal/cache"
 	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
 	"fmt"
+	"log"
+	"math"
+	"sort"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// balanceCacheTTL bounds how long balances are cached; they are also replaced
+// whenever they change
+const balanceCacheTTL = 30 * time.Minute
+
 type BalanceService struct {
-	mongo *database.MongoClient
-	redis *database.RedisClient
+	transactor   repository.Transactor
+	expenses     repository.ExpenseRepository
+	balances     repository.BalanceRepository
+	cache        cache.Cache
+	eventService *EventService
 }
 
-func NewBalanceService(mongo *database.MongoClient, redis *database.RedisClient) *BalanceService {
+func NewBalanceService(transactor repository.Transactor, expenses repository.ExpenseRepository, balances repository.BalanceRepository, cache cache.Cache, eventService *EventService) *BalanceService {
 	return &BalanceService{
-		mongo: mongo,
-		redis: redis,
+		transactor:   transactor,
+		expenses:     expenses,
+		balances:     balances,
+		cache:        cache,
+		eventService: eventService,
 	}
 }
 
-// RecalculateBalances recalculates balances for a group
-func (s *BalanceService) RecalculateBalances(ctx context.Context, groupID primitive.ObjectID) error {
-	// Fetch all expenses for the group
-	cursor, err := s.mongo.Collection("expenses").Find(ctx, bson.M{"groupId": groupID})
-	if err != nil {
-		return err
-	}
-	defer cursor.Close(ctx)
-
-	var expenses []models.Expense
-	if err := cursor.All(ctx, &expenses); err != nil {
-		return err
-	}
+func balanceCacheKey(groupID primitive.ObjectID) string {
+	return fmt.Sprintf("balance:%s", groupID.Hex())
+}
 
-	// Calculate balances
-	balances := make(map[string]float64)
+// RecalculateBalances recalculates balances for a group from all of its expenses.
+// The worker applies each expense's delta instead (see ApplyDelta); this is the repair
+// path for balances that drifted, and is used for bulk changes such as imports.
+// The balances are locked while the expenses are summed, and the recalculation
+// records the last expense event it counted, so deltas of those events still queued
+// are skipped rather than counted twice, and deltas applied meanwhile are not lost.
+func (s *BalanceService) RecalculateBalances(ctx context.Context, groupID primitive.ObjectID) error {
+	var balances map[string]float64
+	err := s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		seq, err := s.balances.Lock(txCtx, groupID)
+		if err != nil {
+			return err
+		}
 
-	for _, expense := range expenses {
-		// Split amount equally among splitBetween members
-		splitAmount := expense.Amount / float64(len(expense.SplitBetween))
+		// Fetch all expenses for the group
+		expenses, err := s.expenses.ListByGroup(txCtx, groupID)
+		if err != nil {
+			return err
+		}
 
-		// Add to payer's balance (they are owed)
-		balances[expense.PaidBy] += expense.Amount
+		// Calculate balances
+		balances = make(map[string]float64)
 
-		// Deduct from each member's balance (they owe)
-		for _, member := range expense.SplitBetween {
-			balances[member] -= splitAmount
+		for _, expense := range expenses {
+			for member, amount := range ExpenseEffects(&expense) {
+				balances[member] += amount
+			}
 		}
+
+		return s.balances.Save(txCtx, groupID, balances, seq)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Save to MongoDB
-	balance := &models.Balance{
-		GroupID:   groupID,
-		Balances:  balances,
-		UpdatedAt: time.Now(),
+	s.balancesChanged(ctx, groupID, balances)
+	return nil
+}
+
+// ApplyDelta adds an expense's balance change to the group's balances atomically,
+// instead of recalculating them from every expense. The change is recorded under
+// key and skipped if key was recently applied, so a redelivered message is not
+// counted twice, or if a recalculation already counted the event sequenced seq.
+// Deltas the repository cannot apply in place fall back to RecalculateBalances.
+func (s *BalanceService) ApplyDelta(ctx context.Context, groupID primitive.ObjectID, key string, seq int64, delta map[string]float64) error {
+	if len(delta) == 0 {
+		return nil // The expense did not change anyone's balance
 	}
 
-	opts := options.Update().SetUpsert(true)
-	_, err = s.mongo.Collection("balances").UpdateOne(
-		ctx,
-		bson.M{"groupId": groupID},
-		bson.M{"$set": balance},
-		opts,
-	)
-	if err != nil {
+	balances, applied, err := s.balances.ApplyDelta(ctx, groupID, key, seq, delta)
+	if errors.Is(err, repository.ErrDeltaUnsupported) {
+		return s.RecalculateBalances(ctx, groupID)
+	}
+	if err != nil || !applied {
 		return err
 	}
 
-	// Cache in Redis for fast retrieval
-	cacheKey := fmt.Sprintf("balance:%s", groupID.Hex())
+	s.balancesChanged(ctx, groupID, balances)
+	return nil
+}
+
+// balancesChanged refreshes what depends on a group's balances once they are saved
+func (s *BalanceService) balancesChanged(ctx context.Context, groupID primitive.ObjectID, balances map[string]float64) {
+	// Cache for fast retrieval. The balances are already saved, so a failure is only
+	// logged; the cache drops values it failed to update, and reads fall back to the database.
 	data, _ := json.Marshal(balances)
-	s.redis.Client.Set(ctx, cacheKey, data, 30*time.Minute)
+	logCacheError(s.cache.Set(ctx, balanceCacheKey(groupID), data, balanceCacheTTL), "cache balances", groupID)
 
-	return nil
+	// Cached analytics no longer reflect the group's expenses
+	logCacheError(s.cache.Delete(ctx, analyticsCacheKey(groupID)), "invalidate analytics", groupID)
+
+	// Push the new balances to live clients; they are already saved, so only log a failure
+	if err := s.eventService.Publish(ctx, groupID, models.EventBalancesChanged, balances); err != nil {
+		log.Printf("❌ Failed to publish balance event for group %s: %v", groupID.Hex(), err)
+	}
 }
 
 // GetBalances retrieves balances for a group (from cache or DB)
 func (s *BalanceService) GetBalances(ctx context.Context, groupID primitive.ObjectID) (map[string]float64, error) {
 	// Try cache first
-	cacheKey := fmt.Sprintf("balance:%s", groupID.Hex())
-	cached, err := s.redis.Client.Get(ctx, cacheKey).Result()
+	cacheKey := balanceCacheKey(groupID)
+	cached, err := s.cache.Get(ctx, cacheKey)
 	if err == nil {
 		var balances map[string]float64
-		if json.Unmarshal([]byte(cached), &balances) == nil {
+		if json.Unmarshal(cached, &balances) == nil {
 			return balances, nil
 		}
 	}
 
-	// Fallback to database
-	var balance models.Balance
-	err = s.mongo.Collection("balances").FindOne(ctx, bson.M{"groupId": groupID}).Decode(&balance)
+	// Fallback to database, on a miss or while the cache is unavailable
+	balance, err := s.balances.Get(ctx, groupID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Update cache
 	data, _ := json.Marshal(balance.Balances)
-	s.redis.Client.Set(ctx, cacheKey, data, 30*time.Minute)
+	logCacheError(s.cache.Set(ctx, cacheKey, data, balanceCacheTTL), "cache balances", groupID)
 
 	return balance.Balances, nil
 }
+
+// logCacheError logs a failure to update the cache. An unavailable cache is not
+// logged here, as the cache logs when it becomes unavailable.
+func logCacheError(err error, action string, groupID primitive.ObjectID) {
+	if err != nil && !errors.Is(err, cache.ErrUnavailable) {
+		log.Printf("❌ Failed to %s for group %s: %v", action, groupID.Hex(), err)
+	}
+}
+
+// BalanceDelta returns how replacing before with after changes each member's balance.
+// before is nil for a new expense and after is nil for a deleted one.
+func BalanceDelta(before, after *models.Expense) map[string]float64 {
+	delta := make(map[string]float64)
+	if before != nil {
+		for member, amount := range ExpenseEffects(before) {
+			delta[member] -= amount
+		}
+	}
+	if after != nil {
+		for member, amount := range ExpenseEffects(after) {
+			delta[member] += amount
+		}
+	}
+	for member, amount := range delta {
+		if amount == 0 {
+			delete(delta, member)
+		}
+	}
+	return delta
+}
+
+// ExpenseEffects returns how an expense changes each involved member's balance
+func ExpenseEffects(expense *models.Expense) map[string]float64 {
+	participants := expense.Participants()
+	effects := make(map[string]float64, len(participants)+1)
+
+	// Split amount equally among the participants
+	splitAmount := expense.Amount / float64(len(participants))
+
+	// Add to payer's balance (they are owed)
+	effects[expense.PaidBy] += expense.Amount
+
+	// Deduct from each member's balance (they owe)
+	for _, member := range participants {
+		effects[member] -= splitAmount
+	}
+
+	return effects
+}
+
+// SuggestSettlements returns payments that settle all balances, repeatedly paying the
+// largest creditor from the largest debtor so that few payments are needed
+func SuggestSettlements(balances map[string]float64) []models.Settlement {
+	type member struct {
+		name  string
+		cents int64
+	}
+
+	var creditors, debtors []member
+	for name, amount := range balances {
+		cents := int64(math.Round(amount * 100))
+		if cents > 0 {
+			creditors = append(creditors, member{name, cents})
+		} else if cents < 0 {
+			debtors = append(debtors, member{name, -cents})
+		}
+	}
+
+	settlements := []models.Settlement{}
+	for len(creditors) > 0 && len(debtors) > 0 {
+		for _, members := range [][]member{creditors, debtors} {
+			sort.Slice(members, func(i, j int) bool {
+				if members[i].cents != members[j].cents {
+					return members[i].cents > members[j].cents
+				}
+				return members[i].name < members[j].name
+			})
+		}
+
+		cents := min(creditors[0].cents, debtors[0].cents)
+		settlements = append(settlements, models.Settlement{
+			From:   debtors[0].name,
+			To:     creditors[0].name,
+			Amount: float64(cents) / 100,
+		})
+
+		creditors[0].cents -= cents
+		debtors[0].cents -= cents
+		if creditors[0].cents == 0 {
+			creditors = creditors[1:]
+		}
+		if debtors[0].cents == 0 {
+			debtors = debtors[1:]
+		}
+	}
+
+	return settlements
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/testutil"
+	"maps"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// applyMessage applies a queued message's delta as the expense worker does
+func applyMessage(t *testing.T, s *testServices, message models.ExpenseMessage) {
+	t.Helper()
+
+	groupID, err := primitive.ObjectIDFromHex(message.GroupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := message.ExpenseID
+	if message.Event != models.EventExpenseCreated {
+		key = message.Event + ":" + message.EventID
+	}
+	if err := s.balances.ApplyDelta(context.Background(), groupID, key, message.Seq, message.Delta); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBalances(t *testing.T, s *testServices, groupID primitive.ObjectID, want map[string]float64) {
+	t.Helper()
+
+	balance, err := s.Store.Balances.Get(context.Background(), groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := maps.Clone(balance.Balances)
+	maps.DeleteFunc(got, func(_ string, amount float64) bool { return amount == 0 })
+	if !maps.Equal(got, want) {
+		t.Errorf("balances = %v, want %v", got, want)
+	}
+}
+
+func TestRecalculateBalancesSkipsQueuedDeltas(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+	groupID := primitive.NewObjectID()
+
+	dinner := &models.Expense{GroupID: groupID, Description: "Dinner", Amount: 90, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol"}}
+	if err := s.expenses.CreateExpense(ctx, dinner); err != nil {
+		t.Fatal(err)
+	}
+	queued := receiveExpenseMessage(t, deliveries)
+
+	// The recalculation counts the expense while its delta is still queued
+	if err := s.balances.RecalculateBalances(ctx, groupID); err != nil {
+		t.Fatal(err)
+	}
+	applyMessage(t, s, queued)
+	assertBalances(t, s, groupID, map[string]float64{"alice": 60, "bob": -30, "carol": -30})
+
+	// Changes after the recalculation are still applied
+	taxi := &models.Expense{GroupID: groupID, Description: "Taxi", Amount: 20, PaidBy: "bob", SplitBetween: []string{"alice", "bob"}}
+	if err := s.expenses.CreateExpense(ctx, taxi); err != nil {
+		t.Fatal(err)
+	}
+	applyMessage(t, s, receiveExpenseMessage(t, deliveries))
+	assertBalances(t, s, groupID, map[string]float64{"alice": 50, "bob": -20, "carol": -30})
+}
+
+func TestRecalculateBalancesSkipsQueuedDeletion(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+	groupID := primitive.NewObjectID()
+
+	dinner := &models.Expense{GroupID: groupID, Description: "Dinner", Amount: 90, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol"}}
+	if err := s.expenses.CreateExpense(ctx, dinner); err != nil {
+		t.Fatal(err)
+	}
+	applyMessage(t, s, receiveExpenseMessage(t, deliveries))
+
+	if err := s.expenses.DeleteExpense(ctx, groupID, dinner.ID); err != nil {
+		t.Fatal(err)
+	}
+	queued := receiveExpenseMessage(t, deliveries)
+
+	// The recalculation no longer counts the expense, so its deletion must not be applied again
+	if err := s.balances.RecalculateBalances(ctx, groupID); err != nil {
+		t.Fatal(err)
+	}
+	applyMessage(t, s, queued)
+	assertBalances(t, s, groupID, map[string]float64{})
+}
+
+func TestApplyDeltaSkipsRedelivery(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+	groupID := primitive.NewObjectID()
+
+	dinner := &models.Expense{GroupID: groupID, Description: "Dinner", Amount: 90, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol"}}
+	if err := s.expenses.CreateExpense(ctx, dinner); err != nil {
+		t.Fatal(err)
+	}
+	message := receiveExpenseMessage(t, deliveries)
+	applyMessage(t, s, message)
+	applyMessage(t, s, message)
+	assertBalances(t, s, groupID, map[string]float64{"alice": 60, "bob": -30, "carol": -30})
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// budgetThresholds are the percentages of a budget that raise an alert when crossed
+var budgetThresholds = []int{80, 100}
+
+type BudgetService struct {
+	budgets        repository.BudgetRepository
+	expenses       repository.ExpenseRepository
+	webhookService *WebhookService
+}
+
+func NewBudgetService(budgets repository.BudgetRepository, expenses repository.ExpenseRepository, webhookService *WebhookService) *BudgetService {
+	return &BudgetService{
+		budgets:        budgets,
+		expenses:       expenses,
+		webhookService: webhookService,
+	}
+}
+
+// CreateBudget creates a new budget for a group
+func (s *BudgetService) CreateBudget(ctx context.Context, budget *models.Budget) error {
+	budget.CreatedAt = time.Now()
+
+	return s.budgets.Create(ctx, budget)
+}
+
+// DeleteBudget removes a budget from a group
+func (s *BudgetService) DeleteBudget(ctx context.Context, groupID, budgetID primitive.ObjectID) error {
+	return s.budgets.Delete(ctx, groupID, budgetID)
+}
+
+// GetBudgetStatuses returns every budget of a group with its spending in the current period
+func (s *BudgetService) GetBudgetStatuses(ctx context.Context, groupID primitive.ObjectID) ([]models.BudgetStatus, error) {
+	budgets, err := s.budgets.ListByGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []models.BudgetStatus{}
+	for _, budget := range budgets {
+		start, end, _, err := budgetPeriod(&budget, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		spent, err := s.spent(ctx, &budget, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, models.BudgetStatus{
+			Budget:      budget,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Spent:       spent,
+			Remaining:   budget.Amount - spent,
+			Percent:     spent / budget.Amount * 100,
+		})
+	}
+
+	return statuses, nil
+}
+
+// ProcessExpense checks the group's budgets for the period the expense falls in and
+// sends a budget.alert webhook event for each threshold crossed. Each threshold is alerted at most
+// once per budget period, so reprocessing the same expense is safe.
+func (s *BudgetService) ProcessExpense(ctx context.Context, groupID, expenseID primitive.ObjectID) error {
+	expense, err := s.expenses.Get(ctx, expenseID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // Deleted since it was queued
+	}
+	if err != nil {
+		return err
+	}
+	if expense.Category == models.CategoryPayment {
+		return nil // Settlements are not spending
+	}
+
+	budgets, err := s.budgets.ListByGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	for _, budget := range budgets {
+		if budget.Category != "" && budget.Category != expense.Category {
+			continue
+		}
+
+		start, end, key, err := budgetPeriod(&budget, expense.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if (start != nil && expense.CreatedAt.Before(*start)) || (end != nil && !expense.CreatedAt.Before(*end)) {
+			continue // Outside a trip budget
+		}
+
+		spent, err := s.spent(ctx, &budget, start, end)
+		if err != nil {
+			return err
+		}
+
+		for _, threshold := range budgetThresholds {
+			if spent < budget.Amount*float64(threshold)/100 {
+				break
+			}
+			if err := s.alert(ctx, &budget, key, threshold, spent, expenseID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// alert records that a threshold was crossed in a period and dispatches the alert to
+// the group's webhooks, unless it was already recorded
+func (s *BudgetService) alert(ctx context.Context, budget *models.Budget, period string, threshold int, spent float64, expenseID primitive.ObjectID) error {
+	marked, err := s.budgets.MarkAlerted(ctx, budget.ID, period, threshold)
+	if err != nil {
+		return err
+	}
+	if !marked {
+		return nil // Already alerted
+	}
+
+	alert := models.BudgetAlert{
+		GroupID:   budget.GroupID.Hex(),
+		BudgetID:  budget.ID.Hex(),
+		Name:      budget.Name,
+		Category:  budget.Category,
+		Period:    period,
+		Threshold: threshold,
+		Spent:     spent,
+		Amount:    budget.Amount,
+		ExpenseID: expenseID.Hex(),
+		CreatedAt: time.Now(),
+	}
+
+	log.Printf("💸 Budget %q of group %s reached %d%% (%.2f of %.2f)", budget.Name, alert.GroupID, threshold, spent, budget.Amount)
+
+	eventID := fmt.Sprintf("budget:%s:%s:%d", budget.ID.Hex(), period, threshold)
+	if err := s.webhookService.Dispatch(ctx, budget.GroupID, eventID, models.EventBudgetAlert, alert); err != nil {
+		// Forget the alert so a retry dispatches it again
+		s.budgets.UnmarkAlerted(ctx, budget.ID, period, threshold)
+		return err
+	}
+
+	return nil
+}
+
+// spent sums the budget's spending in [start, end); nil bounds are open
+func (s *BudgetService) spent(ctx context.Context, budget *models.Budget, start, end *time.Time) (float64, error) {
+	filter := repository.ExpenseFilter{GroupID: budget.GroupID, From: start, To: end}
+	if budget.Category != "" {
+		filter.Category = budget.Category
+	} else {
+		filter.ExcludeCategory = models.CategoryPayment
+	}
+
+	expenses, err := s.expenses.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0.0
+	for _, expense := range expenses {
+		total += expense.Amount
+	}
+	return total, nil
+}
+
+// budgetPeriod returns the bounds and key of the budget period containing t.
+// Monthly budgets use calendar months in the budget's timezone; trip budgets
+// have a single period bounded by their optional start and end dates.
+func budgetPeriod(budget *models.Budget, t time.Time) (*time.Time, *time.Time, string, error) {
+	if budget.Period == models.BudgetTrip {
+		return budget.StartDate, budget.EndDate, models.BudgetTrip, nil
+	}
+
+	loc, err := time.LoadLocation(budget.Timezone)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	local := t.In(loc)
+	start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 1, 0)
+
+	return &start, &end, start.Format("2006-01"), nil
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DigestSchedule is when the weekly digest is sent
+type DigestSchedule struct {
+	Weekday  time.Weekday
+	Hour     int
+	Minute   int
+	Location *time.Location
+}
+
+// ParseDigestSchedule parses a weekday name (e.g. "monday"), a time of day ("08:00")
+// and a timezone name
+func ParseDigestSchedule(day, clock, timezone string) (*DigestSchedule, error) {
+	schedule := &DigestSchedule{Weekday: -1}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), day) {
+			schedule.Weekday = d
+		}
+	}
+	if schedule.Weekday < 0 {
+		return nil, fmt.Errorf("invalid digest day %q", day)
+	}
+
+	at, err := time.Parse("15:04", clock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest time %q", clock)
+	}
+	schedule.Hour, schedule.Minute = at.Hour(), at.Minute()
+
+	schedule.Location, err = time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// Last returns the most recent scheduled time at or before now
+func (d *DigestSchedule) Last(now time.Time) time.Time {
+	local := now.In(d.Location)
+	last := time.Date(local.Year(), local.Month(), local.Day(), d.Hour, d.Minute, 0, 0, d.Location)
+	last = last.AddDate(0, 0, -int((local.Weekday()-d.Weekday+7)%7))
+	if last.After(now) {
+		last = last.AddDate(0, 0, -7)
+	}
+	return last
+}
+
+// digestRunLease is how long a run holds its claim on a week. A run still unfinished
+// after that is presumed dead, and the next run takes the week over.
+const digestRunLease = 15 * time.Minute
+
+type DigestService struct {
+	notifications       repository.NotificationRepository
+	groups              repository.GroupRepository
+	expenses            repository.ExpenseRepository
+	balanceService      *BalanceService
+	notificationService *NotificationService
+}
+
+func NewDigestService(notifications repository.NotificationRepository, groups repository.GroupRepository, expenses repository.ExpenseRepository, balanceService *BalanceService, notificationService *NotificationService) *DigestService {
+	return &DigestService{
+		notifications:       notifications,
+		groups:              groups,
+		expenses:            expenses,
+		balanceService:      balanceService,
+		notificationService: notificationService,
+	}
+}
+
+// RunDue sends the digest for the week ending at the schedule's most recent time,
+// unless it was already sent. Runs claim their week, so only one worker sends it at a
+// time; a run that dies leaves its claim to expire after digestRunLease. Each user's
+// email is deduplicated by the notification worker, so a run taking over a week from
+// a dead one does not double-send.
+func (s *DigestService) RunDue(ctx context.Context, schedule *DigestSchedule, now time.Time) error {
+	to := schedule.Last(now)
+	from := to.AddDate(0, 0, -7)
+	year, week := to.ISOWeek()
+	weekKey := fmt.Sprintf("%d-W%02d", year, week)
+
+	claimed, err := s.notifications.ClaimDigestRun(ctx, weekKey, now, now.Add(-digestRunLease))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil // Sent, or being sent by another worker
+	}
+
+	digests, err := s.BuildDigests(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range digests {
+		digest.Week = weekKey
+		// A delayed digest is left to the outbox relay
+		if err := s.notificationService.NotifyDigest(ctx, digest); err != nil && !errors.Is(err, ErrEventDelayed) {
+			return err
+		}
+	}
+
+	if err := s.notifications.CompleteDigestRun(ctx, weekKey, len(digests), time.Now()); err != nil {
+		return err
+	}
+
+	log.Printf("📬 Queued %d weekly digests for %s", len(digests), weekKey)
+	return nil
+}
+
+// BuildDigests builds a digest for every member of every group who had new expenses
+// in [from, to) or has an outstanding balance
+func (s *DigestService) BuildDigests(ctx context.Context, from, to time.Time) ([]*models.WeeklyDigest, error) {
+	groups, err := s.groups.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []*models.WeeklyDigest
+	byUser := make(map[string]*models.WeeklyDigest)
+
+	for _, group := range groups {
+		balances, err := s.balanceService.GetBalances(ctx, group.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			balances = map[string]float64{} // No expenses processed yet
+		} else if err != nil {
+			return nil, err
+		}
+		settlements := SuggestSettlements(balances)
+
+		expenses, err := s.newExpenses(ctx, &group, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range group.Members {
+			section := models.DigestGroup{
+				GroupID:     group.ID,
+				Name:        group.Name,
+				NewExpenses: []models.Expense{},
+				Balance:     math.Round(balances[member]*100) / 100,
+				Settlements: []models.Settlement{},
+			}
+			for _, expense := range expenses {
+				if expense.PaidBy == member || slices.Contains(expense.SplitBetween, member) {
+					section.NewExpenses = append(section.NewExpenses, expense)
+				}
+			}
+			for _, settlement := range settlements {
+				if settlement.From == member || settlement.To == member {
+					section.Settlements = append(section.Settlements, settlement)
+				}
+			}
+			if len(section.NewExpenses) == 0 && section.Balance == 0 {
+				continue // Nothing to report for this group
+			}
+
+			digest := byUser[member]
+			if digest == nil {
+				digest = &models.WeeklyDigest{User: member, From: from, To: to}
+				byUser[member] = digest
+				digests = append(digests, digest)
+			}
+			digest.Groups = append(digest.Groups, section)
+		}
+	}
+
+	return digests, nil
+}
+
+// newExpenses returns the group's expenses created in [from, to), oldest first
+func (s *DigestService) newExpenses(ctx context.Context, group *models.Group, from, to time.Time) ([]models.Expense, error) {
+	return s.expenses.Find(ctx, repository.ExpenseFilter{GroupID: group.ID, From: &from, To: &to})
+}
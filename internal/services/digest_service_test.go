@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/testutil"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDigestRunSendsEachWeekOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	digests := NewDigestService(s.Store.Notifications, s.Store.Groups, s.Store.Expenses, s.balances, s.notifications)
+	deliveries := s.Consume(t, testutil.NotificationQueue)
+
+	group, err := s.groups.CreateGroup(ctx, "Trip", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added models.NotificationMessage
+	testutil.Receive(t, deliveries, &added)
+
+	expense := &models.Expense{
+		GroupID:      group.ID,
+		Description:  "Dinner",
+		Amount:       40,
+		PaidBy:       "alice",
+		SplitBetween: []string{"alice", "bob"},
+		CreatedAt:    time.Date(2026, 3, 5, 20, 0, 0, 0, time.UTC),
+	}
+	if err := s.Store.Expenses.Create(ctx, expense); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mondays at 08:00, run an hour later
+	schedule := &DigestSchedule{Weekday: time.Monday, Hour: 8, Location: time.UTC}
+	now := time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC)
+
+	if err := digests.RunDue(ctx, schedule, now); err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for range 2 {
+		var message models.NotificationMessage
+		testutil.Receive(t, deliveries, &message)
+		if message.Kind != models.NotificationWeeklyDigest || message.Digest.Week != "2026-W11" {
+			t.Errorf("notification = %+v, want a weekly digest for 2026-W11", message)
+		}
+		users = append(users, message.Members...)
+	}
+	slices.Sort(users)
+	if !slices.Equal(users, []string{"alice", "bob"}) {
+		t.Errorf("digests went to %v, want alice and bob", users)
+	}
+
+	// The week is complete, however long after
+	if err := digests.RunDue(ctx, schedule, now.Add(digestRunLease+time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+}
+
+func TestDigestRunTakesOverStaleClaims(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	digests := NewDigestService(s.Store.Notifications, s.Store.Groups, s.Store.Expenses, s.balances, s.notifications)
+	deliveries := s.Consume(t, testutil.NotificationQueue)
+
+	group, err := s.groups.CreateGroup(ctx, "Trip", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added models.NotificationMessage
+	testutil.Receive(t, deliveries, &added)
+
+	expense := &models.Expense{
+		GroupID:     group.ID,
+		Description: "Taxi",
+		Amount:      20,
+		PaidBy:      "bob",
+		CreatedAt:   time.Date(2026, 3, 6, 23, 0, 0, 0, time.UTC),
+	}
+	if err := s.Store.Expenses.Create(ctx, expense); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := &DigestSchedule{Weekday: time.Monday, Hour: 8, Location: time.UTC}
+	now := time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC)
+
+	// Another worker is sending the week
+	claimed, err := s.Store.Notifications.ClaimDigestRun(ctx, "2026-W11", now, now.Add(-digestRunLease))
+	if err != nil || !claimed {
+		t.Fatalf("ClaimDigestRun = %v, %v, want the week claimed", claimed, err)
+	}
+	if err := digests.RunDue(ctx, schedule, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+
+	// It died: once its lease runs out, the week is taken over
+	if err := digests.RunDue(ctx, schedule, now.Add(digestRunLease+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	var message models.NotificationMessage
+	testutil.Receive(t, deliveries, &message)
+	if message.Kind != models.NotificationWeeklyDigest || !slices.Equal(message.Members, []string{"bob"}) {
+		t.Errorf("notification = %+v, want bob's weekly digest", message)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/database"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidEventID is returned for a Last-Event-ID that is not a stream ID
+var ErrInvalidEventID = errors.New("invalid event ID")
+
+// groupEventHistory is roughly how many events per group are kept for clients resuming with Last-Event-ID
+const groupEventHistory = 1000
+
+// EventService publishes group events to clients of any API instance. Each event is
+// appended to a per-group Redis stream, whose IDs let clients resume, and announced
+// on a per-group pub/sub channel for live delivery.
+type EventService struct {
+	expenses repository.ExpenseRepository
+	redis    *database.RedisClient
+}
+
+func NewEventService(expenses repository.ExpenseRepository, redis *database.RedisClient) *EventService {
+	return &EventService{
+		expenses: expenses,
+		redis:    redis,
+	}
+}
+
+func groupEventStream(groupID primitive.ObjectID) string {
+	return fmt.Sprintf("events:%s", groupID.Hex())
+}
+
+func groupEventChannel(groupID primitive.ObjectID) string {
+	return fmt.Sprintf("events:%s:live", groupID.Hex())
+}
+
+// Publish records an event for a group and notifies subscribed clients
+func (s *EventService) Publish(ctx context.Context, groupID primitive.ObjectID, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	createdAt := time.Now()
+	id, err := s.redis.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: groupEventStream(groupID),
+		MaxLen: groupEventHistory,
+		Approx: true,
+		Values: map[string]interface{}{"event": event, "data": payload, "createdAt": createdAt.Format(time.RFC3339Nano)},
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	message, _ := json.Marshal(models.GroupEvent{ID: id, Event: event, Data: payload, CreatedAt: createdAt})
+	return s.redis.Client.Publish(ctx, groupEventChannel(groupID), message).Err()
+}
+
+// PublishExpenseEvent publishes the event for an expense message from the queue.
+// Payments are published as settlement.recorded instead of expense.created.
+func (s *EventService) PublishExpenseEvent(ctx context.Context, groupID primitive.ObjectID, message *models.ExpenseMessage) error {
+	event, data, err := expenseEvent(ctx, s.expenses, message)
+	if err != nil || data == nil {
+		return err
+	}
+	return s.Publish(ctx, groupID, event, data)
+}
+
+// Subscribe streams a group's events until ctx is cancelled. When lastEventID is set,
+// the events after it are sent first. If some of them are no longer in the history,
+// a reset event is sent instead, with the ID of the latest event.
+func (s *EventService) Subscribe(ctx context.Context, groupID primitive.ObjectID, lastEventID string) (<-chan models.GroupEvent, error) {
+	if lastEventID != "" {
+		if _, _, err := parseStreamID(lastEventID); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEventID, lastEventID)
+		}
+	}
+
+	// Subscribe before reading the history so no event falls between the two
+	pubsub := s.redis.Client.Subscribe(ctx, groupEventChannel(groupID))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	var history []redis.XMessage
+	if lastEventID != "" {
+		var err error
+		history, err = s.history(ctx, groupID, lastEventID)
+		if err != nil {
+			pubsub.Close()
+			return nil, err
+		}
+	}
+
+	events := make(chan models.GroupEvent)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		send := func(event models.GroupEvent) bool {
+			if lastEventID != "" && compareStreamIDs(event.ID, lastEventID) <= 0 {
+				return true // Already sent from the history
+			}
+			select {
+			case events <- event:
+				lastEventID = event.ID
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, entry := range history {
+			if !send(streamEvent(entry)) {
+				return
+			}
+		}
+
+		live := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-live:
+				if !ok {
+					return
+				}
+				var event models.GroupEvent
+				if json.Unmarshal([]byte(msg.Payload), &event) != nil {
+					continue
+				}
+				if !send(event) {
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// history returns the group's events after lastEventID, or a reset event when events
+// after it were trimmed from the stream
+func (s *EventService) history(ctx context.Context, groupID primitive.ObjectID, lastEventID string) ([]redis.XMessage, error) {
+	stream := groupEventStream(groupID)
+
+	first, err := s.redis.Client.XRangeN(ctx, stream, "-", "+", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(first) == 0 || compareStreamIDs(lastEventID, first[0].ID) >= 0 {
+		return s.redis.Client.XRange(ctx, stream, "("+lastEventID, "+").Result()
+	}
+
+	// The client missed events that are gone; resume it from the latest
+	latest, err := s.redis.Client.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	reset := redis.XMessage{
+		ID:     latest[0].ID,
+		Values: map[string]interface{}{"event": models.EventStreamReset, "data": "{}", "createdAt": time.Now().Format(time.RFC3339Nano)},
+	}
+	return []redis.XMessage{reset}, nil
+}
+
+// streamEvent converts a stream entry written by Publish to an event
+func streamEvent(entry redis.XMessage) models.GroupEvent {
+	event := models.GroupEvent{ID: entry.ID}
+	event.Event, _ = entry.Values["event"].(string)
+	if data, ok := entry.Values["data"].(string); ok {
+		event.Data = json.RawMessage(data)
+	}
+	if createdAt, ok := entry.Values["createdAt"].(string); ok {
+		event.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	}
+	return event
+}
+
+// parseStreamID parses a Redis stream ID, "<ms>-<seq>" or "<ms>"
+func parseStreamID(id string) (ms, seq uint64, err error) {
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	if ms, err = strconv.ParseUint(msPart, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	return ms, seq, nil
+}
+
+// compareStreamIDs orders Redis stream IDs, returning -1, 0 or 1
+func compareStreamIDs(a, b string) int {
+	am, aq, _ := parseStreamID(a)
+	bm, bq, _ := parseStreamID(b)
+	switch {
+	case am != bm:
+		if am < bm {
+			return -1
+		}
+		return 1
+	case aq != bq:
+		if aq < bq {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// expenseEvent returns the event name and payload for an expense message from the
+// queue: the expense itself, or only its identity once deleted. The payload is nil
+// if the expense was deleted after the message was queued; its deletion event follows.
+func expenseEvent(ctx context.Context, expenses repository.ExpenseRepository, message *models.ExpenseMessage) (string, interface{}, error) {
+	event := message.Event
+	if event == "" {
+		event = models.EventExpenseCreated
+	}
+
+	// Balances changed without a single expense to show, as after an import
+	if event == models.EventBalancesChanged {
+		return event, nil, nil
+	}
+
+	if event == models.EventExpenseDeleted {
+		return event, map[string]interface{}{"id": message.ExpenseID, "amount": message.Amount}, nil
+	}
+
+	expenseID, err := primitive.ObjectIDFromHex(message.ExpenseID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	expense, err := expenses.Get(ctx, expenseID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return event, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if event == models.EventExpenseCreated && expense.Category == models.CategoryPayment {
+		event = models.EventSettlementRecorded
+	}
+
+	return event, expense, nil
+}
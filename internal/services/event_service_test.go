@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// receiveEvent returns the next event from events
+func receiveEvent(t *testing.T, events <-chan models.GroupEvent) models.GroupEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return models.GroupEvent{}
+	}
+}
+
+// publishEvents publishes n events to a group and returns their stream IDs
+func publishEvents(t *testing.T, events *EventService, groupID primitive.ObjectID, n int) []string {
+	t.Helper()
+
+	ctx := context.Background()
+	for i := range n {
+		if err := events.Publish(ctx, groupID, models.EventBalancesChanged, map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := events.redis.Client.XRange(ctx, groupEventStream(groupID), "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestSubscribeResumesAfterLastEventID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServices(t)
+	groupID := primitive.NewObjectID()
+	ids := publishEvents(t, s.events, groupID, 3)
+
+	events, err := s.events.Subscribe(ctx, groupID, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range ids[1:] {
+		if event := receiveEvent(t, events); event.ID != want || event.Event != models.EventBalancesChanged {
+			t.Errorf("event = %s %s, want %s %s", event.ID, event.Event, want, models.EventBalancesChanged)
+		}
+	}
+
+	// Live events follow the history
+	if err := s.events.Publish(ctx, groupID, models.EventBalancesChanged, map[string]int{"n": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); compareStreamIDs(event.ID, ids[2]) <= 0 {
+		t.Errorf("live event ID %s, want after %s", event.ID, ids[2])
+	}
+}
+
+func TestSubscribeResetsWhenHistoryIsTrimmed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServices(t)
+	groupID := primitive.NewObjectID()
+	ids := publishEvents(t, s.events, groupID, 4)
+
+	// Only the latest two events are kept
+	if err := s.Redis.Client.XTrimMaxLen(ctx, groupEventStream(groupID), 2).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.events.Subscribe(ctx, groupID, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := receiveEvent(t, events)
+	if event.Event != models.EventStreamReset || event.ID != ids[3] {
+		t.Errorf("event = %s %s, want %s %s", event.ID, event.Event, ids[3], models.EventStreamReset)
+	}
+
+	// The trimmed stream still resumes from an event it keeps
+	events, err = s.events.Subscribe(ctx, groupID, ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.ID != ids[3] || event.Event != models.EventBalancesChanged {
+		t.Errorf("event = %s %s, want %s %s", event.ID, event.Event, ids[3], models.EventBalancesChanged)
+	}
+}
+
+func TestSubscribeRejectsMalformedLastEventID(t *testing.T) {
+	s := newTestServices(t)
+
+	for _, id := range []string{"abc", "1700000000000-", "-1", "1-2-3"} {
+		_, err := s.events.Subscribe(context.Background(), primitive.NewObjectID(), id)
+		if !errors.Is(err, ErrInvalidEventID) {
+			t.Errorf("Subscribe(%q) = %v, want ErrInvalidEventID", id, err)
+		}
+	}
+}
+
+// Stream IDs parse as Redis writes them, with or without a sequence number
+func TestParseStreamID(t *testing.T) {
+	for id, want := range map[string][2]uint64{"1700000000000-3": {1700000000000, 3}, "42": {42, 0}} {
+		ms, seq, err := parseStreamID(id)
+		if err != nil || ms != want[0] || seq != want[1] {
+			t.Errorf("parseStreamID(%q) = %d, %d, %v, want %d, %d", id, ms, seq, err, want[0], want[1])
+		}
+	}
+}
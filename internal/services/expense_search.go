@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"html"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SearchExpenses runs a full-text search over the expenses of a group
+func (s *ExpenseService) SearchExpenses(ctx context.Context, groupID primitive.ObjectID, query string, limit int64) ([]models.ExpenseSearchResult, error) {
+	return s.searchExpenses(ctx, []primitive.ObjectID{groupID}, query, limit)
+}
+
+// SearchUserExpenses runs a full-text search over the expenses of every group the user belongs to
+func (s *ExpenseService) SearchUserExpenses(ctx context.Context, user, query string, limit int64) ([]models.ExpenseSearchResult, error) {
+	groups, err := s.groups.ListByMember(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return []models.ExpenseSearchResult{}, nil
+	}
+
+	groupIDs := make([]primitive.ObjectID, len(groups))
+	for i, group := range groups {
+		groupIDs[i] = group.ID
+	}
+
+	return s.searchExpenses(ctx, groupIDs, query, limit)
+}
+
+// searchExpenses runs the text query over the expenses of groups, ranked by relevance,
+// and highlights the matches
+func (s *ExpenseService) searchExpenses(ctx context.Context, groupIDs []primitive.ObjectID, query string, limit int64) ([]models.ExpenseSearchResult, error) {
+	results, err := s.expenses.Search(ctx, groupIDs, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	highlighter := newHighlighter(query)
+	for i := range results {
+		results[i].Highlights = highlighter.highlight(&results[i].Expense)
+	}
+
+	return results, nil
+}
+
+// highlighter marks query terms in matched expense fields
+type highlighter struct {
+	stems map[string]bool
+}
+
+// searchWord matches the words text is indexed as
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// newHighlighter builds a highlighter for the positive terms of a $text query.
+// Words are compared by their stems, as the text index compares them, so "taxis"
+// marks "taxi" and "taxiing".
+func newHighlighter(query string) *highlighter {
+	stems := make(map[string]bool)
+	for _, term := range strings.Fields(strings.ReplaceAll(query, `"`, " ")) {
+		if strings.HasPrefix(term, "-") {
+			continue // negated term, never present in a match
+		}
+		for _, word := range searchWord.FindAllString(term, -1) {
+			if stem := repository.SearchStem(word); stem != "" {
+				stems[stem] = true
+			}
+		}
+	}
+	return &highlighter{stems: stems}
+}
+
+// highlight returns the searchable fields containing a match, HTML-escaped, with
+// matched words wrapped in <mark>
+func (h *highlighter) highlight(expense *models.Expense) map[string]string {
+	if len(h.stems) == 0 {
+		return nil
+	}
+
+	highlights := make(map[string]string)
+	mark := func(field, text string) {
+		if marked, ok := h.mark(text); ok {
+			highlights[field] = marked
+		}
+	}
+
+	mark("description", expense.Description)
+	mark("notes", expense.Notes)
+	if len(expense.Comments) > 0 {
+		mark("comments", strings.Join(expense.Comments, "\n"))
+	}
+
+	return highlights
+}
+
+// mark escapes text for HTML and wraps the matching words in <mark>, reporting
+// whether any matched. Words are found in the text before escaping, so they never
+// match inside the entities escaping adds.
+func (h *highlighter) mark(text string) (string, bool) {
+	var b strings.Builder
+	last := 0
+	matched := false
+	for _, match := range searchWord.FindAllStringIndex(text, -1) {
+		if !h.stems[repository.SearchStem(text[match[0]:match[1]])] {
+			continue
+		}
+		matched = true
+		b.WriteString(html.EscapeString(text[last:match[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[match[0]:match[1]]))
+		b.WriteString("</mark>")
+		last = match[1]
+	}
+	if !matched {
+		return "", false
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String(), true
+}
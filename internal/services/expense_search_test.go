@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/models"
+	"maps"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHighlightEscapesHTML(t *testing.T) {
+	tests := []struct {
+		query   string
+		expense models.Expense
+		want    map[string]string
+	}{
+		{
+			query:   "taxi",
+			expense: models.Expense{Description: "Taxis home", Notes: "no match"},
+			want:    map[string]string{"description": "<mark>Taxis</mark> home"},
+		},
+		{
+			// Words match by stem, as the text index matches them
+			query:   "taxis",
+			expense: models.Expense{Description: "Taxi and taxiing", Notes: "taxonomy"},
+			want:    map[string]string{"description": "<mark>Taxi</mark> and <mark>taxiing</mark>"},
+		},
+		{
+			// Stop words are not indexed, so they are not marked
+			query:   "the dinners",
+			expense: models.Expense{Description: "The dinner"},
+			want:    map[string]string{"description": "The <mark>dinner</mark>"},
+		},
+		{
+			query:   "dinner",
+			expense: models.Expense{Description: `<img src=x onerror="alert(1)"> dinner`},
+			want:    map[string]string{"description": `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>dinner</mark>`},
+		},
+		{
+			query:   "script",
+			expense: models.Expense{Notes: "a <script>tag</script>"},
+			want:    map[string]string{"notes": "a &lt;<mark>script</mark>&gt;tag&lt;/<mark>script</mark>&gt;"},
+		},
+		{
+			// Terms never match inside the entities escaping adds
+			query:   "amp lt",
+			expense: models.Expense{Description: "Lunch & drinks < 20", Comments: []string{"amp rental"}},
+			want:    map[string]string{"comments": "<mark>amp</mark> rental"},
+		},
+		{
+			query:   "fish&chips",
+			expense: models.Expense{Description: "Fish&Chips"},
+			want:    map[string]string{"description": "<mark>Fish</mark>&amp;<mark>Chips</mark>"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := newHighlighter(tt.query).highlight(&tt.expense)
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("highlight(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchExpensesMatchesStems(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+
+	groupID := primitive.NewObjectID()
+	for _, description := range []string{"Taxi to the airport", "Taxonomy book", "Airport taxis"} {
+		expense := &models.Expense{GroupID: groupID, Description: description, Amount: 10, PaidBy: "alice", SplitBetween: []string{"alice"}}
+		if err := s.expenses.CreateExpense(ctx, expense); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.expenses.SearchExpenses(ctx, groupID, "taxiing -airports", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("found %d expenses, want none with the negated term's stem", len(results))
+	}
+
+	results, err = s.expenses.SearchExpenses(ctx, groupID, "taxiing", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var highlights []string
+	for _, result := range results {
+		highlights = append(highlights, result.Highlights["description"])
+	}
+	slices.Sort(highlights)
+	if want := []string{"<mark>Taxi</mark> to the airport", "Airport <mark>taxis</mark>"}; !slices.Equal(highlights, want) {
+		t.Errorf("highlights = %q, want %q", highlights, want)
+	}
+}
@@ -3,62 +3,137 @@ package services
 import (
 	"context"
 	"expense-split-wise/internal/models"
-	"expense-split-wise/internal/queue"
-	"expense-split-worker/internal/database"
+	"expense-split-wise/internal/repository"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ExpenseService struct {
-	mongo    *database.MongoClient
-	rabbitmq *queue.RabbitMQClient
-	queue    string
+	transactor repository.Transactor
+	expenses   repository.ExpenseRepository
+	groups     repository.GroupRepository
+	balances   repository.BalanceRepository
+	outbox     *OutboxService
+	queue      string
 }
 
-func NewExpenseService(mongo *database.MongoClient, rabbitmq *queue.RabbitMQClient, queueName string) *ExpenseService {
+func NewExpenseService(transactor repository.Transactor, expenses repository.ExpenseRepository, groups repository.GroupRepository, balances repository.BalanceRepository, outbox *OutboxService, queueName string) *ExpenseService {
 	return &ExpenseService{
-		mongo:    mongo,
-		rabbitmq: rabbitmq,
-		queue:    queueName,
+		transactor: transactor,
+		expenses:   expenses,
+		groups:     groups,
+		balances:   balances,
+		outbox:     outbox,
+		queue:      queueName,
 	}
 }
 
-// CreateExpense creates a new expense and publishes to queue
+// CreateExpense creates a new expense and publishes to queue.
+// CreatedAt defaults to now when not set (e.g. by a statement import).
+// An error wrapping ErrEventDelayed means the expense was saved but not yet published.
 func (s *ExpenseService) CreateExpense(ctx context.Context, expense *models.Expense) error {
-	expense.CreatedAt = time.Now()
+	var entry *models.OutboxEntry
+	err := s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		var err error
+		entry, err = s.createExpense(txCtx, expense)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Publish message to RabbitMQ for async processing
+	return s.outbox.Publish(ctx, entry)
+}
 
-	// Save expense to MongoDB
-	result, err := s.mongo.Collection("expenses").InsertOne(ctx, expense)
+// createExpense saves a new expense and its event in the transaction txCtx runs in,
+// returning the outbox entry to publish once the transaction commits
+func (s *ExpenseService) createExpense(txCtx context.Context, expense *models.Expense) (*models.OutboxEntry, error) {
+	if expense.CreatedAt.IsZero() {
+		expense.CreatedAt = time.Now()
+	}
+
+	if err := s.expenses.Create(txCtx, expense); err != nil {
+		return nil, err
+	}
+	return s.addEvent(txCtx, models.EventExpenseCreated, expense, BalanceDelta(nil, expense))
+}
+
+// UpdateExpense replaces the editable fields of an expense and publishes to queue.
+// As with CreateExpense, ErrEventDelayed means the change was saved.
+func (s *ExpenseService) UpdateExpense(ctx context.Context, expense *models.Expense) error {
+	var entry *models.OutboxEntry
+	err := s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		// Read the previous version to work out how the edit changes balances
+		previous, err := s.expenses.Update(txCtx, expense)
+		if err != nil {
+			return err
+		}
+
+		// Fields that cannot be edited keep their stored values
+		expense.Comments = previous.Comments
+		expense.CreatedAt = previous.CreatedAt
+
+		entry, err = s.addEvent(txCtx, models.EventExpenseUpdated, expense, BalanceDelta(previous, expense))
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	expense.ID = result.InsertedID.(primitive.ObjectID)
+	return s.outbox.Publish(ctx, entry)
+}
+
+// DeleteExpense deletes an expense and publishes to queue.
+// As with CreateExpense, ErrEventDelayed means the change was saved.
+func (s *ExpenseService) DeleteExpense(ctx context.Context, groupID, expenseID primitive.ObjectID) error {
+	var entry *models.OutboxEntry
+	err := s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		expense, err := s.expenses.Delete(txCtx, groupID, expenseID)
+		if err != nil {
+			return err
+		}
+
+		entry, err = s.addEvent(txCtx, models.EventExpenseDeleted, expense, BalanceDelta(expense, nil))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.outbox.Publish(ctx, entry)
+}
+
+// addEvent saves an expense event, with the balance change it causes, to the outbox.
+// It must run in the transaction that changes the expense, so the event is recorded
+// if and only if the change is, and is sequenced against balance recalculations.
+func (s *ExpenseService) addEvent(txCtx context.Context, event string, expense *models.Expense, delta map[string]float64) (*models.OutboxEntry, error) {
+	seq, err := s.balances.NextSequence(txCtx, expense.GroupID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Publish message to RabbitMQ for async processing
 	message := models.ExpenseMessage{
+		EventID:   primitive.NewObjectID().Hex(),
+		Event:     event,
 		GroupID:   expense.GroupID.Hex(),
 		ExpenseID: expense.ID.Hex(),
 		Amount:    expense.Amount,
+		Delta:     delta,
+		Seq:       seq,
 	}
 
-	return s.rabbitmq.PublishMessage(s.queue, message)
+	return s.outbox.Add(txCtx, s.queue, message)
 }
 
 // GetExpensesByGroup retrieves all expenses for a group
 func (s *ExpenseService) GetExpensesByGroup(ctx context.Context, groupID primitive.ObjectID) ([]models.Expense, error) {
-	cursor, err := s.mongo.Collection("expenses").Find(ctx, bson.M{"groupId": groupID})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var expenses []models.Expense
-	if err := cursor.All(ctx, &expenses); err != nil {
-		return nil, err
-	}
+	return s.expenses.ListByGroup(ctx, groupID)
+}
 
-	return expenses, nil
+// StreamExpensesByGroup calls fn for each expense of a group in creation order,
+// without loading the whole group at once
+func (s *ExpenseService) StreamExpensesByGroup(ctx context.Context, groupID primitive.ObjectID, fn func(*models.Expense) error) error {
+	return s.expenses.StreamByGroup(ctx, groupID, fn)
 }
@@ -2,23 +2,32 @@ package services
 
 import (
 	"context"
+	"errors"
 	"expense-split-wise/internal/models"
-	"expense-split-worker/internal/database"
+	"expense-split-wise/internal/repository"
+	"slices"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type GroupService struct {
-	mongo *database.MongoClient
+	transactor          repository.Transactor
+	groups              repository.GroupRepository
+	outbox              *OutboxService
+	notificationService *NotificationService
 }
 
-func NewGroupService(mongo *database.MongoClient) *GroupService {
-	return &GroupService{mongo: mongo}
+func NewGroupService(transactor repository.Transactor, groups repository.GroupRepository, outbox *OutboxService, notificationService *NotificationService) *GroupService {
+	return &GroupService{
+		transactor:          transactor,
+		groups:              groups,
+		outbox:              outbox,
+		notificationService: notificationService,
+	}
 }
 
-// CreateGroup creates a new group
+// CreateGroup creates a new group and notifies its members
 func (s *GroupService) CreateGroup(ctx context.Context, name string, members []string) (*models.Group, error) {
 	group := &models.Group{
 		Name:      name,
@@ -27,28 +36,72 @@ func (s *GroupService) CreateGroup(ctx context.Context, name string, members []s
 		UpdatedAt: time.Now(),
 	}
 
-	result, err := s.mongo.Collection("groups").InsertOne(ctx, group)
+	var entry *models.OutboxEntry
+	err := s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		if err := s.groups.Create(txCtx, group); err != nil {
+			return err
+		}
+
+		var err error
+		entry, err = s.notificationService.NotifyMembersAdded(txCtx, group.ID, members)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	group.ID = result.InsertedID.(primitive.ObjectID)
+	s.publishNotification(ctx, entry)
 	return group, nil
 }
 
 // GetGroup retrieves a group by ID
 func (s *GroupService) GetGroup(ctx context.Context, id primitive.ObjectID) (*models.Group, error) {
-	var group models.Group
-	err := s.mongo.Collection("groups").FindOne(ctx, bson.M{"_id": id}).Decode(&group)
-	return &group, err
+	return s.groups.Get(ctx, id)
 }
 
-// AddMembersToGroup adds users to an existing group
+// AddMembersToGroup adds users to an existing group and notifies the ones added
 func (s *GroupService) AddMembersToGroup(ctx context.Context, groupID primitive.ObjectID, members []string) error {
-	update := bson.M{
-		"$addToSet": bson.M{"members": bson.M{"$each": members}},
-		"$set":      bson.M{"updatedAt": time.Now()},
+	var entry *models.OutboxEntry
+	err := s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		var err error
+		entry, err = s.addMembers(txCtx, groupID, members)
+		return err
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // Nothing to add to, as with an update matching no group
+	}
+	if err != nil {
+		return err
+	}
+
+	s.publishNotification(ctx, entry)
+	return nil
+}
+
+// addMembers adds users to a group and saves the member_added notification of the
+// ones actually added to the outbox. Call it in a transaction and publish the
+// returned entry, if any, once it commits.
+func (s *GroupService) addMembers(txCtx context.Context, groupID primitive.ObjectID, members []string) (*models.OutboxEntry, error) {
+	// Read the members before the update to notify only the ones actually added
+	before, err := s.groups.AddMembers(txCtx, groupID, members, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, member := range members {
+		if !slices.Contains(before.Members, member) && !slices.Contains(added, member) {
+			added = append(added, member)
+		}
+	}
+	return s.notificationService.NotifyMembersAdded(txCtx, groupID, added)
+}
+
+// publishNotification publishes a notification saved to the outbox. The group change
+// is already saved and the relay publishes the entry if this fails, so the error
+// (which Publish logs) is not returned.
+func (s *GroupService) publishNotification(ctx context.Context, entry *models.OutboxEntry) {
+	if entry != nil {
+		s.outbox.Publish(ctx, entry)
 	}
-	_, err := s.mongo.Collection("groups").UpdateOne(ctx, bson.M{"_id": groupID}, update)
-	return err
 }
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/importer"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ImportService struct {
+	transactor   repository.Transactor
+	expenses     repository.ExpenseRepository
+	groupService *GroupService
+	outbox       *OutboxService
+	queue        string
+}
+
+func NewImportService(transactor repository.Transactor, expenses repository.ExpenseRepository, groupService *GroupService, outbox *OutboxService, queueName string) *ImportService {
+	return &ImportService{
+		transactor:   transactor,
+		expenses:     expenses,
+		groupService: groupService,
+		outbox:       outbox,
+		queue:        queueName,
+	}
+}
+
+// ImportSplitwise imports a parsed Splitwise export into a group.
+// On a dry run nothing is written and the report shows what would change.
+// Otherwise the missing members are added and the expenses inserted in one batch,
+// in a single transaction.
+//
+// Imported expenses are history, so they are not announced one by one: they send no
+// expense webhooks, live events or emails and do not count towards budget alerts.
+// Instead the import queues a single balances.changed event, on which the worker
+// recalculates the group's balances and notifies webhooks and live clients. An
+// error wrapping ErrEventDelayed means the import was saved but the event not yet
+// published.
+func (s *ImportService) ImportSplitwise(ctx context.Context, groupID primitive.ObjectID, export *importer.SplitwiseExport, dryRun bool) (*models.ImportReport, error) {
+	group, err := s.groupService.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ImportReport{
+		DryRun:     dryRun,
+		NewMembers: []string{},
+		Expenses:   []models.Expense{},
+		Duplicates: []models.Expense{},
+		Skipped:    export.Skipped,
+	}
+	if report.Skipped == nil {
+		report.Skipped = []models.SkippedRow{}
+	}
+
+	existingMembers := make(map[string]bool, len(group.Members))
+	for _, member := range group.Members {
+		existingMembers[member] = true
+	}
+	for _, member := range export.Members {
+		if !existingMembers[member] {
+			report.NewMembers = append(report.NewMembers, member)
+		}
+	}
+
+	// Rows already present (e.g. from an earlier import) are reported, not duplicated
+	existing, err := s.existingExpenseKeys(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, expense := range export.Expenses {
+		expense.GroupID = groupID
+		if existing[importKey(&expense)] {
+			report.Duplicates = append(report.Duplicates, expense)
+			continue
+		}
+		report.Expenses = append(report.Expenses, expense)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	var entries []*models.OutboxEntry
+	err = s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		entries = nil
+		if len(report.NewMembers) > 0 {
+			entry, err := s.groupService.addMembers(txCtx, groupID, report.NewMembers)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+
+		if len(report.Expenses) == 0 {
+			return nil
+		}
+		if err := s.expenses.CreateMany(txCtx, report.Expenses); err != nil {
+			return err
+		}
+
+		// A message without a delta makes the worker recalculate the balances
+		entry, err := s.outbox.Add(txCtx, s.queue, models.ExpenseMessage{
+			EventID: primitive.NewObjectID().Hex(),
+			Event:   models.EventBalancesChanged,
+			GroupID: groupID.Hex(),
+		})
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var delayed error
+	for _, entry := range entries {
+		if err := s.outbox.Publish(ctx, entry); err != nil {
+			delayed = err
+		}
+	}
+	return report, delayed
+}
+
+// existingExpenseKeys returns the import keys of every expense already in the group
+func (s *ImportService) existingExpenseKeys(ctx context.Context, groupID primitive.ObjectID) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	err := s.expenses.StreamByGroup(ctx, groupID, func(expense *models.Expense) error {
+		keys[importKey(expense)] = true
+		return nil
+	})
+	return keys, err
+}
+
+// importKey identifies an expense by day, description, amount and payer
+func importKey(expense *models.Expense) string {
+	return fmt.Sprintf("%s|%s|%.2f|%s",
+		expense.CreatedAt.UTC().Format("2006-01-02"),
+		expense.Description,
+		expense.Amount,
+		expense.PaidBy,
+	)
+}
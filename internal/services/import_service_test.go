@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/importer"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/testutil"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const splitwiseExport = `Date,Description,Category,Cost,Currency,alice,bob,carol
+
+2024-01-05,Groceries,Groceries,90.00,EUR,60.00,-30.00,-30.00
+2024-01-06,Taxi,Taxi,20.00,EUR,-10.00,10.00,0.00
+
+2024-01-31,Total balance, , ,EUR,50.00,-20.00,-30.00
+`
+
+// parseSplitwise parses splitwiseExport
+func parseSplitwise(t *testing.T) *importer.SplitwiseExport {
+	t.Helper()
+
+	export, err := importer.ParseSplitwiseCSV(strings.NewReader(splitwiseExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return export
+}
+
+func TestImportSplitwiseQueuesOneBalancesEvent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	imports := NewImportService(s.Store.Transactor, s.Store.Expenses, s.groups, s.outbox, testutil.ExpenseQueue)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+
+	group, err := s.groups.CreateGroup(ctx, "Trip", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := imports.ImportSplitwise(ctx, group.ID, parseSplitwise(t), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Expenses) != 2 || !slices.Equal(report.NewMembers, []string{"carol"}) {
+		t.Fatalf("report = %+v, want 2 expenses and carol added", report)
+	}
+
+	group, err = s.groups.GetGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(group.Members, "carol") {
+		t.Errorf("members = %v, want carol added", group.Members)
+	}
+	expenses, err := s.Store.Expenses.ListByGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expenses) != 2 {
+		t.Errorf("saved %d expenses, want 2", len(expenses))
+	}
+
+	// One message for the whole import, not one per expense
+	message := receiveExpenseMessage(t, deliveries)
+	if message.Event != models.EventBalancesChanged || message.GroupID != group.ID.Hex() || message.ExpenseID != "" || message.Delta != nil {
+		t.Errorf("message = %+v, want balances.changed for the group without an expense or delta", message)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+
+	// Importing again only reports the duplicates
+	report, err = imports.ImportSplitwise(ctx, group.ID, parseSplitwise(t), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Expenses) != 0 || len(report.Duplicates) != 2 {
+		t.Errorf("report = %+v, want both expenses reported as duplicates", report)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+}
+
+func TestImportSplitwiseSurvivesBrokerOutage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	outbox := NewOutboxService(s.Store.Outbox, downPublisher{})
+	notifications := NewNotificationService(s.Store.Notifications, s.Store.Groups, s.Store.Expenses, outbox, downPublisher{}, testutil.NotificationQueue)
+	groups := NewGroupService(s.Store.Transactor, s.Store.Groups, outbox, notifications)
+	imports := NewImportService(s.Store.Transactor, s.Store.Expenses, groups, outbox, testutil.ExpenseQueue)
+
+	group, err := s.groups.CreateGroup(ctx, "Trip", []string{"alice", "bob", "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := imports.ImportSplitwise(ctx, group.ID, parseSplitwise(t), false); !errors.Is(err, ErrEventDelayed) {
+		t.Fatalf("ImportSplitwise = %v, want ErrEventDelayed", err)
+	}
+
+	// The import is saved and its event waits in the outbox
+	expenses, err := s.Store.Expenses.ListByGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expenses) != 2 {
+		t.Errorf("saved %d expenses, want 2", len(expenses))
+	}
+	later := time.Now().Add(time.Hour)
+	entry, err := s.Store.Outbox.ClaimDue(ctx, later, later.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("no outbox entry for the import: %v", err)
+	}
+	var message models.ExpenseMessage
+	if err := json.Unmarshal(entry.Body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Queue != testutil.ExpenseQueue || message.Event != models.EventBalancesChanged {
+		t.Errorf("outbox entry for %s = %+v, want balances.changed", entry.Queue, message)
+	}
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/notify"
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationKinds are the notifications a user can opt out of
+var NotificationKinds = []string{
+	models.NotificationMemberAdded,
+	models.NotificationExpenseAdded,
+	models.NotificationPaymentReceived,
+	models.NotificationWeeklyDigest,
+}
+
+type NotificationService struct {
+	notifications repository.NotificationRepository
+	groups        repository.GroupRepository
+	expenses      repository.ExpenseRepository
+	outbox        *OutboxService
+	publisher     queue.Publisher
+	queue         string
+}
+
+func NewNotificationService(notifications repository.NotificationRepository, groups repository.GroupRepository, expenses repository.ExpenseRepository, outbox *OutboxService, publisher queue.Publisher, queueName string) *NotificationService {
+	return &NotificationService{
+		notifications: notifications,
+		groups:        groups,
+		expenses:      expenses,
+		outbox:        outbox,
+		publisher:     publisher,
+		queue:         queueName,
+	}
+}
+
+// GetPreferences returns a user's notification preferences
+func (s *NotificationService) GetPreferences(ctx context.Context, user string) (*models.NotificationPreferences, error) {
+	return s.notifications.GetPreferences(ctx, user)
+}
+
+// UpdatePreferences creates or replaces a user's notification preferences
+func (s *NotificationService) UpdatePreferences(ctx context.Context, preferences *models.NotificationPreferences) error {
+	if preferences.OptOut == nil {
+		preferences.OptOut = []string{}
+	}
+	preferences.UpdatedAt = time.Now()
+
+	return s.notifications.SavePreferences(ctx, preferences)
+}
+
+// NotifyMembersAdded saves a member_added notification for members newly added to a
+// group to the outbox, returning nil when there is no one to notify. Call it in the
+// transaction that adds them, and publish the entry once it commits.
+func (s *NotificationService) NotifyMembersAdded(txCtx context.Context, groupID primitive.ObjectID, members []string) (*models.OutboxEntry, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	return s.outbox.Add(txCtx, s.queue, models.NotificationMessage{
+		EventID: primitive.NewObjectID().Hex(),
+		Kind:    models.NotificationMemberAdded,
+		GroupID: groupID.Hex(),
+		Members: members,
+	})
+}
+
+// NotifyExpense queues a notification for a newly created expense: payment_received
+// for settlements, expense_added otherwise. Other expense events are not notified.
+func (s *NotificationService) NotifyExpense(ctx context.Context, expenseMsg *models.ExpenseMessage) error {
+	if expenseMsg.Event != "" && expenseMsg.Event != models.EventExpenseCreated {
+		return nil
+	}
+
+	expenseID, err := primitive.ObjectIDFromHex(expenseMsg.ExpenseID)
+	if err != nil {
+		return err
+	}
+
+	expense, err := s.expenses.Get(ctx, expenseID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // Deleted since it was queued
+	}
+	if err != nil {
+		return err
+	}
+
+	kind := models.NotificationExpenseAdded
+	if expense.Category == models.CategoryPayment {
+		kind = models.NotificationPaymentReceived
+	}
+
+	eventID := expenseMsg.EventID
+	if eventID == "" {
+		eventID = expenseMsg.ExpenseID
+	}
+
+	return s.publisher.PublishMessage(s.queue, models.NotificationMessage{
+		EventID:   eventID,
+		Kind:      kind,
+		GroupID:   expenseMsg.GroupID,
+		ExpenseID: expenseMsg.ExpenseID,
+	})
+}
+
+// NotifyDigest queues a user's weekly digest through the outbox. Its event ID is per
+// user and week, so queueing the same week again does not email the user twice. An
+// error wrapping ErrEventDelayed means the digest is saved for the outbox relay.
+func (s *NotificationService) NotifyDigest(ctx context.Context, digest *models.WeeklyDigest) error {
+	entry, err := s.outbox.Add(ctx, s.queue, models.NotificationMessage{
+		EventID: fmt.Sprintf("digest:%s:%s", digest.Week, digest.User),
+		Kind:    models.NotificationWeeklyDigest,
+		Members: []string{digest.User},
+		Digest:  digest,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.outbox.Publish(ctx, entry)
+}
+
+// Notification is one email to send for a NotificationMessage
+type Notification struct {
+	Recipient string
+	Email     string
+	Data      notify.EmailData
+}
+
+// Prepare resolves the recipients of a notification message, skipping members
+// without an email address and members who opted out of its kind
+func (s *NotificationService) Prepare(ctx context.Context, msg *models.NotificationMessage, currency string) ([]Notification, error) {
+	if msg.Kind == models.NotificationWeeklyDigest {
+		return s.resolve(ctx, msg.Kind, msg.Members, func(recipient string) notify.EmailData {
+			return notify.EmailData{Recipient: recipient, Digest: msg.Digest, Currency: currency}
+		})
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(msg.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := s.groups.Get(ctx, groupID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var expense *models.Expense
+	recipients := msg.Members
+	if msg.Kind != models.NotificationMemberAdded {
+		expenseID, err := primitive.ObjectIDFromHex(msg.ExpenseID)
+		if err != nil {
+			return nil, err
+		}
+
+		expense, err = s.expenses.Get(ctx, expenseID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil // Deleted since it was queued
+		}
+		if err != nil {
+			return nil, err
+		}
+		if expense.Currency != "" {
+			currency = expense.Currency
+		}
+
+		// Everyone involved except the payer
+		recipients = nil
+		for _, member := range expense.SplitBetween {
+			if member != expense.PaidBy {
+				recipients = append(recipients, member)
+			}
+		}
+	}
+
+	return s.resolve(ctx, msg.Kind, recipients, func(recipient string) notify.EmailData {
+		data := notify.EmailData{
+			Recipient: recipient,
+			Group:     group,
+			Expense:   expense,
+			Currency:  currency,
+		}
+		if expense != nil && len(expense.SplitBetween) > 0 {
+			data.Share = expense.Amount / float64(len(expense.SplitBetween))
+		}
+		return data
+	})
+}
+
+// resolve builds a notification for each recipient with an email address who has
+// not opted out of kind
+func (s *NotificationService) resolve(ctx context.Context, kind string, recipients []string, data func(recipient string) notify.EmailData) ([]Notification, error) {
+	preferences, err := s.notifications.ListPreferences(ctx, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	var notifications []Notification
+	for _, recipient := range recipients {
+		pref, ok := preferences[recipient]
+		if !ok || pref.Email == "" || slices.Contains(pref.OptOut, kind) {
+			continue
+		}
+
+		notifications = append(notifications, Notification{Recipient: recipient, Email: pref.Email, Data: data(recipient)})
+	}
+
+	return notifications, nil
+}
+
+// MarkSent records that a notification event was emailed to a recipient. It returns
+// false if it already was, so redelivered messages do not email anyone twice.
+func (s *NotificationService) MarkSent(ctx context.Context, eventID, recipient string) (bool, error) {
+	return s.notifications.MarkSent(ctx, eventID, recipient, time.Now())
+}
+
+// UnmarkSent forgets a notification that failed to send so a retry sends it
+func (s *NotificationService) UnmarkSent(ctx context.Context, eventID, recipient string) error {
+	return s.notifications.UnmarkSent(ctx, eventID, recipient)
+}
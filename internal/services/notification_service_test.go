@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/testutil"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recipients returns who notifications go to
+func recipients(notifications []Notification) []string {
+	var users []string
+	for _, notification := range notifications {
+		users = append(users, notification.Recipient)
+	}
+	return users
+}
+
+func TestAddMembersNotifiesOnlyNewMembers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.NotificationQueue)
+
+	group, err := s.groups.CreateGroup(ctx, "Trip", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created models.NotificationMessage
+	testutil.Receive(t, deliveries, &created)
+	if created.Kind != models.NotificationMemberAdded || !slices.Equal(created.Members, []string{"alice", "bob"}) {
+		t.Errorf("notification = %+v, want member_added for alice and bob", created)
+	}
+
+	if err := s.groups.AddMembersToGroup(ctx, group.ID, []string{"bob", "carol", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	var added models.NotificationMessage
+	testutil.Receive(t, deliveries, &added)
+	if !slices.Equal(added.Members, []string{"carol"}) {
+		t.Errorf("members = %v, want [carol]", added.Members)
+	}
+
+	// Nobody new, nothing to notify
+	if err := s.groups.AddMembersToGroup(ctx, group.ID, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+}
+
+// downPublisher fails every publish, as a broker that is down does
+type downPublisher struct{}
+
+func (downPublisher) PublishMessage(queueName string, message interface{}) error {
+	return errors.New("broker down")
+}
+
+func TestAddMembersNotificationSurvivesBrokerOutage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	outbox := NewOutboxService(s.Store.Outbox, downPublisher{})
+	notifications := NewNotificationService(s.Store.Notifications, s.Store.Groups, s.Store.Expenses, outbox, downPublisher{}, testutil.NotificationQueue)
+	groups := NewGroupService(s.Store.Transactor, s.Store.Groups, outbox, notifications)
+
+	group, err := groups.CreateGroup(ctx, "Trip", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("CreateGroup = %v, want the group saved while the broker is down", err)
+	}
+
+	// The notification waits in the outbox for the relay
+	later := time.Now().Add(time.Hour)
+	entry, err := s.Store.Outbox.ClaimDue(ctx, later, later.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("no outbox entry for the notification: %v", err)
+	}
+	var message models.NotificationMessage
+	if err := json.Unmarshal(entry.Body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Queue != testutil.NotificationQueue || message.Kind != models.NotificationMemberAdded || message.GroupID != group.ID.Hex() {
+		t.Errorf("outbox entry for %s = %+v, want member_added for the group", entry.Queue, message)
+	}
+}
+
+func TestPrepareSkipsOptedOutMembers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+
+	group, err := s.groups.CreateGroup(ctx, "Flat", []string{"alice", "bob", "carol", "dave"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, preferences := range []*models.NotificationPreferences{
+		{User: "alice", Email: "alice@example.com"},
+		{User: "bob", Email: "bob@example.com"},
+		{User: "carol", Email: "carol@example.com", OptOut: []string{models.NotificationExpenseAdded}},
+		// dave has no email address
+	} {
+		if err := s.notifications.UpdatePreferences(ctx, preferences); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rent := &models.Expense{GroupID: group.ID, Description: "Rent", Amount: 1200, PaidBy: "alice", SplitBetween: []string{"alice", "bob", "carol", "dave"}}
+	if err := s.expenses.CreateExpense(ctx, rent); err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := s.notifications.Prepare(ctx, &models.NotificationMessage{
+		EventID:   rent.ID.Hex(),
+		Kind:      models.NotificationExpenseAdded,
+		GroupID:   group.ID.Hex(),
+		ExpenseID: rent.ID.Hex(),
+	}, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recipients(notifications); !slices.Equal(got, []string{"bob"}) {
+		t.Fatalf("recipients = %v, want [bob]", got)
+	}
+	if notifications[0].Email != "bob@example.com" || notifications[0].Data.Share != 300 {
+		t.Errorf("notification = %+v, want bob@example.com with a share of 300", notifications[0])
+	}
+
+	// Opted out of expenses only
+	notifications, err = s.notifications.Prepare(ctx, &models.NotificationMessage{
+		EventID: "added",
+		Kind:    models.NotificationMemberAdded,
+		GroupID: group.ID.Hex(),
+		Members: []string{"carol", "dave"},
+	}, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recipients(notifications); !slices.Equal(got, []string{"carol"}) {
+		t.Errorf("recipients = %v, want [carol]", got)
+	}
+}
+
+func TestMarkSentOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+
+	for i, want := range []bool{true, false} {
+		sent, err := s.notifications.MarkSent(ctx, "event", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != want {
+			t.Errorf("MarkSent #%d = %v, want %v", i+1, sent, want)
+		}
+	}
+
+	// A failed send is forgotten so the retry sends it
+	if err := s.notifications.UnmarkSent(ctx, "event", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := s.notifications.MarkSent(ctx, "event", "alice"); err != nil || !sent {
+		t.Errorf("MarkSent after UnmarkSent = %v, %v, want true", sent, err)
+	}
+}
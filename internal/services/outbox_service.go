@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// outboxGrace gives the request that wrote an entry time to publish it itself
+	// before the relay does
+	outboxGrace = 10 * time.Second
+	// outboxRetryDelay is how long the relay waits before retrying a failed publish
+	outboxRetryDelay = 30 * time.Second
+)
+
+// ErrEventDelayed is returned when a change was saved but the broker did not confirm
+// the message announcing it. The relay publishes the message later, so processing
+// that depends on it, such as balance updates, is delayed rather than lost.
+var ErrEventDelayed = errors.New("change saved, but its event is delayed")
+
+// OutboxService delivers queue messages with at-least-once guarantees: a message is
+// saved to the outbox in the same transaction as the change it announces, then
+// published and marked sent. Entries whose publish failed (or never ran, because the
+// process died) are published by Relay. Consumers must tolerate duplicates.
+type OutboxService struct {
+	outbox    repository.OutboxRepository
+	publisher queue.Publisher
+}
+
+func NewOutboxService(outbox repository.OutboxRepository, publisher queue.Publisher) *OutboxService {
+	return &OutboxService{
+		outbox:    outbox,
+		publisher: publisher,
+	}
+}
+
+// Add saves a message for queueName. Call it with the context of the transaction
+// that makes the change the message announces.
+func (s *OutboxService) Add(ctx context.Context, queueName string, message interface{}) (*models.OutboxEntry, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entry := &models.OutboxEntry{
+		Queue:         queueName,
+		Body:          body,
+		NextAttemptAt: now.Add(outboxGrace),
+		CreatedAt:     now,
+	}
+
+	if err := s.outbox.Add(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Publish publishes an entry right after its transaction commits. On failure the
+// change is still saved, Relay publishes the entry later, and the error wraps
+// ErrEventDelayed.
+func (s *OutboxService) Publish(ctx context.Context, entry *models.OutboxEntry) error {
+	if err := s.publisher.PublishMessage(entry.Queue, json.RawMessage(entry.Body)); err != nil {
+		log.Printf("❌ Failed to publish outbox entry %s, the relay will retry: %v", entry.ID.Hex(), err)
+		return fmt.Errorf("%w: %v", ErrEventDelayed, err)
+	}
+	s.markSent(ctx, entry.ID)
+	return nil
+}
+
+// Relay publishes every unsent entry that is due and returns how many it published.
+// Entries are claimed one at a time, so relays can run in several processes.
+func (s *OutboxService) Relay(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		now := time.Now()
+		entry, err := s.outbox.ClaimDue(ctx, now, now.Add(outboxRetryDelay))
+		if errors.Is(err, repository.ErrNotFound) {
+			return published, nil
+		}
+		if err != nil {
+			return published, err
+		}
+
+		// Stop at the first failure; RabbitMQ is likely down and the claim retries it later
+		if err := s.publisher.PublishMessage(entry.Queue, json.RawMessage(entry.Body)); err != nil {
+			return published, err
+		}
+		if err := s.markSent(ctx, entry.ID); err != nil {
+			return published, err
+		}
+		published++
+	}
+}
+
+func (s *OutboxService) markSent(ctx context.Context, id primitive.ObjectID) error {
+	err := s.outbox.MarkSent(ctx, id, time.Now())
+	if err != nil {
+		log.Printf("❌ Failed to mark outbox entry %s sent: %v", id.Hex(), err)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"expense-split-wise/internal/testutil"
+	"testing"
+	"time"
+)
+
+// addDueEntry saves an outbox entry for queueName that has been due since dueAt,
+// as one whose publish never ran
+func addDueEntry(t *testing.T, s *testServices, queueName, body string, dueAt time.Time) *models.OutboxEntry {
+	t.Helper()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &models.OutboxEntry{Queue: queueName, Body: encoded, NextAttemptAt: dueAt, CreatedAt: dueAt.Add(-outboxGrace)}
+	if err := s.Store.Outbox.Add(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+// unsentEntry returns the unsent entry that is due first an hour from now, as the
+// relay will then see it
+func unsentEntry(t *testing.T, s *testServices) (*models.OutboxEntry, error) {
+	t.Helper()
+
+	later := time.Now().Add(time.Hour)
+	return s.Store.Outbox.ClaimDue(context.Background(), later, later.Add(time.Minute))
+}
+
+func TestRelayPublishesDueEntries(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+
+	now := time.Now()
+	addDueEntry(t, s, testutil.ExpenseQueue, "second", now.Add(-time.Second))
+	addDueEntry(t, s, testutil.ExpenseQueue, "first", now.Add(-time.Minute))
+
+	// Within its grace period an entry is left to the request that wrote it
+	if _, err := s.outbox.Add(ctx, testutil.ExpenseQueue, "fresh"); err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := s.outbox.Relay(ctx)
+	if err != nil || published != 2 {
+		t.Fatalf("Relay = %d, %v, want the 2 due entries published", published, err)
+	}
+	for _, want := range []string{"first", "second"} {
+		var body string
+		if testutil.Receive(t, deliveries, &body); body != want {
+			t.Errorf("relayed %q, want %q", body, want)
+		}
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+
+	// Sent entries are not published again
+	if published, err := s.outbox.Relay(ctx); err != nil || published != 0 {
+		t.Errorf("second Relay = %d, %v, want nothing published", published, err)
+	}
+}
+
+func TestRelayRetriesFailedPublishesLater(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+	addDueEntry(t, s, testutil.ExpenseQueue, "a", time.Now().Add(-time.Second))
+
+	// The broker is down: the relay stops and the entry waits for its retry delay
+	down := NewOutboxService(s.Store.Outbox, downPublisher{})
+	if published, err := down.Relay(ctx); err == nil || published != 0 {
+		t.Fatalf("Relay with the broker down = %d, %v, want an error", published, err)
+	}
+	if published, err := s.outbox.Relay(ctx); err != nil || published != 0 {
+		t.Errorf("Relay before the retry delay = %d, %v, want nothing published", published, err)
+	}
+	testutil.ExpectNoMessage(t, deliveries)
+
+	entry, err := unsentEntry(t, s)
+	if err != nil {
+		t.Fatalf("failed entry not kept for a retry: %v", err)
+	}
+	if entry.Attempts != 1 || entry.NextAttemptAt.Before(time.Now().Add(outboxRetryDelay-time.Second)) {
+		t.Errorf("failed entry = %+v, want 1 attempt and a retry after %v", entry, outboxRetryDelay)
+	}
+}
+
+func TestPublishMarksEntriesSent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+
+	entry, err := s.outbox.Add(ctx, testutil.ExpenseQueue, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.outbox.Publish(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	var body string
+	if testutil.Receive(t, deliveries, &body); body != "a" {
+		t.Errorf("published %q, want %q", body, "a")
+	}
+	if _, err := unsentEntry(t, s); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("published entry left for the relay: %v", err)
+	}
+
+	// A failed publish leaves the entry to the relay
+	entry, err = s.outbox.Add(ctx, testutil.ExpenseQueue, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := NewOutboxService(s.Store.Outbox, downPublisher{})
+	if err := down.Publish(ctx, entry); !errors.Is(err, ErrEventDelayed) {
+		t.Errorf("Publish with the broker down = %v, want ErrEventDelayed", err)
+	}
+	if unsent, err := unsentEntry(t, s); err != nil || unsent.ID != entry.ID {
+		t.Errorf("unsent entry = %v, %v, want %s left for the relay", unsent, err, entry.ID.Hex())
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"expense-split-wise/internal/models"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReportService struct {
+	groupService   *GroupService
+	expenseService *ExpenseService
+}
+
+func NewReportService(groupService *GroupService, expenseService *ExpenseService) *ReportService {
+	return &ReportService{
+		groupService:   groupService,
+		expenseService: expenseService,
+	}
+}
+
+// MemberStatement builds a member's statement for [from, to): the balance carried over
+// from earlier expenses, every expense and settlement in the period that affects the
+// member with their share, and the resulting closing balance
+func (s *ReportService) MemberStatement(ctx context.Context, groupID primitive.ObjectID, member string, from, to time.Time) (*models.MemberStatement, error) {
+	group, err := s.groupService.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	expenses, err := s.expenseService.GetExpensesByGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(expenses, func(i, j int) bool {
+		return expenses[i].CreatedAt.Before(expenses[j].CreatedAt)
+	})
+
+	statement := &models.MemberStatement{
+		GroupID:   groupID,
+		GroupName: group.Name,
+		Member:    member,
+		From:      from,
+		To:        to,
+		Entries:   []models.MemberStatementEntry{},
+	}
+
+	balance := 0.0
+	for i := range expenses {
+		expense := &expenses[i]
+		if !expense.CreatedAt.Before(to) {
+			break
+		}
+
+		effect, involved := ExpenseEffects(expense)[member]
+		if !involved {
+			continue
+		}
+		balance += effect
+
+		if expense.CreatedAt.Before(from) {
+			statement.OpeningBalance = balance
+			continue
+		}
+
+		var share float64
+		for _, participant := range expense.SplitBetween {
+			if participant == member {
+				share = expense.Amount / float64(len(expense.SplitBetween))
+			}
+		}
+
+		statement.Entries = append(statement.Entries, models.MemberStatementEntry{
+			ExpenseID:   expense.ID,
+			Date:        expense.CreatedAt,
+			Description: expense.Description,
+			Category:    expense.Category,
+			PaidBy:      expense.PaidBy,
+			Amount:      expense.Amount,
+			Share:       share,
+			Effect:      effect,
+			Balance:     balance,
+			Settlement:  expense.Category == models.CategoryPayment,
+		})
+	}
+	statement.ClosingBalance = balance
+
+	return statement, nil
+}
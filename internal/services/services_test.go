@@ -0,0 +1,45 @@
+package services
+
+import (
+	"expense-split-wise/internal/cache"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/testutil"
+	"testing"
+)
+
+// testServices are the services wired as the API wires them, in a test environment
+type testServices struct {
+	*testutil.Env
+	outbox        *OutboxService
+	events        *EventService
+	balances      *BalanceService
+	expenses      *ExpenseService
+	notifications *NotificationService
+	groups        *GroupService
+}
+
+func newTestServices(t *testing.T) *testServices {
+	t.Helper()
+
+	env := testutil.NewEnv(t, testutil.ExpenseQueue, testutil.NotificationQueue)
+	store := env.Store
+
+	s := &testServices{Env: env}
+	s.outbox = NewOutboxService(store.Outbox, env.Broker)
+	s.events = NewEventService(store.Expenses, env.Redis)
+	s.balances = NewBalanceService(store.Transactor, store.Expenses, store.Balances, cache.NewLRUCache(100, 0), s.events)
+	s.expenses = NewExpenseService(store.Transactor, store.Expenses, store.Groups, store.Balances, s.outbox, testutil.ExpenseQueue)
+	s.notifications = NewNotificationService(store.Notifications, store.Groups, store.Expenses, s.outbox, env.Broker, testutil.NotificationQueue)
+	s.groups = NewGroupService(store.Transactor, store.Groups, s.outbox, s.notifications)
+	return s
+}
+
+// receiveExpenseMessage acknowledges the next message on the expense queue and returns it
+func receiveExpenseMessage(t *testing.T, deliveries <-chan queue.Delivery) models.ExpenseMessage {
+	t.Helper()
+
+	var message models.ExpenseMessage
+	testutil.Receive(t, deliveries, &message)
+	return message
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/importer"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// matchAmountTolerance is how far a transaction may differ from an expense amount
+	matchAmountTolerance = 0.01
+	// matchWindow is how far apart a transaction and an expense may be dated
+	matchWindow = 3 * 24 * time.Hour
+)
+
+// ErrProposalNotPending is returned when accepting a transaction that is not (or no longer) a pending proposal
+var ErrProposalNotPending = errors.New("transaction is not a pending proposal")
+
+// ErrNotMember is returned when a statement or a proposed split names someone outside the group
+var ErrNotMember = errors.New("not a member of the group")
+
+type StatementService struct {
+	transactor     repository.Transactor
+	statements     repository.StatementRepository
+	expenses       repository.ExpenseRepository
+	groupService   *GroupService
+	expenseService *ExpenseService
+	outbox         *OutboxService
+}
+
+func NewStatementService(transactor repository.Transactor, statements repository.StatementRepository, expenses repository.ExpenseRepository, groupService *GroupService, expenseService *ExpenseService, outbox *OutboxService) *StatementService {
+	return &StatementService{
+		transactor:     transactor,
+		statements:     statements,
+		expenses:       expenses,
+		groupService:   groupService,
+		expenseService: expenseService,
+		outbox:         outbox,
+	}
+}
+
+// ImportStatement stores a member's statement transactions in a group. Spending that
+// matches an expense paid by the member (same amount, close date, similar description)
+// is linked to it; the rest is proposed as new expenses pending review. Credits are
+// ignored, and transactions already uploaded are counted as duplicates before matching,
+// so they cannot claim an expense from a new transaction.
+func (s *StatementService) ImportStatement(ctx context.Context, groupID primitive.ObjectID, member string, transactions []importer.BankTransaction) (*models.StatementImportResult, error) {
+	group, err := s.groupService.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(group.Members, member) {
+		return nil, fmt.Errorf("%w: %s", ErrNotMember, member)
+	}
+
+	result := &models.StatementImportResult{
+		Linked:   []models.StatementTransaction{},
+		Proposed: []models.StatementTransaction{},
+	}
+
+	var externalIDs []string
+	for _, transaction := range transactions {
+		externalIDs = append(externalIDs, transaction.ExternalID)
+	}
+	uploaded, err := s.statements.ExistingExternalIDs(ctx, groupID, member, externalIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var spending []importer.BankTransaction
+	for _, transaction := range transactions {
+		if transaction.Amount >= 0 {
+			result.Ignored++
+			continue
+		}
+		if uploaded[transaction.ExternalID] {
+			result.Duplicates++
+			continue
+		}
+		uploaded[transaction.ExternalID] = true // Repeated within the statement
+		spending = append(spending, transaction)
+	}
+
+	candidates, err := s.unlinkedExpenses(ctx, groupID, member)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range matchTransactions(spending, candidates) {
+		transaction := models.StatementTransaction{
+			GroupID:     groupID,
+			Member:      member,
+			ExternalID:  match.transaction.ExternalID,
+			Date:        match.transaction.Date,
+			Amount:      -match.transaction.Amount,
+			Description: match.transaction.Description,
+			Status:      models.TransactionPending,
+			CreatedAt:   time.Now(),
+		}
+		if match.expense != nil {
+			transaction.Status = models.TransactionLinked
+			transaction.ExpenseID = &match.expense.ID
+			transaction.MatchScore = match.score
+		}
+
+		// Only a concurrent upload of the same statement gets here with a duplicate
+		added, err := s.statements.Add(ctx, &transaction)
+		if err != nil {
+			return nil, err
+		}
+		if !added {
+			result.Duplicates++
+			continue
+		}
+
+		if transaction.Status == models.TransactionLinked {
+			result.Linked = append(result.Linked, transaction)
+		} else {
+			result.Proposed = append(result.Proposed, transaction)
+		}
+	}
+
+	return result, nil
+}
+
+// GetTransactions lists a group's statement transactions, optionally filtered by status
+func (s *StatementService) GetTransactions(ctx context.Context, groupID primitive.ObjectID, status string) ([]models.StatementTransaction, error) {
+	return s.statements.List(ctx, groupID, status)
+}
+
+// ProposalAcceptance accepts a pending proposal, optionally overriding the proposed expense
+type ProposalAcceptance struct {
+	TransactionID primitive.ObjectID
+	Description   string   // Defaults to the statement description
+	SplitBetween  []string // Defaults to every group member
+	Category      string
+}
+
+// AcceptProposals creates an expense for each accepted proposal, paid by the
+// statement's member on the transaction date. The proposals are accepted together
+// in one transaction: if any is not pending (ErrProposalNotPending) or splits the
+// expense with someone outside the group (ErrNotMember), none is. As with
+// ExpenseService.CreateExpense, an error wrapping ErrEventDelayed means the
+// expenses were saved but their events not yet published.
+func (s *StatementService) AcceptProposals(ctx context.Context, groupID primitive.ObjectID, acceptances []ProposalAcceptance) ([]models.Expense, error) {
+	group, err := s.groupService.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, acceptance := range acceptances {
+		for _, member := range acceptance.SplitBetween {
+			if !slices.Contains(group.Members, member) {
+				return nil, fmt.Errorf("%w: %s", ErrNotMember, member)
+			}
+		}
+	}
+
+	var (
+		expenses []models.Expense
+		entries  []*models.OutboxEntry
+	)
+	err = s.transactor.WithTransaction(ctx, func(txCtx context.Context) error {
+		expenses, entries = []models.Expense{}, nil
+		for _, acceptance := range acceptances {
+			transaction, err := s.statements.Get(txCtx, groupID, acceptance.TransactionID)
+			if errors.Is(err, repository.ErrNotFound) {
+				return ErrProposalNotPending
+			}
+			if err != nil {
+				return err
+			}
+			if transaction.Status != models.TransactionPending {
+				return ErrProposalNotPending
+			}
+
+			expense := &models.Expense{
+				GroupID:      groupID,
+				Description:  transaction.Description,
+				Amount:       transaction.Amount,
+				PaidBy:       transaction.Member,
+				SplitBetween: group.Members,
+				Category:     acceptance.Category,
+				CreatedAt:    transaction.Date,
+			}
+			if acceptance.Description != "" {
+				expense.Description = acceptance.Description
+			}
+			if len(acceptance.SplitBetween) > 0 {
+				expense.SplitBetween = acceptance.SplitBetween
+			}
+
+			entry, err := s.expenseService.createExpense(txCtx, expense)
+			if err != nil {
+				return err
+			}
+
+			// Fails if a concurrent review accepted or rejected the proposal meanwhile
+			err = s.statements.Accept(txCtx, groupID, transaction.ID, expense.ID)
+			if errors.Is(err, repository.ErrNotFound) {
+				return ErrProposalNotPending
+			}
+			if err != nil {
+				return err
+			}
+
+			expenses = append(expenses, *expense)
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var delayed error
+	for _, entry := range entries {
+		if err := s.outbox.Publish(ctx, entry); err != nil {
+			delayed = err
+		}
+	}
+	return expenses, delayed
+}
+
+// RejectProposals marks pending proposals as rejected
+func (s *StatementService) RejectProposals(ctx context.Context, groupID primitive.ObjectID, transactionIDs []primitive.ObjectID) error {
+	return s.statements.Reject(ctx, groupID, transactionIDs)
+}
+
+// unlinkedExpenses returns the expenses paid by member that no statement transaction is linked to yet
+func (s *StatementService) unlinkedExpenses(ctx context.Context, groupID primitive.ObjectID, member string) ([]models.Expense, error) {
+	linked, err := s.statements.LinkedExpenseIDs(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.expenses.Find(ctx, repository.ExpenseFilter{GroupID: groupID, PaidBy: member, ExcludeIDs: linked})
+}
+
+// transactionMatch pairs a statement transaction with the expense it matched, if any
+type transactionMatch struct {
+	transaction importer.BankTransaction
+	expense     *models.Expense
+	score       float64
+}
+
+// matchTransactions links each transaction to at most one expense and each expense to at
+// most one transaction, taking the best-scoring pairs first. Only pairs with the same
+// amount within the date window are considered; the score then favours close dates and
+// similar descriptions.
+func matchTransactions(transactions []importer.BankTransaction, expenses []models.Expense) []transactionMatch {
+	type candidate struct {
+		transaction, expense int
+		score                float64
+	}
+
+	var candidates []candidate
+	for i, transaction := range transactions {
+		for j := range expenses {
+			expense := &expenses[j]
+			if math.Abs(-transaction.Amount-expense.Amount) > matchAmountTolerance {
+				continue
+			}
+			gap := transaction.Date.Sub(expense.CreatedAt)
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap > matchWindow {
+				continue
+			}
+
+			dateScore := 1 - float64(gap)/float64(matchWindow)
+			score := 0.5*dateScore + 0.5*descriptionSimilarity(transaction.Description, expense.Description)
+			candidates = append(candidates, candidate{transaction: i, expense: j, score: score})
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].score > candidates[b].score
+	})
+
+	matches := make([]transactionMatch, len(transactions))
+	for i, transaction := range transactions {
+		matches[i].transaction = transaction
+	}
+
+	usedExpenses := make(map[int]bool)
+	for _, c := range candidates {
+		if matches[c.transaction].expense != nil || usedExpenses[c.expense] {
+			continue
+		}
+		usedExpenses[c.expense] = true
+		matches[c.transaction].expense = &expenses[c.expense]
+		matches[c.transaction].score = c.score
+	}
+
+	return matches
+}
+
+// descriptionSimilarity is the Jaccard similarity of the lowercase words of a and b
+func descriptionSimilarity(a, b string) float64 {
+	words := func(s string) map[string]bool {
+		set := make(map[string]bool)
+		for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			set[word] = true
+		}
+		return set
+	}
+
+	wa, wb := words(a), words(b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for word := range wa {
+		if wb[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wa)+len(wb)-shared)
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expense-split-wise/internal/importer"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"expense-split-wise/internal/testutil"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var statementDate = time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+
+// newStatementTest returns a statement service and a group of alice and bob
+func newStatementTest(t *testing.T) (*testServices, *StatementService, *models.Group) {
+	t.Helper()
+
+	s := newTestServices(t)
+	statements := NewStatementService(s.Store.Transactor, s.Store.Statements, s.Store.Expenses, s.groups, s.expenses, s.outbox)
+	group, err := s.groups.CreateGroup(context.Background(), "Flat", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, statements, group
+}
+
+// proposeTransactions uploads spending to alice's statement and returns the proposals
+func proposeTransactions(t *testing.T, statements *StatementService, groupID primitive.ObjectID, descriptions ...string) []models.StatementTransaction {
+	t.Helper()
+
+	var transactions []importer.BankTransaction
+	for _, description := range descriptions {
+		transactions = append(transactions, importer.BankTransaction{ExternalID: description, Date: statementDate, Amount: -12, Description: description})
+	}
+	result, err := statements.ImportStatement(context.Background(), groupID, "alice", transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Proposed) != len(descriptions) {
+		t.Fatalf("proposed %d transactions, want %d", len(result.Proposed), len(descriptions))
+	}
+	return result.Proposed
+}
+
+func TestImportStatementSkipsDuplicatesBeforeMatching(t *testing.T) {
+	ctx := context.Background()
+	s, statements, group := newStatementTest(t)
+
+	groceries := &models.Expense{GroupID: group.ID, Description: "Groceries", Amount: 40, PaidBy: "alice", SplitBetween: []string{"alice", "bob"}, CreatedAt: statementDate}
+	if err := s.expenses.CreateExpense(ctx, groceries); err != nil {
+		t.Fatal(err)
+	}
+
+	first := importer.BankTransaction{ExternalID: "1", Date: statementDate, Amount: -40, Description: "Groceries"}
+	result, err := statements.ImportStatement(ctx, group.ID, "alice", []importer.BankTransaction{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Linked) != 1 {
+		t.Fatalf("linked %d transactions, want 1", len(result.Linked))
+	}
+
+	restock := &models.Expense{GroupID: group.ID, Description: "Groceries", Amount: 40, PaidBy: "alice", SplitBetween: []string{"alice", "bob"}, CreatedAt: statementDate}
+	if err := s.expenses.CreateExpense(ctx, restock); err != nil {
+		t.Fatal(err)
+	}
+
+	// The re-uploaded transaction matches the new expense best, but must not claim it
+	second := importer.BankTransaction{ExternalID: "2", Date: statementDate.Add(48 * time.Hour), Amount: -40, Description: "Shop"}
+	result, err = statements.ImportStatement(ctx, group.ID, "alice", []importer.BankTransaction{first, second, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Duplicates != 2 || len(result.Linked) != 1 || len(result.Proposed) != 0 {
+		t.Fatalf("result = %+v, want 2 duplicates and the new transaction linked", result)
+	}
+	if linked := result.Linked[0]; linked.ExternalID != "2" || *linked.ExpenseID != restock.ID {
+		t.Errorf("linked %s to %s, want 2 linked to %s", linked.ExternalID, linked.ExpenseID.Hex(), restock.ID.Hex())
+	}
+}
+
+func TestImportStatementChecksGroupAndMember(t *testing.T) {
+	ctx := context.Background()
+	_, statements, group := newStatementTest(t)
+
+	if _, err := statements.ImportStatement(ctx, primitive.NewObjectID(), "alice", nil); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("ImportStatement for a missing group = %v, want ErrNotFound", err)
+	}
+	if _, err := statements.ImportStatement(ctx, group.ID, "mallory", nil); !errors.Is(err, ErrNotMember) {
+		t.Errorf("ImportStatement for a non-member = %v, want ErrNotMember", err)
+	}
+}
+
+func TestAcceptProposals(t *testing.T) {
+	ctx := context.Background()
+	s, statements, group := newStatementTest(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+	proposals := proposeTransactions(t, statements, group.ID, "Taxi", "Cinema")
+
+	expenses, err := statements.AcceptProposals(ctx, group.ID, []ProposalAcceptance{
+		{TransactionID: proposals[0].ID, Category: "Transport"},
+		{TransactionID: proposals[1].ID, Description: "Movie night", SplitBetween: []string{"bob"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expenses) != 2 || expenses[0].PaidBy != "alice" || !slices.Equal(expenses[0].SplitBetween, []string{"alice", "bob"}) ||
+		expenses[1].Description != "Movie night" || !slices.Equal(expenses[1].SplitBetween, []string{"bob"}) {
+		t.Fatalf("expenses = %+v", expenses)
+	}
+	for range expenses {
+		if message := receiveExpenseMessage(t, deliveries); message.Event != models.EventExpenseCreated {
+			t.Errorf("message = %+v, want expense.created", message)
+		}
+	}
+
+	accepted, err := statements.GetTransactions(ctx, group.ID, models.TransactionAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 2 || accepted[0].ExpenseID == nil || *accepted[0].ExpenseID != expenses[0].ID {
+		t.Errorf("accepted = %+v, want both linked to their expenses", accepted)
+	}
+}
+
+func TestAcceptProposalsAcceptsAllOrNone(t *testing.T) {
+	ctx := context.Background()
+	s, statements, group := newStatementTest(t)
+	deliveries := s.Consume(t, testutil.ExpenseQueue)
+	proposals := proposeTransactions(t, statements, group.ID, "Taxi", "Cinema")
+
+	if err := statements.RejectProposals(ctx, group.ID, []primitive.ObjectID{proposals[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		acceptances []ProposalAcceptance
+		want        error
+	}{
+		{name: "rejected", acceptances: []ProposalAcceptance{{TransactionID: proposals[0].ID}, {TransactionID: proposals[1].ID}}, want: ErrProposalNotPending},
+		{name: "twice", acceptances: []ProposalAcceptance{{TransactionID: proposals[0].ID}, {TransactionID: proposals[0].ID}}, want: ErrProposalNotPending},
+		{name: "unknown", acceptances: []ProposalAcceptance{{TransactionID: proposals[0].ID}, {TransactionID: primitive.NewObjectID()}}, want: ErrProposalNotPending},
+		{name: "split with a non-member", acceptances: []ProposalAcceptance{{TransactionID: proposals[0].ID, SplitBetween: []string{"alice", "mallory"}}}, want: ErrNotMember},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expenses, err := statements.AcceptProposals(ctx, group.ID, tt.acceptances)
+			if !errors.Is(err, tt.want) || expenses != nil {
+				t.Fatalf("AcceptProposals = %v, %v, want %v and no expenses", expenses, err, tt.want)
+			}
+
+			// Nothing was saved or published
+			saved, err := s.Store.Expenses.ListByGroup(ctx, group.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 0 {
+				t.Errorf("saved %d expenses, want none", len(saved))
+			}
+			pending, err := statements.GetTransactions(ctx, group.ID, models.TransactionPending)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pending) != 1 || pending[0].ID != proposals[0].ID {
+				t.Errorf("pending = %+v, want the taxi still pending", pending)
+			}
+			testutil.ExpectNoMessage(t, deliveries)
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it is marked failed
+	webhookMaxAttempts = 8
+	// webhookBaseBackoff is the delay before the first retry; it doubles on each attempt
+	webhookBaseBackoff = 30 * time.Second
+	// webhookMaxBackoff caps the delay between retries
+	webhookMaxBackoff = time.Hour
+	// webhookDisableAfter consecutive failed attempts disable a subscription
+	webhookDisableAfter = 15
+	// webhookLease is how long a claimed delivery is hidden from other workers
+	webhookLease = time.Minute
+)
+
+// WebhookEvents are the event types a subscription may subscribe to
+var WebhookEvents = []string{
+	models.EventExpenseCreated,
+	models.EventExpenseUpdated,
+	models.EventExpenseDeleted,
+	models.EventSettlementRecorded,
+	models.EventBalancesChanged,
+	models.EventBudgetAlert,
+}
+
+type WebhookService struct {
+	webhooks repository.WebhookRepository
+	expenses repository.ExpenseRepository
+	client   *http.Client
+}
+
+func NewWebhookService(webhooks repository.WebhookRepository, expenses repository.ExpenseRepository) *WebhookService {
+	return &WebhookService{
+		webhooks: webhooks,
+		expenses: expenses,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// CreateSubscription creates an active webhook subscription
+func (s *WebhookService) CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	subscription.Active = true
+	subscription.CreatedAt = time.Now()
+
+	return s.webhooks.CreateSubscription(ctx, subscription)
+}
+
+// GetSubscriptions lists a group's webhook subscriptions
+func (s *WebhookService) GetSubscriptions(ctx context.Context, groupID primitive.ObjectID) ([]models.WebhookSubscription, error) {
+	return s.webhooks.ListSubscriptions(ctx, groupID)
+}
+
+// DeleteSubscription removes a subscription and its delivery log
+func (s *WebhookService) DeleteSubscription(ctx context.Context, groupID, subscriptionID primitive.ObjectID) error {
+	return s.webhooks.DeleteSubscription(ctx, groupID, subscriptionID)
+}
+
+// EnableSubscription re-enables a subscription disabled after repeated failures
+func (s *WebhookService) EnableSubscription(ctx context.Context, groupID, subscriptionID primitive.ObjectID) error {
+	return s.webhooks.EnableSubscription(ctx, groupID, subscriptionID)
+}
+
+// GetDeliveries returns the most recent deliveries of a subscription
+func (s *WebhookService) GetDeliveries(ctx context.Context, groupID, subscriptionID primitive.ObjectID, limit int64) ([]models.WebhookDelivery, error) {
+	return s.webhooks.ListDeliveries(ctx, groupID, subscriptionID, limit)
+}
+
+// Dispatch queues an event for delivery to every active subscription of the group
+// that subscribes to it. Dispatching the same event ID again is a no-op.
+func (s *WebhookService) Dispatch(ctx context.Context, groupID primitive.ObjectID, eventID, eventType string, data interface{}) error {
+	subscriptions, err := s.webhooks.ListActiveSubscriptions(ctx, groupID, eventType)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(models.WebhookEvent{
+		ID:        eventID,
+		Type:      eventType,
+		GroupID:   groupID.Hex(),
+		CreatedAt: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		delivery := &models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			GroupID:        groupID,
+			EventID:        eventID,
+			Event:          eventType,
+			Payload:        string(payload),
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  time.Now(),
+			CreatedAt:      time.Now(),
+		}
+		if err := s.webhooks.AddDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DispatchExpenseEvent dispatches the webhook event for an expense message from the
+// queue. Payments are dispatched as settlement.recorded instead of expense.created.
+func (s *WebhookService) DispatchExpenseEvent(ctx context.Context, groupID primitive.ObjectID, message *models.ExpenseMessage) error {
+	event, data, err := expenseEvent(ctx, s.expenses, message)
+	if err != nil || data == nil {
+		return err
+	}
+
+	eventID := message.EventID
+	if eventID == "" {
+		eventID = fmt.Sprintf("%s:%s", event, message.ExpenseID)
+	}
+
+	return s.Dispatch(ctx, groupID, eventID, event, data)
+}
+
+// DeliverDue attempts every delivery whose next attempt is due and returns how
+// many were attempted. Deliveries are claimed one at a time, so several workers
+// can run this concurrently.
+func (s *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for {
+		now := time.Now()
+		delivery, err := s.webhooks.ClaimDueDelivery(ctx, now, now.Add(webhookLease))
+		if errors.Is(err, repository.ErrNotFound) {
+			return attempted, nil
+		}
+		if err != nil {
+			return attempted, err
+		}
+
+		if err := s.deliver(ctx, delivery); err != nil {
+			return attempted, err
+		}
+		attempted++
+	}
+}
+
+// deliver makes one attempt at a delivery and records the outcome
+func (s *WebhookService) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	subscription, err := s.webhooks.GetSubscription(ctx, delivery.SubscriptionID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && !subscription.Active) {
+		return s.recordAttempt(ctx, delivery, 0, errors.New("subscription deleted or disabled"), true)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, sendErr := s.send(ctx, subscription, delivery)
+	if sendErr == nil {
+		if err := s.webhooks.ResetFailures(ctx, subscription.ID); err != nil {
+			return err
+		}
+		return s.recordAttempt(ctx, delivery, status, nil, false)
+	}
+
+	log.Printf("❌ Webhook delivery %s to %s failed: %v", delivery.ID.Hex(), subscription.URL, sendErr)
+
+	// Disable the subscription once it keeps failing
+	updated, err := s.webhooks.IncrementFailures(ctx, subscription.ID)
+	if err != nil {
+		return err
+	}
+	if updated.Active && updated.ConsecutiveFailures >= webhookDisableAfter {
+		log.Printf("⚠️ Disabling webhook %s after %d consecutive failures", subscription.ID.Hex(), updated.ConsecutiveFailures)
+		if err := s.webhooks.DisableSubscription(ctx, subscription.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return s.recordAttempt(ctx, delivery, status, sendErr, false)
+}
+
+// send posts the payload with its HMAC-SHA256 signature and returns the response status
+func (s *WebhookService) send(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(subscription.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt stores the outcome of an attempt and schedules the next retry with
+// exponential backoff, or marks the delivery failed when retries are exhausted or final is set
+func (s *WebhookService) recordAttempt(ctx context.Context, delivery *models.WebhookDelivery, status int, attemptErr error, final bool) error {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+
+	switch {
+	case attemptErr == nil:
+		delivery.Status = models.DeliverySucceeded
+	case final || delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = attemptErr.Error()
+	default:
+		backoff := webhookBaseBackoff << (delivery.Attempts - 1)
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+		delivery.NextAttemptAt = now.Add(backoff)
+		delivery.LastError = attemptErr.Error()
+	}
+
+	return s.webhooks.UpdateDelivery(ctx, delivery)
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.payload" keyed by secret.
+// Receivers recompute it to verify the X-Webhook-Signature header.
+func SignWebhook(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
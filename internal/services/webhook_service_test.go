@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/repository"
+	"expense-split-wise/internal/testutil"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testWebhookSecret = "s3cret"
+
+// webhookEndpoint is a test server recording the requests it receives and
+// responding with status
+type webhookEndpoint struct {
+	*httptest.Server
+	status atomic.Int32
+
+	mu       sync.Mutex
+	requests []webhookRequest
+}
+
+type webhookRequest struct {
+	header http.Header
+	body   string
+}
+
+func newWebhookEndpoint(t *testing.T, status int) *webhookEndpoint {
+	t.Helper()
+
+	endpoint := &webhookEndpoint{}
+	endpoint.status.Store(int32(status))
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		endpoint.mu.Lock()
+		endpoint.requests = append(endpoint.requests, webhookRequest{header: r.Header.Clone(), body: string(body)})
+		endpoint.mu.Unlock()
+		w.WriteHeader(int(endpoint.status.Load()))
+	}))
+	t.Cleanup(endpoint.Close)
+	return endpoint
+}
+
+func (e *webhookEndpoint) received() []webhookRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]webhookRequest(nil), e.requests...)
+}
+
+// newTestWebhooks returns a webhook service in a test environment with a subscription
+// of a new group to expense.created events delivered to endpoint
+func newTestWebhooks(t *testing.T, endpoint *webhookEndpoint) (*WebhookService, repository.WebhookRepository, *models.WebhookSubscription) {
+	t.Helper()
+
+	store := testutil.NewEnv(t).Store
+	webhooks := NewWebhookService(store.Webhooks, store.Expenses)
+	subscription := &models.WebhookSubscription{
+		GroupID: primitive.NewObjectID(),
+		URL:     endpoint.URL,
+		Secret:  testWebhookSecret,
+		Events:  []string{models.EventExpenseCreated},
+	}
+	if err := webhooks.CreateSubscription(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+	return webhooks, store.Webhooks, subscription
+}
+
+// deliverDue runs DeliverDue and checks how many deliveries it attempted
+func deliverDue(t *testing.T, webhooks *WebhookService, want int) {
+	t.Helper()
+
+	attempted, err := webhooks.DeliverDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempted != want {
+		t.Fatalf("DeliverDue attempted %d deliveries, want %d", attempted, want)
+	}
+}
+
+// onlyDelivery returns the single delivery of a subscription
+func onlyDelivery(t *testing.T, webhooks *WebhookService, subscription *models.WebhookSubscription) models.WebhookDelivery {
+	t.Helper()
+
+	deliveries, err := webhooks.GetDeliveries(context.Background(), subscription.GroupID, subscription.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	ctx := context.Background()
+	endpoint := newWebhookEndpoint(t, http.StatusOK)
+	webhooks, _, subscription := newTestWebhooks(t, endpoint)
+
+	data := map[string]string{"description": "Dinner"}
+	if err := webhooks.Dispatch(ctx, subscription.GroupID, "event-1", models.EventExpenseCreated, data); err != nil {
+		t.Fatal(err)
+	}
+	// Redelivered events and unsubscribed events are not delivered
+	if err := webhooks.Dispatch(ctx, subscription.GroupID, "event-1", models.EventExpenseCreated, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := webhooks.Dispatch(ctx, subscription.GroupID, "event-2", models.EventExpenseDeleted, data); err != nil {
+		t.Fatal(err)
+	}
+	deliverDue(t, webhooks, 1)
+	deliverDue(t, webhooks, 0)
+
+	requests := endpoint.received()
+	if len(requests) != 1 {
+		t.Fatalf("endpoint received %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(request.header.Get("X-Webhook-Timestamp") + "." + request.body))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); request.header.Get("X-Webhook-Signature") != want {
+		t.Errorf("signature = %q, want %q", request.header.Get("X-Webhook-Signature"), want)
+	}
+	if event := request.header.Get("X-Webhook-Event"); event != models.EventExpenseCreated {
+		t.Errorf("event header = %q, want %q", event, models.EventExpenseCreated)
+	}
+
+	delivery := onlyDelivery(t, webhooks, subscription)
+	if delivery.Status != models.DeliverySucceeded || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusOK || delivery.LastError != "" {
+		t.Errorf("delivery = %+v, want succeeded on the first attempt", delivery)
+	}
+	if delivery.Payload != request.body || request.header.Get("X-Webhook-Delivery") != delivery.ID.Hex() {
+		t.Errorf("request %+v does not match delivery %+v", request, delivery)
+	}
+}
+
+func TestDeliverBacksOffAfterServerError(t *testing.T) {
+	ctx := context.Background()
+	endpoint := newWebhookEndpoint(t, http.StatusServiceUnavailable)
+	webhooks, repo, subscription := newTestWebhooks(t, endpoint)
+
+	if err := webhooks.Dispatch(ctx, subscription.GroupID, "event-1", models.EventExpenseCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt, backoff := range []time.Duration{webhookBaseBackoff, 2 * webhookBaseBackoff} {
+		deliverDue(t, webhooks, 1)
+		delivery := onlyDelivery(t, webhooks, subscription)
+		if delivery.Status != models.DeliveryPending || delivery.Attempts != attempt+1 || delivery.ResponseStatus != http.StatusServiceUnavailable || delivery.LastError == "" {
+			t.Fatalf("delivery = %+v, want pending after %d failed attempts", delivery, attempt+1)
+		}
+		if got := delivery.NextAttemptAt.Sub(*delivery.LastAttemptAt); got != backoff {
+			t.Errorf("retry %d scheduled after %v, want %v", attempt+1, got, backoff)
+		}
+
+		// Not due until the backoff passes, so make it due now
+		deliverDue(t, webhooks, 0)
+		delivery.NextAttemptAt = time.Now()
+		if err := repo.UpdateDelivery(ctx, &delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	endpoint.status.Store(http.StatusNoContent)
+	deliverDue(t, webhooks, 1)
+	if delivery := onlyDelivery(t, webhooks, subscription); delivery.Status != models.DeliverySucceeded || delivery.Attempts != 3 || delivery.LastError != "" {
+		t.Errorf("delivery = %+v, want succeeded on the third attempt", delivery)
+	}
+	updated, err := repo.GetSubscription(ctx, subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ConsecutiveFailures != 0 {
+		t.Errorf("consecutive failures = %d after a success, want 0", updated.ConsecutiveFailures)
+	}
+	if len(endpoint.received()) != 3 {
+		t.Errorf("endpoint received %d requests, want 3", len(endpoint.received()))
+	}
+}
+
+func TestDeliverDisablesFailingSubscription(t *testing.T) {
+	ctx := context.Background()
+	endpoint := newWebhookEndpoint(t, http.StatusInternalServerError)
+	webhooks, repo, subscription := newTestWebhooks(t, endpoint)
+
+	// One more event than the failures that disable the subscription
+	for i := 0; i <= webhookDisableAfter; i++ {
+		eventID := primitive.NewObjectID().Hex()
+		if err := webhooks.Dispatch(ctx, subscription.GroupID, eventID, models.EventExpenseCreated, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliverDue(t, webhooks, webhookDisableAfter+1)
+
+	if got := len(endpoint.received()); got != webhookDisableAfter {
+		t.Errorf("endpoint received %d requests, want %d", got, webhookDisableAfter)
+	}
+	disabled, err := repo.GetSubscription(ctx, subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled.Active || disabled.DisabledAt == nil || disabled.ConsecutiveFailures != webhookDisableAfter {
+		t.Errorf("subscription = %+v, want disabled after %d failures", disabled, webhookDisableAfter)
+	}
+
+	// The last delivery is given up rather than sent to the disabled subscription
+	deliveries, err := webhooks.GetDeliveries(ctx, subscription.GroupID, subscription.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]int)
+	for _, delivery := range deliveries {
+		statuses[delivery.Status]++
+	}
+	if statuses[models.DeliveryPending] != webhookDisableAfter || statuses[models.DeliveryFailed] != 1 {
+		t.Errorf("delivery statuses = %v, want %d pending and 1 failed", statuses, webhookDisableAfter)
+	}
+
+	// Disabled subscriptions get no new deliveries until they are enabled again
+	if err := webhooks.Dispatch(ctx, subscription.GroupID, "after", models.EventExpenseCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := webhooks.GetDeliveries(ctx, subscription.GroupID, subscription.ID, 100); len(after) != len(deliveries) {
+		t.Errorf("got %d deliveries after dispatching to a disabled subscription, want %d", len(after), len(deliveries))
+	}
+	if err := webhooks.EnableSubscription(ctx, subscription.GroupID, subscription.ID); err != nil {
+		t.Fatal(err)
+	}
+	enabled, err := repo.GetSubscription(ctx, subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled.Active || enabled.DisabledAt != nil || enabled.ConsecutiveFailures != 0 {
+		t.Errorf("subscription = %+v, want enabled with no failures", enabled)
+	}
+}
@@ -0,0 +1,113 @@
+// Package testutil runs services, handlers and workers in tests on an in-memory
+// store and broker, with Redis served by miniredis
+package testutil
+
+import (
+	"encoding/json"
+	"expense-split-wise/internal/database"
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Queue names used by the services under test
+const (
+	ExpenseQueue      = "expense_added"
+	NotificationQueue = "notifications"
+)
+
+// receiveTimeout is how long Receive waits for a message
+const receiveTimeout = 5 * time.Second
+
+// Env is the environment of a test, torn down when the test ends
+type Env struct {
+	Store  *repository.Store
+	Broker *queue.MemoryBroker
+	Redis  *database.RedisClient
+	Server *miniredis.Miniredis // Serves Redis; Close it to simulate an outage
+}
+
+// NewEnv returns an environment with queues declared on its broker
+func NewEnv(t testing.TB, queues ...string) *Env {
+	t.Helper()
+
+	broker := queue.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+	for _, name := range queues {
+		if err := broker.DeclareQueue(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	redisClient, server := NewRedis(t)
+	return &Env{Store: repository.NewMemoryStore(), Broker: broker, Redis: redisClient, Server: server}
+}
+
+// NewRedis returns a client of a new miniredis server. The client does not retry,
+// so commands fail at once after the server is closed.
+func NewRedis(t testing.TB) (*database.RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	redisClient := &database.RedisClient{Client: redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})}
+	t.Cleanup(func() { redisClient.Close() })
+	return redisClient, server
+}
+
+// Consume consumes a queue of the environment's broker
+func (e *Env) Consume(t testing.TB, queueName string) <-chan queue.Delivery {
+	t.Helper()
+
+	deliveries, err := e.Broker.ConsumeMessages(queueName, queueName+"-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+// Receive acknowledges the next message of deliveries, decodes it into v and returns it
+func Receive(t testing.TB, deliveries <-chan queue.Delivery, v interface{}) queue.Delivery {
+	t.Helper()
+
+	select {
+	case msg := <-deliveries:
+		if err := json.Unmarshal(msg.Body, v); err != nil {
+			t.Fatal(err)
+		}
+		if err := msg.Ack(); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	case <-time.After(receiveTimeout):
+		t.Fatal("no message received")
+		return queue.Delivery{}
+	}
+}
+
+// ExpectNoMessage fails if deliveries has a message waiting
+func ExpectNoMessage(t testing.TB, deliveries <-chan queue.Delivery) {
+	t.Helper()
+
+	select {
+	case msg := <-deliveries:
+		t.Fatalf("unexpected message %s", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Eventually polls condition until it holds, failing after the receive timeout
+func Eventually(t testing.TB, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(receiveTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"expense-split-wise/internal/services"
+	"log"
+	"time"
+)
+
+type DigestWorker struct {
+	digestService *services.DigestService
+	schedule      *services.DigestSchedule
+	interval      time.Duration
+}
+
+func NewDigestWorker(digestService *services.DigestService, schedule *services.DigestSchedule, interval time.Duration) *DigestWorker {
+	return &DigestWorker{
+		digestService: digestService,
+		schedule:      schedule,
+		interval:      interval,
+	}
+}
+
+// Start checks every interval, and once at startup, whether this week's digest is due
+// until ctx is cancelled
+func (w *DigestWorker) Start(ctx context.Context) {
+	log.Printf("🔄 Digest worker started, sending every %s at %02d:%02d %s",
+		w.schedule.Weekday, w.schedule.Hour, w.schedule.Minute, w.schedule.Location)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.digestService.RunDue(ctx, w.schedule, time.Now()); err != nil {
+			log.Printf("❌ Failed to send weekly digests: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,64 +3,129 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"expense-split-wise/internal/models"
 	"expense-split-wise/internal/queue"
 	"expense-split-wise/internal/services"
 	"log"
+	"time"
 
-	"github.com/streadway/amqp"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ExpenseWorker struct {
-	rabbitmq       *queue.RabbitMQClient
-	balanceService *services.BalanceService
-	queueName      string
+	consumer            queue.Consumer
+	balanceService      *services.BalanceService
+	budgetService       *services.BudgetService
+	webhookService      *services.WebhookService
+	eventService        *services.EventService
+	notificationService *services.NotificationService
+	queueName           string
+	concurrency         int
+	prefetch            int
+	metrics             *Metrics
 }
 
-func NewExpenseWorker(rabbitmq *queue.RabbitMQClient, balanceService *services.BalanceService, queueName string) *ExpenseWorker {
+func NewExpenseWorker(consumer queue.Consumer, balanceService *services.BalanceService, budgetService *services.BudgetService, webhookService *services.WebhookService, eventService *services.EventService, notificationService *services.NotificationService, queueName string, concurrency, prefetch int) *ExpenseWorker {
 	return &ExpenseWorker{
-		rabbitmq:       rabbitmq,
-		balanceService: balanceService,
-		queueName:      queueName,
+		consumer:            consumer,
+		balanceService:      balanceService,
+		budgetService:       budgetService,
+		webhookService:      webhookService,
+		eventService:        eventService,
+		notificationService: notificationService,
+		queueName:           queueName,
+		concurrency:         concurrency,
+		prefetch:            prefetch,
+		metrics:             newMetrics(),
 	}
 }
 
-// Start begins consuming messages from the queue
-func (w *ExpenseWorker) Start() error {
-	// Declare the queue
-	if err := w.rabbitmq.DeclareQueue(w.queueName); err != nil {
+// Metrics returns the worker's throughput metrics
+func (w *ExpenseWorker) Metrics() *Metrics {
+	return w.metrics
+}
+
+// expenseConsumer is the worker's consumer tag
+const expenseConsumer = "expense-worker"
+
+// Start consumes messages from the queue until ctx is cancelled, then stops
+// consuming and returns once the messages already received are processed
+func (w *ExpenseWorker) Start(ctx context.Context) error {
+	// Declare the queue with its dead-letter queue; failed messages are retried in the pool
+	if err := w.consumer.DeclareDeadLetterQueue(w.queueName); err != nil {
+		return err
+	}
+
+	// Limit unacknowledged messages, which bounds the messages buffered in the pool
+	if err := w.consumer.SetPrefetch(w.prefetch); err != nil {
 		return err
 	}
 
 	// Start consuming messages
-	messages, err := w.rabbitmq.ConsumeMessages(w.queueName)
+	messages, err := w.consumer.ConsumeMessages(w.queueName, expenseConsumer)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("🔄 Worker started, listening on queue: %s", w.queueName)
-
-	// Process messages continuously
-	forever := make(chan bool)
-
+	// Stop deliveries on shutdown, which ends the loop below
 	go func() {
-		for msg := range messages {
-			w.processMessage(msg)
+		<-ctx.Done()
+		if err := w.consumer.CancelConsumer(expenseConsumer); err != nil {
+			log.Printf("❌ Failed to cancel consumer: %v", err)
 		}
 	}()
 
-	<-forever
-	return nil
+	log.Printf("🔄 Worker started, listening on queue: %s (%d processors, prefetch %d)", w.queueName, w.concurrency, w.prefetch)
+
+	// Process different groups in parallel, and each group's messages in order
+	pool := newPartitionedPool(w.concurrency, w.prefetch, func(msg queue.Delivery, retries int) time.Duration {
+		done := w.metrics.track()
+		retryIn, err := w.processMessage(msg, retries)
+		done(err)
+		return retryIn
+	})
+	depths := pool.depths
+	w.metrics.depths.Store(&depths)
+	go w.metrics.logEvery(ctx, "Expense worker", time.Minute)
+
+	for msg := range messages {
+		pool.dispatch(groupKey(msg), msg)
+	}
+
+	// Finish the messages already dispatched. Groups waiting for a retry are requeued.
+	pool.close()
+	if ctx.Err() != nil {
+		log.Printf("🛑 Worker stopped consuming queue: %s", w.queueName)
+		return nil
+	}
+	return errors.New("consumer channel closed")
 }
 
-// processMessage handles individual expense messages
-func (w *ExpenseWorker) processMessage(msg amqp.Delivery) {
+// groupKey returns the group a message is for, used to keep each group's messages in order
+func groupKey(msg queue.Delivery) string {
+	var expenseMsg struct {
+		GroupID string `json:"groupId"`
+	}
+	json.Unmarshal(msg.Body, &expenseMsg)
+	return expenseMsg.GroupID
+}
+
+// processMessage handles an expense message that the pool has already retried
+// retries times, returning the error of a failed attempt and, if the message is to be
+// retried, the delay before its next attempt.
+//
+// A failing message is retried by the pool, with the delays of the retry policy,
+// rather than through retry queues: the pool parks the group meanwhile, so its later
+// messages are never processed ahead of it while other groups go on. The message is
+// dead-lettered once it has used all its attempts, after which the group's later
+// messages go ahead.
+func (w *ExpenseWorker) processMessage(msg queue.Delivery, retries int) (time.Duration, error) {
 	var expenseMsg models.ExpenseMessage
 	if err := json.Unmarshal(msg.Body, &expenseMsg); err != nil {
 		log.Printf("❌ Failed to unmarshal message: %v", err)
-		msg.Nack(false, false) // Reject and don't requeue
-		return
+		w.consumer.DeadLetter(w.queueName, msg, err)
+		return 0, err
 	}
 
 	log.Printf("📨 Processing expense: %s for group: %s", expenseMsg.ExpenseID, expenseMsg.GroupID)
@@ -69,18 +134,101 @@ func (w *ExpenseWorker) processMessage(msg amqp.Delivery) {
 	groupID, err := primitive.ObjectIDFromHex(expenseMsg.GroupID)
 	if err != nil {
 		log.Printf("❌ Invalid group ID: %v", err)
-		msg.Nack(false, false)
-		return
+		w.consumer.DeadLetter(w.queueName, msg, err)
+		return 0, err
+	}
+
+	err = w.handleExpense(context.Background(), groupID, &expenseMsg)
+	if err == nil {
+		msg.Ack() // Acknowledge successful processing
+		return 0, nil
+	}
+
+	// Messages failed by an earlier worker have used some of their attempts already
+	policy := queue.DefaultRetryPolicy
+	attempts := queue.RetryCount(msg) + retries + 1
+	if attempts >= policy.MaxAttempts {
+		w.consumer.DeadLetter(w.queueName, queue.WithRetryCount(msg, attempts-1), err)
+		return 0, err
+	}
+
+	delay := policy.Delay(attempts)
+	log.Printf("🔁 Retrying expense %s in %s (attempt %d of %d)", expenseMsg.ExpenseID, delay, attempts+1, policy.MaxAttempts)
+	return delay, err
+}
+
+// handleExpense applies an expense message: it updates the balances and budgets,
+// queues webhooks and notifications, and streams the event. Each step but the last
+// is idempotent, so a failed message can be handled again from the start; the event
+// is streamed once all of them succeed, so a retry does not stream it twice.
+func (w *ExpenseWorker) handleExpense(ctx context.Context, groupID primitive.ObjectID, expenseMsg *models.ExpenseMessage) error {
+	// Apply the expense's change to the group's balances
+	if err := w.updateBalances(ctx, groupID, expenseMsg); err != nil {
+		log.Printf("❌ Failed to update balances: %v", err)
+		return err
+	}
+
+	log.Printf("✅ Successfully updated balances for group: %s", expenseMsg.GroupID)
+
+	// Track spending against the group's budgets
+	if expenseID, err := primitive.ObjectIDFromHex(expenseMsg.ExpenseID); err == nil {
+		if err := w.budgetService.ProcessExpense(ctx, groupID, expenseID); err != nil {
+			log.Printf("❌ Failed to check budgets: %v", err)
+			return err
+		}
+	}
+
+	// Queue webhook deliveries for the expense and the new balances
+	if err := w.dispatchWebhooks(ctx, groupID, expenseMsg); err != nil {
+		log.Printf("❌ Failed to dispatch webhooks: %v", err)
+		return err
+	}
+
+	// Email the members involved; sends are deduplicated by event ID
+	if err := w.notificationService.NotifyExpense(ctx, expenseMsg); err != nil {
+		log.Printf("❌ Failed to queue notifications: %v", err)
+		return err
+	}
+
+	// Stream the change to live clients
+	if err := w.eventService.PublishExpenseEvent(ctx, groupID, expenseMsg); err != nil {
+		log.Printf("❌ Failed to publish expense event: %v", err)
+	}
+
+	return nil
+}
+
+// dispatchWebhooks queues the expense event and a balances.changed event.
+// Both are deduplicated by event ID, so a redelivered message is not sent twice.
+func (w *ExpenseWorker) dispatchWebhooks(ctx context.Context, groupID primitive.ObjectID, expenseMsg *models.ExpenseMessage) error {
+	if err := w.webhookService.DispatchExpenseEvent(ctx, groupID, expenseMsg); err != nil {
+		return err
+	}
+
+	balances, err := w.balanceService.GetBalances(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	eventID := expenseMsg.EventID
+	if eventID == "" {
+		eventID = expenseMsg.ExpenseID
+	}
+	return w.webhookService.Dispatch(ctx, groupID, eventID+":balances", models.EventBalancesChanged, balances)
+}
+
+// updateBalances applies the message's balance delta, keyed by expense ID for a new
+// expense and by event ID for an edit or deletion. Messages without a delta fall back
+// to a full recalculation.
+func (w *ExpenseWorker) updateBalances(ctx context.Context, groupID primitive.ObjectID, expenseMsg *models.ExpenseMessage) error {
+	if expenseMsg.Delta == nil {
+		return w.balanceService.RecalculateBalances(ctx, groupID)
 	}
 
-	// Recalculate balances for the group
-	ctx := context.Background()
-	if err := w.balanceService.RecalculateBalances(ctx, groupID); err != nil {
-		log.Printf("❌ Failed to recalculate balances: %v", err)
-		msg.Nack(false, true) // Reject and requeue for retry
-		return
+	key := expenseMsg.ExpenseID
+	if expenseMsg.Event != "" && expenseMsg.Event != models.EventExpenseCreated {
+		key = expenseMsg.Event + ":" + expenseMsg.EventID
 	}
 
-	log.Printf("✅ Successfully recalculated balances for group: %s", expenseMsg.GroupID)
-	msg.Ack(false) // Acknowledge successful processing
+	return w.balanceService.ApplyDelta(ctx, groupID, key, expenseMsg.Seq, expenseMsg.Delta)
 }
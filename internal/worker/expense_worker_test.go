@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"expense-split-wise/internal/cache"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/services"
+	"expense-split-wise/internal/testutil"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// expenseWorkerTest runs an ExpenseWorker in a test environment
+type expenseWorkerTest struct {
+	*testutil.Env
+	expenses *services.ExpenseService
+	budgets  *services.BudgetService
+	webhooks *services.WebhookService
+	events   *services.EventService
+	stopped  chan error // Receives Start's result once the worker stops
+	stop     context.CancelFunc
+}
+
+func startExpenseWorker(t *testing.T) *expenseWorkerTest {
+	t.Helper()
+
+	env := testutil.NewEnv(t, testutil.NotificationQueue)
+	store := env.Store
+	// As the worker does when it starts, so the dead-letter queue can be consumed right away
+	if err := env.Broker.DeclareDeadLetterQueue(testutil.ExpenseQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	eventService := services.NewEventService(store.Expenses, env.Redis)
+	balanceService := services.NewBalanceService(store.Transactor, store.Expenses, store.Balances, cache.NewLRUCache(100, 0), eventService)
+	outboxService := services.NewOutboxService(store.Outbox, env.Broker)
+	notificationService := services.NewNotificationService(store.Notifications, store.Groups, store.Expenses, outboxService, env.Broker, testutil.NotificationQueue)
+	webhooks := services.NewWebhookService(store.Webhooks, store.Expenses)
+	w := &expenseWorkerTest{
+		Env:      env,
+		expenses: services.NewExpenseService(store.Transactor, store.Expenses, store.Groups, store.Balances, outboxService, testutil.ExpenseQueue),
+		budgets:  services.NewBudgetService(store.Budgets, store.Expenses, webhooks),
+		webhooks: webhooks,
+		events:   eventService,
+		stopped:  make(chan error, 1),
+	}
+
+	worker := NewExpenseWorker(env.Broker, balanceService, w.budgets, w.webhooks, eventService, notificationService, testutil.ExpenseQueue, 2, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	w.stop = cancel
+	go func() { w.stopped <- worker.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-w.stopped
+	})
+	return w
+}
+
+func TestExpenseWorkerProcessesExpense(t *testing.T) {
+	ctx := context.Background()
+	w := startExpenseWorker(t)
+	notifications := w.Consume(t, testutil.NotificationQueue)
+
+	group := &models.Group{Name: "Trip", Members: []string{"alice", "bob"}}
+	if err := w.Store.Groups.Create(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	budget := &models.Budget{GroupID: group.ID, Name: "Food", Amount: 100, Period: models.BudgetMonthly, Timezone: "UTC"}
+	if err := w.budgets.CreateBudget(ctx, budget); err != nil {
+		t.Fatal(err)
+	}
+	subscription := &models.WebhookSubscription{
+		GroupID: group.ID,
+		URL:     "http://127.0.0.1/hook",
+		Events:  []string{models.EventExpenseCreated, models.EventBalancesChanged, models.EventBudgetAlert},
+	}
+	if err := w.webhooks.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	dinner := &models.Expense{GroupID: group.ID, Description: "Dinner", Amount: 90, PaidBy: "alice", SplitBetween: []string{"alice", "bob"}}
+	if err := w.expenses.CreateExpense(ctx, dinner); err != nil {
+		t.Fatal(err)
+	}
+
+	// Notifications are queued last, so every earlier step is done once one arrives
+	var notification models.NotificationMessage
+	testutil.Receive(t, notifications, &notification)
+	if notification.Kind != models.NotificationExpenseAdded || notification.ExpenseID != dinner.ID.Hex() {
+		t.Errorf("notification = %+v, want expense_added for the dinner", notification)
+	}
+
+	balance, err := w.Store.Balances.Get(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"alice": 45, "bob": -45}; !maps.Equal(balance.Balances, want) {
+		t.Errorf("balances = %v, want %v", balance.Balances, want)
+	}
+
+	deliveries, err := w.webhooks.GetDeliveries(ctx, group.ID, subscription.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(map[string]models.WebhookDelivery)
+	for _, delivery := range deliveries {
+		events[delivery.Event] = delivery
+	}
+	if _, ok := events[models.EventExpenseCreated]; len(deliveries) != 3 || !ok || events[models.EventBalancesChanged].EventID == "" {
+		t.Errorf("webhook deliveries = %+v, want expense.created, balances.changed and budget.alert", deliveries)
+	}
+
+	var alert struct {
+		Data models.BudgetAlert `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(events[models.EventBudgetAlert].Payload), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.Data.BudgetID != budget.ID.Hex() || alert.Data.Threshold != 80 || alert.Data.Spent != 90 {
+		t.Errorf("alert = %+v, want the 80%% threshold crossed at 90", alert.Data)
+	}
+}
+
+func TestExpenseWorkerDeadLettersInvalidMessages(t *testing.T) {
+	w := startExpenseWorker(t)
+	dlq := w.Consume(t, queue.DeadLetterQueueName(testutil.ExpenseQueue))
+
+	if err := w.Broker.PublishMessage(testutil.ExpenseQueue, models.ExpenseMessage{GroupID: "not a group"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var message models.ExpenseMessage
+	dead := testutil.Receive(t, dlq, &message)
+	if message.GroupID != "not a group" || queue.RetryCount(dead) != 1 || dead.Headers[queue.LastErrorHeader] == nil {
+		t.Errorf("dead-lettered %+v with headers %v, want the invalid message after one attempt", message, dead.Headers)
+	}
+
+	// The worker stops cleanly
+	w.stop()
+	select {
+	case err := <-w.stopped:
+		w.stopped <- err // For the cleanup
+		if err != nil {
+			t.Errorf("Start = %v, want nil after cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not stop")
+	}
+}
+
+func TestExpenseWorkerHoldsGroupWhileRetrying(t *testing.T) {
+	ctx := context.Background()
+	w := startExpenseWorker(t)
+	notifications := w.Consume(t, testutil.NotificationQueue)
+
+	group := &models.Group{Name: "Trip", Members: []string{"alice", "bob"}}
+	if err := w.Store.Groups.Create(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	subscribed, unsubscribe := context.WithCancel(ctx)
+	defer unsubscribe()
+	events, err := w.events.Subscribe(subscribed, group.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Checking a budget in an unknown timezone fails, so the group's messages fail
+	broken := &models.Budget{GroupID: group.ID, Name: "Broken", Amount: 100, Period: models.BudgetMonthly, Timezone: "Nowhere/Unknown"}
+	if err := w.Store.Budgets.Create(ctx, broken); err != nil {
+		t.Fatal(err)
+	}
+
+	first := &models.Expense{GroupID: group.ID, Description: "Dinner", Amount: 10, PaidBy: "alice", SplitBetween: []string{"alice", "bob"}}
+	second := &models.Expense{GroupID: group.ID, Description: "Taxi", Amount: 30, PaidBy: "bob", SplitBetween: []string{"alice", "bob"}}
+	for _, expense := range []*models.Expense{first, second} {
+		if err := w.expenses.CreateExpense(ctx, expense); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first expense reaches the balances before failing on the budget
+	testutil.Eventually(t, "the first expense to be applied", func() bool {
+		balance, err := w.Store.Balances.Get(ctx, group.ID)
+		return err == nil && balance.Balances["alice"] == 5
+	})
+
+	// The second waits behind it rather than going ahead
+	time.Sleep(100 * time.Millisecond)
+	balance, err := w.Store.Balances.Get(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"alice": 5, "bob": -5}; !maps.Equal(balance.Balances, want) {
+		t.Errorf("balances = %v while the first expense is retried, want %v", balance.Balances, want)
+	}
+
+	// Once the retry succeeds both are processed, in order
+	if err := w.budgets.DeleteBudget(ctx, group.ID, broken.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, expense := range []*models.Expense{first, second} {
+		var notification models.NotificationMessage
+		testutil.Receive(t, notifications, &notification)
+		if notification.ExpenseID != expense.ID.Hex() {
+			t.Errorf("notified %s, want %s (%s)", notification.ExpenseID, expense.ID.Hex(), expense.Description)
+		}
+	}
+
+	// Each expense is streamed once, however many attempts it took
+	var streamed []string
+	for len(streamed) < 2 {
+		select {
+		case event := <-events:
+			if event.Event == models.EventExpenseCreated {
+				var expense models.Expense
+				json.Unmarshal(event.Data, &expense)
+				streamed = append(streamed, expense.Description)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("streamed %v, want both expenses", streamed)
+		}
+	}
+	if want := []string{"Dinner", "Taxi"}; !slices.Equal(streamed, want) {
+		t.Errorf("streamed %v, want %v", streamed, want)
+	}
+}
@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics counts the messages a worker handles, to tune its concurrency and prefetch
+type Metrics struct {
+	started        time.Time
+	processed      atomic.Int64
+	failed         atomic.Int64 // Failed attempts, including those retried
+	inFlight       atomic.Int64
+	processingTime atomic.Int64 // Nanoseconds spent handling messages, summed over processors
+	depths         atomic.Pointer[func() []int]
+}
+
+// MetricsSnapshot is the state of a worker's metrics at a point in time
+type MetricsSnapshot struct {
+	UptimeSeconds     float64 `json:"uptimeSeconds"`
+	Processed         int64   `json:"processed"`
+	Failed            int64   `json:"failed"`
+	InFlight          int64   `json:"inFlight"`
+	PerSecond         float64 `json:"perSecond"`         // Messages handled per second since start
+	AvgProcessingMs   float64 `json:"avgProcessingMs"`   // Mean time to handle a message
+	ProcessorsBusy    float64 `json:"processorsBusy"`    // Mean number of processors busy; near the concurrency means add processors
+	PartitionBacklogs []int   `json:"partitionBacklogs"` // Messages waiting per processor; uneven backlogs mean a few busy groups
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{started: time.Now()}
+}
+
+// track records the handling of one message; call the returned function when it is done
+func (m *Metrics) track() func(err error) {
+	start := time.Now()
+	m.inFlight.Add(1)
+	return func(err error) {
+		m.processingTime.Add(int64(time.Since(start)))
+		m.inFlight.Add(-1)
+		if err != nil {
+			m.failed.Add(1)
+		} else {
+			m.processed.Add(1)
+		}
+	}
+}
+
+// Snapshot returns the current metrics
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	uptime := time.Since(m.started)
+	snapshot := MetricsSnapshot{
+		UptimeSeconds: uptime.Seconds(),
+		Processed:     m.processed.Load(),
+		Failed:        m.failed.Load(),
+		InFlight:      m.inFlight.Load(),
+	}
+
+	handled := snapshot.Processed + snapshot.Failed
+	processing := time.Duration(m.processingTime.Load())
+	if uptime > 0 {
+		snapshot.PerSecond = float64(handled) / uptime.Seconds()
+		snapshot.ProcessorsBusy = processing.Seconds() / uptime.Seconds()
+	}
+	if handled > 0 {
+		snapshot.AvgProcessingMs = processing.Seconds() * 1000 / float64(handled)
+	}
+	if depths := m.depths.Load(); depths != nil {
+		snapshot.PartitionBacklogs = (*depths)()
+	}
+
+	return snapshot
+}
+
+// logEvery logs a summary of the metrics every interval, with the throughput over
+// that interval, until ctx is cancelled
+func (m *Metrics) logEvery(ctx context.Context, name string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s := m.Snapshot()
+		handled := s.Processed + s.Failed
+		log.Printf("📊 %s: %.1f msg/s, %d processed, %d failed, %d in flight, %.1f ms avg, %.1f processors busy, backlogs %v",
+			name, float64(handled-previous)/interval.Seconds(), s.Processed, s.Failed, s.InFlight, s.AvgProcessingMs, s.ProcessorsBusy, s.PartitionBacklogs)
+		previous = handled
+	}
+}
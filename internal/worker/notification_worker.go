@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-split-wise/internal/models"
+	"expense-split-wise/internal/notify"
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/services"
+	"log"
+)
+
+type NotificationWorker struct {
+	consumer            queue.Consumer
+	notificationService *services.NotificationService
+	mailer              *notify.Mailer
+	templates           *notify.Templates
+	currency            string
+	queueName           string
+}
+
+func NewNotificationWorker(consumer queue.Consumer, notificationService *services.NotificationService, mailer *notify.Mailer, templates *notify.Templates, currency, queueName string) *NotificationWorker {
+	return &NotificationWorker{
+		consumer:            consumer,
+		notificationService: notificationService,
+		mailer:              mailer,
+		templates:           templates,
+		currency:            currency,
+		queueName:           queueName,
+	}
+}
+
+// notificationConsumer is the worker's consumer tag
+const notificationConsumer = "notification-worker"
+
+// Start consumes notification messages from the queue until ctx is cancelled,
+// then returns once the message being sent is done
+func (w *NotificationWorker) Start(ctx context.Context) error {
+	// Declare the queue with its retry and dead-letter queues
+	if err := w.consumer.DeclareRetryQueues(w.queueName, queue.DefaultRetryPolicy); err != nil {
+		return err
+	}
+
+	// Start consuming messages
+	messages, err := w.consumer.ConsumeMessages(w.queueName, notificationConsumer)
+	if err != nil {
+		return err
+	}
+
+	// Stop deliveries on shutdown, which ends the loop below
+	go func() {
+		<-ctx.Done()
+		if err := w.consumer.CancelConsumer(notificationConsumer); err != nil {
+			log.Printf("❌ Failed to cancel consumer: %v", err)
+		}
+	}()
+
+	log.Printf("🔄 Notification worker started, listening on queue: %s", w.queueName)
+
+	for msg := range messages {
+		w.processMessage(msg)
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("🛑 Notification worker stopped consuming queue: %s", w.queueName)
+		return nil
+	}
+	return errors.New("consumer channel closed")
+}
+
+// processMessage emails every recipient of a notification message
+func (w *NotificationWorker) processMessage(msg queue.Delivery) {
+	var notification models.NotificationMessage
+	if err := json.Unmarshal(msg.Body, &notification); err != nil {
+		log.Printf("❌ Failed to unmarshal notification: %v", err)
+		w.consumer.DeadLetter(w.queueName, msg, err)
+		return
+	}
+
+	ctx := context.Background()
+	notifications, err := w.notificationService.Prepare(ctx, &notification, w.currency)
+	if err != nil {
+		log.Printf("❌ Failed to prepare %s notification: %v", notification.Kind, err)
+		retry(w.consumer, w.queueName, msg, err)
+		return
+	}
+
+	for _, n := range notifications {
+		if err := w.send(ctx, &notification, &n); err != nil {
+			log.Printf("❌ Failed to email %s: %v", n.Recipient, err)
+			retry(w.consumer, w.queueName, msg, err) // Recipients already emailed are skipped on retry
+			return
+		}
+	}
+
+	msg.Ack()
+}
+
+// retry schedules a failed message for a delayed retry, or dead-letters it once it
+// has used all its attempts, so a poison message cannot be redelivered forever
+func retry(consumer queue.Consumer, queueName string, msg queue.Delivery, cause error) {
+	if err := consumer.Retry(queueName, msg, cause); err != nil {
+		log.Printf("❌ Failed to schedule retry, requeued: %v", err)
+	}
+}
+
+// send emails one recipient unless a previous delivery of the message already did
+func (w *NotificationWorker) send(ctx context.Context, notification *models.NotificationMessage, n *services.Notification) error {
+	subject, body, err := w.templates.Render(notification.Kind, &n.Data)
+	if err != nil {
+		return err
+	}
+
+	first, err := w.notificationService.MarkSent(ctx, notification.EventID, n.Recipient)
+	if err != nil || !first {
+		return err
+	}
+
+	if err := w.mailer.Send(n.Email, subject, body); err != nil {
+		w.notificationService.UnmarkSent(ctx, notification.EventID, n.Recipient)
+		return err
+	}
+
+	log.Printf("📧 Sent %s email to %s", notification.Kind, n.Recipient)
+	return nil
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"context"
+	"expense-split-wise/internal/services"
+	"log"
+	"time"
+)
+
+type OutboxRelay struct {
+	outboxService *services.OutboxService
+	interval      time.Duration
+}
+
+func NewOutboxRelay(outboxService *services.OutboxService, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outboxService: outboxService,
+		interval:      interval,
+	}
+}
+
+// Start publishes outbox entries the API could not publish every interval until ctx is cancelled
+func (r *OutboxRelay) Start(ctx context.Context) {
+	log.Printf("🔄 Outbox relay started, polling every %s", r.interval)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			published, err := r.outboxService.Relay(ctx)
+			if published > 0 {
+				log.Printf("📤 Relayed %d outbox entries", published)
+			}
+			if err != nil {
+				log.Printf("❌ Failed to relay outbox entries: %v", err)
+			}
+		}
+	}
+}
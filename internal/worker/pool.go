@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"expense-split-wise/internal/queue"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// partitionedPool handles messages on a fixed number of goroutines. Messages with the
+// same key always go to the same goroutine, so they are handled one at a time and in
+// the order they were dispatched, while different keys are handled in parallel.
+//
+// A handler returns a delay when its message failed and should be handled again
+// after it. The message's key is then parked: its later messages wait behind the
+// failed one, while the partition goes on with other keys. Messages still parked
+// when the pool closes are requeued rather than waited for.
+type partitionedPool struct {
+	partitions []chan keyedDelivery
+	parked     []atomic.Int64 // Messages parked per partition
+	handle     func(msg queue.Delivery, retries int) time.Duration
+	wg         sync.WaitGroup
+}
+
+// keyedDelivery is a message dispatched to a partition with its key
+type keyedDelivery struct {
+	key string
+	msg queue.Delivery
+}
+
+// parkedKey holds a key's messages while its first waits to be retried
+type parkedKey struct {
+	messages []queue.Delivery // The failed message first
+	retries  int              // Times the failed message has been retried
+}
+
+// newPartitionedPool starts size goroutines running handle, which is passed how many
+// times the pool already retried the message. Each partition buffers up to buffer
+// messages, so one slow key does not stop messages for other keys being dispatched;
+// with buffer at least the consumer prefetch, dispatch never blocks.
+func newPartitionedPool(size, buffer int, handle func(msg queue.Delivery, retries int) time.Duration) *partitionedPool {
+	p := &partitionedPool{
+		partitions: make([]chan keyedDelivery, max(size, 1)),
+		parked:     make([]atomic.Int64, max(size, 1)),
+		handle:     handle,
+	}
+	for i := range p.partitions {
+		p.partitions[i] = make(chan keyedDelivery, buffer)
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(i)
+		}()
+	}
+	return p
+}
+
+// run handles the messages of partition i until it is closed
+func (p *partitionedPool) run(i int) {
+	parked := make(map[string]*parkedKey)
+	wake := make(chan string)
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	// process handles a key's messages in order until one asks to be retried, then
+	// parks it with the messages after it
+	process := func(key string, messages []queue.Delivery, retries int) {
+		for j, msg := range messages {
+			delay := p.handle(msg, retries)
+			if delay <= 0 {
+				retries = 0
+				continue
+			}
+
+			parked[key] = &parkedKey{messages: messages[j:], retries: retries + 1}
+			p.parked[i].Add(int64(len(messages) - j))
+			time.AfterFunc(delay, func() {
+				select {
+				case wake <- key:
+				case <-stopped:
+				}
+			})
+			return
+		}
+	}
+
+	for {
+		select {
+		case d, ok := <-p.partitions[i]:
+			if !ok {
+				// Requeue parked messages rather than wait for their retries
+				for _, k := range parked {
+					for _, msg := range k.messages {
+						msg.Nack(true)
+					}
+				}
+				p.parked[i].Store(0)
+				return
+			}
+			if k := parked[d.key]; k != nil {
+				k.messages = append(k.messages, d.msg)
+				p.parked[i].Add(1)
+				continue
+			}
+			process(d.key, []queue.Delivery{d.msg}, 0)
+
+		case key := <-wake:
+			k := parked[key]
+			delete(parked, key)
+			p.parked[i].Add(-int64(len(k.messages)))
+			process(key, k.messages, k.retries)
+		}
+	}
+}
+
+// dispatch queues a message on its key's partition
+func (p *partitionedPool) dispatch(key string, msg queue.Delivery) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p.partitions[h.Sum32()%uint32(len(p.partitions))] <- keyedDelivery{key: key, msg: msg}
+}
+
+// depths returns how many messages are waiting in each partition, parked or not
+func (p *partitionedPool) depths() []int {
+	depths := make([]int, len(p.partitions))
+	for i, partition := range p.partitions {
+		depths[i] = len(partition) + int(p.parked[i].Load())
+	}
+	return depths
+}
+
+// close stops accepting messages, waits for the queued ones to be handled and
+// requeues the parked ones
+func (p *partitionedPool) close() {
+	for _, partition := range p.partitions {
+		close(partition)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"expense-split-wise/internal/queue"
+	"expense-split-wise/internal/testutil"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartitionedPoolKeepsKeyOrder(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+	deliveries := publishAndConsume(t, broker, 6)
+
+	var mu sync.Mutex
+	handled := make(map[string][]string)
+	pool := newPartitionedPool(3, 6, func(msg queue.Delivery, retries int) time.Duration {
+		mu.Lock()
+		handled[groupKey(msg)] = append(handled[groupKey(msg)], msg.MessageID)
+		mu.Unlock()
+		msg.Ack()
+		return 0
+	})
+
+	want := make(map[string][]string)
+	for i := range 6 {
+		msg := <-deliveries
+		key := []string{"a", "b"}[i%2]
+		want[key] = append(want[key], msg.MessageID)
+		pool.dispatch(key, withGroup(msg, key))
+	}
+	pool.close()
+
+	for key, ids := range want {
+		if !slices.Equal(handled[key], ids) {
+			t.Errorf("handled %s as %v, want %v", key, handled[key], ids)
+		}
+	}
+}
+
+func TestPartitionedPoolParksOnlyTheFailingKey(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+	deliveries := publishAndConsume(t, broker, 4)
+
+	var mu sync.Mutex
+	var handled []string
+	failed := false
+	pool := newPartitionedPool(1, 4, func(msg queue.Delivery, retries int) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, fmt.Sprintf("%s/%d", groupKey(msg), retries))
+		if groupKey(msg) == "a" && !failed {
+			failed = true
+			return 50 * time.Millisecond
+		}
+		msg.Ack()
+		return 0
+	})
+
+	// a's first message fails; b's go ahead on the same partition while a's wait
+	for _, key := range []string{"a", "a", "b", "b"} {
+		pool.dispatch(key, withGroup(<-deliveries, key))
+	}
+	testutil.Eventually(t, "every message to be handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 5
+	})
+	pool.close()
+
+	if want := []string{"a/0", "b/0", "b/0", "a/1", "a/0"}; !slices.Equal(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
+
+func TestPartitionedPoolRequeuesParkedMessages(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+	deliveries := publishAndConsume(t, broker, 3)
+	// Received before any is requeued, which would redeliver it ahead of the others
+	received := []queue.Delivery{<-deliveries, <-deliveries, <-deliveries}
+
+	var handled []string
+	pool := newPartitionedPool(1, 3, func(msg queue.Delivery, retries int) time.Duration {
+		handled = append(handled, msg.MessageID)
+		return time.Hour // Fails, and is parked until the pool closes
+	})
+	for _, msg := range received {
+		pool.dispatch("a", msg)
+	}
+	pool.close()
+
+	if len(handled) != 1 {
+		t.Errorf("handled %d messages, want only the first", len(handled))
+	}
+	// All three are back on the queue
+	for range 3 {
+		if msg := <-deliveries; !msg.Redelivered {
+			t.Errorf("message %s not requeued", msg.MessageID)
+		}
+	}
+}
+
+// publishAndConsume publishes n messages to a new queue and returns its deliveries
+func publishAndConsume(t *testing.T, broker *queue.MemoryBroker, n int) <-chan queue.Delivery {
+	t.Helper()
+
+	if err := broker.DeclareQueue("work"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		if err := broker.PublishMessage("work", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliveries, err := broker.ConsumeMessages("work", "pool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+// withGroup returns msg with a body naming group, as groupKey reads it
+func withGroup(msg queue.Delivery, group string) queue.Delivery {
+	msg.Body = []byte(`{"groupId": "` + group + `"}`)
+	return msg
+}
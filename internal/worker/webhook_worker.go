@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"context"
+	"expense-split-wise/internal/services"
+	"log"
+	"time"
+)
+
+type WebhookWorker struct {
+	webhookService *services.WebhookService
+	interval       time.Duration
+}
+
+func NewWebhookWorker(webhookService *services.WebhookService, interval time.Duration) *WebhookWorker {
+	return &WebhookWorker{
+		webhookService: webhookService,
+		interval:       interval,
+	}
+}
+
+// Start delivers due webhooks every interval until ctx is cancelled
+func (w *WebhookWorker) Start(ctx context.Context) {
+	log.Printf("🔄 Webhook worker started, polling every %s", w.interval)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.webhookService.DeliverDue(ctx); err != nil {
+				log.Printf("❌ Failed to deliver webhooks: %v", err)
+			}
+		}
+	}
+}